@@ -0,0 +1,213 @@
+package cdkey
+
+// Request and response bodies of the HTTP API. The dot commands and the /v1
+// API share them; Cmd is only set by the dot commands. The OpenAPI document
+// served at /openapi.json is generated from these types, see openapi.go.
+
+type PackListRequest struct {
+	// Project filters the packs by project, "" is the default project.
+	Project *string `json:"project"`
+}
+
+type PackListResponse struct {
+	Cmd   string     `json:"cmd,omitempty"`
+	Packs []PackInfo `json:"packs"`
+}
+
+type PackAddRequest struct {
+	Name     string `json:"name"`
+	Prefix   string `json:"prefix"`
+	KeyLen   int    `json:"keylen"`
+	PackSize int    `json:"packsize"`
+	Note     string `json:"note"`
+}
+
+// PackRequest names the pack of a command.
+type PackRequest struct {
+	Pack string `json:"pack"`
+}
+
+type PackDisableRequest struct {
+	Pack string `json:"pack"`
+	Msg  string `json:"msg"`
+}
+
+type PackGuardRequest struct {
+	Pack string `json:"pack"`
+
+	// Guard is the new GuardConfig, null restores the default.
+	Guard *GuardConfig `json:"guard"`
+}
+
+type PackExtendRequest struct {
+	Pack  string `json:"pack"`
+	Count int    `json:"count"`
+}
+
+type PackVerifyRequest struct {
+	Pack   string `json:"pack"`
+	Repair bool   `json:"repair"`
+}
+
+// PackResponse is the response of the dot commands changing a pack.
+type PackResponse struct {
+	Cmd  string `json:"cmd,omitempty"`
+	Pack string `json:"pack"`
+}
+
+type PackVerifyResponse struct {
+	Cmd    string       `json:"cmd,omitempty"`
+	Pack   string       `json:"pack"`
+	OK     bool         `json:"ok"`
+	Report VerifyReport `json:"report"`
+}
+
+// KeyListRequest lists the keys of a pack, all of them unless a KeyQuery
+// field is set.
+type KeyListRequest struct {
+	Pack string `json:"pack"`
+	KeyQuery
+}
+
+// KeyListResponse has the listed keys. Total is the number of all keys
+// matching the query, of which Keys is a page.
+type KeyListResponse struct {
+	Cmd   string    `json:"cmd,omitempty"`
+	Pack  string    `json:"pack"`
+	Keys  []KeyInfo `json:"keys"`
+	Total int       `json:"total"`
+}
+
+type KeyFindRequest struct {
+	Key string `json:"key"`
+}
+
+// KeyFindResponse lists the packs visible to the token holding the key.
+type KeyFindResponse struct {
+	Cmd     string     `json:"cmd,omitempty"`
+	Key     string     `json:"key"`
+	Matches []KeyMatch `json:"matches"`
+}
+
+type KeyUseRequest struct {
+	Pack string `json:"pack"`
+	Key  string `json:"key"`
+}
+
+type KeyUseResponse struct {
+	Cmd  string `json:"cmd,omitempty"`
+	Pack string `json:"pack"`
+	Key  string `json:"key"`
+}
+
+// KeyRequest names the key of a command.
+type KeyRequest struct {
+	Pack string `json:"pack"`
+	Key  string `json:"key"`
+}
+
+// KeyResponse is the status of a key after key.check or key.revoke.
+type KeyResponse struct {
+	Cmd    string `json:"cmd,omitempty"`
+	Pack   string `json:"pack"`
+	Key    string `json:"key"`
+	Status string `json:"status"`
+}
+
+type TokenCreateRequest struct {
+	Name     string        `json:"name"`
+	Bindings []RoleBinding `json:"bindings"`
+}
+
+type TokenCreateResponse struct {
+	Cmd string `json:"cmd,omitempty"`
+
+	// Token is the secret of the token. It is only ever returned here.
+	Token     string    `json:"token"`
+	TokenInfo TokenInfo `json:"info"`
+}
+
+type TokenListResponse struct {
+	Cmd    string      `json:"cmd,omitempty"`
+	Tokens []TokenInfo `json:"tokens"`
+}
+
+// IDRequest names the token or webhook of a command.
+type IDRequest struct {
+	ID string `json:"id"`
+}
+
+type IDResponse struct {
+	Cmd string `json:"cmd,omitempty"`
+	ID  string `json:"id"`
+}
+
+type ProjectAddRequest struct {
+	Name     string `json:"name"`
+	MaxPacks int    `json:"maxPacks"`
+	MaxKeys  int    `json:"maxKeys"`
+	Note     string `json:"note"`
+}
+
+// ProjectRequest names the project of a command.
+type ProjectRequest struct {
+	Project string `json:"project"`
+}
+
+type ProjectQuotaRequest struct {
+	Project  string `json:"project"`
+	MaxPacks int    `json:"maxPacks"`
+	MaxKeys  int    `json:"maxKeys"`
+}
+
+// ProjectResponse is the response of the dot commands changing a project.
+type ProjectResponse struct {
+	Cmd     string `json:"cmd,omitempty"`
+	Project string `json:"project"`
+}
+
+type ProjectListResponse struct {
+	Cmd      string        `json:"cmd,omitempty"`
+	Projects []ProjectInfo `json:"projects"`
+}
+
+type AuditQueryResponse struct {
+	Cmd     string        `json:"cmd,omitempty"`
+	Records []AuditRecord `json:"records"`
+}
+
+type WebhookResponse struct {
+	Cmd     string  `json:"cmd,omitempty"`
+	Webhook Webhook `json:"webhook"`
+}
+
+type WebhookListResponse struct {
+	Cmd      string    `json:"cmd,omitempty"`
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type WebhookDeliveriesRequest struct {
+	ID    string `json:"id"`
+	Limit int    `json:"limit"`
+}
+
+type WebhookDeliveriesResponse struct {
+	Cmd        string     `json:"cmd,omitempty"`
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+// DisableBody is the request body of POST /v1/packs/{name}/disable.
+type DisableBody struct {
+	Msg string `json:"msg"`
+}
+
+// ExtendBody is the request body of POST /v1/packs/{name}/extend.
+type ExtendBody struct {
+	Count int `json:"count"`
+}
+
+// QuotaBody is the request body of PUT /v1/projects/{name}/quota.
+type QuotaBody struct {
+	MaxPacks int `json:"maxPacks"`
+	MaxKeys  int `json:"maxKeys"`
+}
@@ -1,82 +1,176 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"text/template"
+	"syscall"
+	"time"
 
 	"github.com/yxpod/cdkey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 var (
-	port = flag.String("p", ":8080", "http port")
-	dir  = flag.String("d", "/home/cdkey", "cdkey db directory")
+	configFile = flag.String("config", os.Getenv("CDKEY_CONFIG"), "TOML config file, see cdkey.example.toml")
+	port       = flag.String("p", ":8080", "http port, overrides listen of the config")
+	grpcPort   = flag.String("grpc", "", "gRPC port, e.g. :9090, disabled if empty, overrides grpc_listen of the config")
+	dir        = flag.String("d", "/home/cdkey", "cdkey db directory, overrides data_dir of the config")
+	auth       = flag.Bool("auth", false, "require API tokens for the HTTP and gRPC API, overrides auth.enabled of the config; without auth only loopback listen addresses are accepted")
 )
 
-type logger struct{}
+func main() {
+	flag.Parse()
 
-func (l logger) Info(v ...interface{}) {
-	log.Println("[INFO] ", fmt.Sprint(v...))
-}
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Println("[APP]   failed load config:", err)
+		os.Exit(1)
+	}
 
-func (l logger) Error(v ...interface{}) {
-	log.Println("[ERROR]", fmt.Sprint(v...))
-}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "p":
+			cfg.Listen = *port
+		case "grpc":
+			cfg.GRPCListen = *grpcPort
+		case "d":
+			cfg.DataDir = *dir
+		case "auth":
+			cfg.Auth.Enabled = *auth
+		}
+	})
+
+	if errs := cfg.validate(); len(errs) > 0 {
+		log.Println("[APP]   invalid config:")
+		for _, e := range errs {
+			log.Println("[APP]     ", e)
+		}
+		os.Exit(1)
+	}
 
-func main() {
-	flag.Parse()
+	if !cfg.Auth.Enabled {
+		log.Println("[APP]   WARNING: auth is disabled, anyone able to connect can remove packs and read keys.")
+	}
 
-	cdkey.SetLogger(&logger{})
+	logger, err := cfg.Log.logger()
+	if err != nil {
+		log.Println("[APP]   failed open log file:", err)
+		os.Exit(1)
+	}
 
-	server := cdkey.NewServer(*dir)
-	if server == nil {
+	server, err := cdkey.NewServerWithOptions(cfg.DataDir, cfg.options(logger))
+	if err != nil {
+		log.Println("[APP]   failed start cdkey server:", err)
 		os.Exit(1)
 	}
 
+	if cfg.Auth.Enabled && len(cfg.authTokens()) == 0 && len(server.ListTokens()) == 0 {
+		token, _, err := server.CreateToken("bootstrap", []cdkey.RoleBinding{{Role: cdkey.RoleAdmin, Packs: "*"}})
+		if err != nil {
+			log.Println("[APP]   failed create bootstrap token:", err)
+			os.Exit(1)
+		}
+		fmt.Println("No API token yet, created admin token:", token)
+	}
+
+	var certs *certReloader
+	if cfg.TLS.CertFile != "" {
+		certs, err = newCertReloader(cfg.TLS)
+		if err != nil {
+			log.Println("[APP]   failed load TLS certificate:", err)
+			os.Exit(1)
+		}
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := certs.reload(); err != nil {
+					log.Println("[APP]   failed reload TLS certificate, keep the old one:", err)
+				}
+			}
+		}()
+	}
+
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
+	m := http.NewServeMux()
+	registerUI(m, cfg.UIPrefix, uiAssets(cfg.StaticDir))
+	m.Handle("/", server.HTTPServeMux())
+
+	hs := &http.Server{Addr: cfg.Listen, Handler: m}
 	go func() {
-		m := http.NewServeMux()
-		m.HandleFunc("/packs", func(w http.ResponseWriter, r *http.Request) {
-			http.ServeFile(w, r, "static/packs.html")
-		})
+		var err error
+		if certs != nil {
+			hs.TLSConfig = certs.tlsConfig()
+			log.Println("[APP]   HTTPS server listen on", cfg.Listen)
+			err = hs.ListenAndServeTLS("", "")
+		} else {
+			log.Println("[APP]   HTTP server listen on", cfg.Listen)
+			err = hs.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 
-		m.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
-			r.ParseForm()
-			packName := r.FormValue("pack")
+	var gs *grpc.Server
+	if cfg.GRPCListen != "" {
+		var opts []grpc.ServerOption
+		if certs != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(certs.tlsConfig())))
+		}
+		gs = server.GRPCServer(opts...)
 
-			keys, err := server.ListKeys(packName)
+		go func() {
+			l, err := net.Listen("tcp", cfg.GRPCListen)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusNotAcceptable)
-				return
+				log.Fatal(err)
 			}
 
-			t, err := template.ParseFiles("static/keys.tpl.html")
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+			log.Println("[APP]   gRPC server listen on", cfg.GRPCListen)
+			if err := gs.Serve(l); err != nil {
+				log.Fatal(err)
 			}
+		}()
+	}
 
-			t.Execute(w, struct {
-				PackName string
-				Keys     []cdkey.KeyInfo
-			}{
-				PackName: packName,
-				Keys:     keys,
-			})
-		})
+	sig := <-c
+	log.Println("[APP]   got", sig, "shutting down, timeout", cfg.ShutdownTimeoutSeconds, "seconds")
 
-		m.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.Dir("static"))))
-		m.Handle("/", server.HTTPServeMux())
+	// Refuse new redemptions and fail /readyz first, then stop taking requests,
+	// let the running ones finish and close the packs last.
+	server.Drain()
 
-		log.Println("[APP]   HTTP server listen on", *port)
-		log.Fatal(http.ListenAndServe(*port, m))
-	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	if err := hs.Shutdown(ctx); err != nil {
+		log.Println("[APP]   HTTP server shutdown:", err)
+	}
+	if gs != nil {
+		stopped := make(chan struct{})
+		go func() {
+			gs.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			gs.Stop()
+		}
+	}
 
-	<-c
-	server.Stop()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("[APP]   cdkey server shutdown:", err)
+	}
+	log.Println("[APP]   stopped")
 }
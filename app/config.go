@@ -0,0 +1,453 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/yxpod/cdkey"
+)
+
+// Config is the configuration of the server binary, read from a TOML file,
+// see cdkey.example.toml. Fields with an env tag can be overridden by that
+// environment variable, and the command line flags override both.
+type Config struct {
+	Listen      string `toml:"listen" env:"CDKEY_LISTEN"`
+	GRPCListen  string `toml:"grpc_listen" env:"CDKEY_GRPC_LISTEN"`
+	DataDir     string `toml:"data_dir" env:"CDKEY_DATA_DIR"`
+	StaticDir   string `toml:"static_dir" env:"CDKEY_STATIC_DIR"` // web UI files, embedded if empty
+	UIPrefix    string `toml:"ui_prefix" env:"CDKEY_UI_PREFIX"`
+	MinDiskFree uint64 `toml:"min_disk_free" env:"CDKEY_MIN_DISK_FREE"`
+
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For header gives the client address.
+	TrustedProxies []string `toml:"trusted_proxies" env:"CDKEY_TRUSTED_PROXIES"`
+
+	// ShutdownTimeoutSeconds bounds the wait for running requests on
+	// SIGINT or SIGTERM.
+	ShutdownTimeoutSeconds int `toml:"shutdown_timeout_seconds" env:"CDKEY_SHUTDOWN_TIMEOUT_SECONDS"`
+
+	TLS     TLSConfig     `toml:"tls"`
+	Auth    AuthConfig    `toml:"auth"`
+	Guard   GuardConfig   `toml:"guard"`
+	Audit   AuditConfig   `toml:"audit"`
+	Log     LogConfig     `toml:"log"`
+	Storage StorageConfig `toml:"storage"`
+}
+
+// TLSConfig enables HTTPS if both files are set. The files are read again on
+// SIGHUP.
+type TLSConfig struct {
+	CertFile string `toml:"cert_file" env:"CDKEY_TLS_CERT_FILE"`
+	KeyFile  string `toml:"key_file" env:"CDKEY_TLS_KEY_FILE"`
+
+	// ClientCAFile enables client certificates signed by these CAs, which
+	// are required if ClientAuth is "require" and optional otherwise.
+	ClientCAFile string `toml:"client_ca_file" env:"CDKEY_TLS_CLIENT_CA_FILE"`
+	ClientAuth   string `toml:"client_auth" env:"CDKEY_TLS_CLIENT_AUTH"` // optional or require
+
+	// Clients map certificate common names to API permissions.
+	Clients []cdkey.ClientCert `toml:"clients"`
+}
+
+type AuthConfig struct {
+	Enabled bool `toml:"enabled" env:"CDKEY_AUTH"`
+
+	// AdminToken is the secret of a static admin token on all packs, meant
+	// to be given by the environment rather than the file.
+	AdminToken string `toml:"admin_token" env:"CDKEY_AUTH_ADMIN_TOKEN"`
+
+	Tokens []cdkey.StaticToken `toml:"tokens"`
+}
+
+// GuardConfig is cdkey.GuardConfig with TOML names, the default brute-force
+// protection of packs.
+type GuardConfig struct {
+	Disabled          bool `toml:"disabled" env:"CDKEY_GUARD_DISABLED"`
+	RatePerMinute     int  `toml:"rate_per_minute" env:"CDKEY_GUARD_RATE_PER_MINUTE"`
+	Burst             int  `toml:"burst" env:"CDKEY_GUARD_BURST"`
+	MaxFailures       int  `toml:"max_failures" env:"CDKEY_GUARD_MAX_FAILURES"`
+	LockoutSeconds    int  `toml:"lockout_seconds" env:"CDKEY_GUARD_LOCKOUT_SECONDS"`
+	MaxLockoutSeconds int  `toml:"max_lockout_seconds" env:"CDKEY_GUARD_MAX_LOCKOUT_SECONDS"`
+}
+
+// AuditConfig is cdkey.AuditConfig with TOML names.
+type AuditConfig struct {
+	MaxSize          int64 `toml:"max_size" env:"CDKEY_AUDIT_MAX_SIZE"`
+	MaxAgeHours      int   `toml:"max_age_hours" env:"CDKEY_AUDIT_MAX_AGE_HOURS"`
+	MaxFiles         int   `toml:"max_files" env:"CDKEY_AUDIT_MAX_FILES"`
+	AggregateSeconds int   `toml:"aggregate_seconds" env:"CDKEY_AUDIT_AGGREGATE_SECONDS"`
+}
+
+type LogConfig struct {
+	Level  string `toml:"level" env:"CDKEY_LOG_LEVEL"`   // debug, info, warn or error
+	Format string `toml:"format" env:"CDKEY_LOG_FORMAT"` // text or json
+	File   string `toml:"file" env:"CDKEY_LOG_FILE"`     // stderr if empty
+}
+
+type StorageConfig struct {
+	Backend string `toml:"backend" env:"CDKEY_STORAGE_BACKEND"` // only leveldb
+}
+
+func defaultConfig() Config {
+	g := cdkey.DefaultGuardConfig
+	return Config{
+		Listen:   ":8080",
+		DataDir:  "/home/cdkey",
+		UIPrefix: "/",
+
+		ShutdownTimeoutSeconds: 30,
+		Guard: GuardConfig{
+			Disabled:          g.Disabled,
+			RatePerMinute:     g.RatePerMinute,
+			Burst:             g.Burst,
+			MaxFailures:       g.MaxFailures,
+			LockoutSeconds:    g.LockoutSeconds,
+			MaxLockoutSeconds: g.MaxLockoutSeconds,
+		},
+		TLS: TLSConfig{
+			ClientAuth: "optional",
+		},
+		Audit: AuditConfig{
+			MaxSize:          64 << 20,
+			AggregateSeconds: 60,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Storage: StorageConfig{
+			Backend: "leveldb",
+		},
+	}
+}
+
+// loadConfig reads the config file at path, if not empty, over the defaults
+// and applies the environment overrides. It does not validate the result.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		md, err := toml.DecodeFile(path, &cfg)
+		if err != nil {
+			return cfg, fmt.Errorf("config %v: %v", path, err)
+		}
+		if keys := md.Undecoded(); len(keys) > 0 {
+			return cfg, fmt.Errorf("config %v: unknown keys %v", path, keys)
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem()); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// applyEnv sets the fields with an env tag from the environment, recursing
+// into nested structs.
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if f.Type.Kind() == reflect.Struct {
+			if err := applyEnv(fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := f.Tag.Get("env")
+		s, ok := os.LookupEnv(name)
+		if name == "" || !ok {
+			continue
+		}
+
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(s)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("env %v: bad bool %q", name, s)
+			}
+			fv.SetBool(b)
+		case reflect.Int:
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("env %v: bad number %q", name, s)
+			}
+			fv.SetInt(int64(n))
+		case reflect.Int64:
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return fmt.Errorf("env %v: bad number %q", name, s)
+			}
+			fv.SetInt(n)
+		case reflect.Uint64:
+			n, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return fmt.Errorf("env %v: bad number %q", name, s)
+			}
+			fv.SetUint(n)
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() != reflect.String {
+				return fmt.Errorf("env %v: unsupported type %v", name, fv.Type())
+			}
+			var list []string
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			fv.Set(reflect.ValueOf(list))
+		}
+	}
+	return nil
+}
+
+// validate returns all problems of the config, to be reported at once.
+func (c Config) validate() []string {
+	var errs []string
+	fail := func(format string, v ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, v...))
+	}
+
+	if c.Listen == "" {
+		fail("listen: empty")
+	}
+	if c.DataDir == "" {
+		fail("data_dir: empty")
+	}
+
+	if !strings.HasPrefix(c.UIPrefix, "/") || !strings.HasSuffix(c.UIPrefix, "/") {
+		fail("ui_prefix: %q must start and end with /", c.UIPrefix)
+	}
+	if c.StaticDir != "" {
+		if f, err := os.Stat(c.StaticDir); err != nil {
+			fail("static_dir: %v", err)
+		} else if !f.IsDir() {
+			fail("static_dir: %v is not a directory", c.StaticDir)
+		}
+	}
+
+	if c.ShutdownTimeoutSeconds <= 0 {
+		fail("shutdown_timeout_seconds: must be positive")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls: cert_file and key_file must be set together")
+	}
+	if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		fail("tls: client_ca_file needs cert_file and key_file")
+	}
+	if c.TLS.ClientAuth != "optional" && c.TLS.ClientAuth != "require" {
+		fail("tls.client_auth: %q is not optional or require", c.TLS.ClientAuth)
+	}
+	for _, f := range []string{c.TLS.CertFile, c.TLS.KeyFile, c.TLS.ClientCAFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			fail("tls: %v", err)
+		}
+	}
+
+	if !c.Auth.Enabled && (c.Auth.AdminToken != "" || len(c.Auth.Tokens) > 0) {
+		fail("auth: tokens given but auth is not enabled")
+	}
+	// Fail closed: without auth anyone reaching the port can remove packs
+	// and dump keys.
+	if !c.Auth.Enabled {
+		if c.Listen != "" && !loopback(c.Listen) {
+			fail("listen: %q serves other hosts without auth, enable auth or listen on a loopback address, e.g. 127.0.0.1:8080", c.Listen)
+		}
+		if c.GRPCListen != "" && !loopback(c.GRPCListen) {
+			fail("grpc_listen: %q serves other hosts without auth, enable auth or listen on a loopback address, e.g. 127.0.0.1:9090", c.GRPCListen)
+		}
+	}
+	names := make(map[string]bool)
+	for i, t := range c.authTokens() {
+		if t.Name == "" {
+			fail("auth.tokens[%v]: name empty", i)
+		} else if names[t.Name] {
+			fail("auth.tokens[%v]: duplicate name %v", i, t.Name)
+		}
+		names[t.Name] = true
+
+		if len(t.Secret) < 16 {
+			fail("auth.tokens[%v]: secret shorter than 16 characters", i)
+		}
+		if len(t.Bindings) == 0 {
+			fail("auth.tokens[%v]: no bindings", i)
+		}
+	}
+
+	if len(c.TLS.Clients) > 0 {
+		if c.TLS.ClientCAFile == "" {
+			fail("tls.clients: client_ca_file not set")
+		}
+		if !c.Auth.Enabled {
+			fail("tls.clients: auth is not enabled")
+		}
+	}
+	names = make(map[string]bool)
+	for i, cc := range c.TLS.Clients {
+		if cc.Name == "" {
+			fail("tls.clients[%v]: name empty", i)
+		} else if names[cc.Name] {
+			fail("tls.clients[%v]: duplicate name %v", i, cc.Name)
+		}
+		names[cc.Name] = true
+
+		if len(cc.Bindings) == 0 {
+			fail("tls.clients[%v]: no bindings", i)
+		}
+	}
+
+	for i, p := range c.TrustedProxies {
+		if _, err := parsePrefix(p); err != nil {
+			fail("trusted_proxies[%v]: %v", i, err)
+		}
+	}
+
+	g := c.Guard
+	if g.RatePerMinute < 0 || g.Burst < 0 || g.MaxFailures < 0 || g.LockoutSeconds < 0 || g.MaxLockoutSeconds < 0 {
+		fail("guard: negative value")
+	}
+	if !g.Disabled && g.RatePerMinute == 0 {
+		fail("guard: rate_per_minute is 0, set disabled instead")
+	}
+
+	if a := c.Audit; a.MaxSize <= 0 || a.MaxAgeHours < 0 || a.MaxFiles < 0 {
+		fail("audit: max_size must be positive, max_age_hours and max_files not negative")
+	}
+
+	if _, err := c.Log.level(); err != nil {
+		fail("log.level: %v", err)
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		fail("log.format: %q is not text or json", c.Log.Format)
+	}
+
+	if c.Storage.Backend != "leveldb" {
+		fail("storage.backend: unknown backend %q", c.Storage.Backend)
+	}
+
+	return errs
+}
+
+// authTokens returns the configured tokens, with AdminToken as the token
+// named "admin".
+func (c Config) authTokens() []cdkey.StaticToken {
+	tokens := c.Auth.Tokens
+	if c.Auth.AdminToken != "" {
+		tokens = append(tokens[:len(tokens):len(tokens)], cdkey.StaticToken{
+			Name:     "admin",
+			Secret:   c.Auth.AdminToken,
+			Bindings: []cdkey.RoleBinding{{Role: cdkey.RoleAdmin, Packs: "*"}},
+		})
+	}
+	return tokens
+}
+
+func (c LogConfig) level() (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(c.Level))
+	return l, err
+}
+
+// logger returns the slog logger configured by c.
+func (c LogConfig) logger() (*slog.Logger, error) {
+	level, err := c.level()
+	if err != nil {
+		return nil, err
+	}
+
+	out := os.Stderr
+	if c.File != "" {
+		out, err = os.OpenFile(c.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if c.Format == "json" {
+		return slog.New(slog.NewJSONHandler(out, opts)), nil
+	}
+	return slog.New(slog.NewTextHandler(out, opts)), nil
+}
+
+// parsePrefix parses a CIDR range or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	return netip.ParsePrefix(s)
+}
+
+// aggregateWindow returns the cdkey.AuditConfig.AggregateWindow of seconds,
+// where 0 disables the aggregation.
+func aggregateWindow(seconds int) time.Duration {
+	if seconds <= 0 {
+		return -1
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// options returns the cdkey.Options of the config.
+func (c Config) options(l *slog.Logger) cdkey.Options {
+	var proxies []netip.Prefix
+	for _, p := range c.TrustedProxies {
+		if prefix, err := parsePrefix(p); err == nil {
+			proxies = append(proxies, prefix.Masked())
+		}
+	}
+
+	g := c.Guard
+	return cdkey.Options{
+		StructuredLogger: cdkey.NewSlogLogger(l),
+		Storage:          cdkey.LevelDBStorage{},
+		Auth:             c.Auth.Enabled,
+		Tokens:           c.authTokens(),
+		ClientCerts:      c.TLS.Clients,
+		Guard: &cdkey.GuardConfig{
+			Disabled:          g.Disabled,
+			RatePerMinute:     g.RatePerMinute,
+			Burst:             g.Burst,
+			MaxFailures:       g.MaxFailures,
+			LockoutSeconds:    g.LockoutSeconds,
+			MaxLockoutSeconds: g.MaxLockoutSeconds,
+		},
+		Audit: cdkey.AuditConfig{
+			MaxSize:         c.Audit.MaxSize,
+			MaxAge:          time.Duration(c.Audit.MaxAgeHours) * time.Hour,
+			MaxFiles:        c.Audit.MaxFiles,
+			AggregateWindow: aggregateWindow(c.Audit.AggregateSeconds),
+		},
+		MinDiskFree:    c.MinDiskFree,
+		TrustedProxies: proxies,
+	}
+}
+
+// loopback reports whether the listen address addr is on the loopback
+// interface only.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
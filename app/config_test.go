@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestValidateListenWithoutAuth(t *testing.T) {
+	for _, c := range []struct {
+		listen string
+		auth   bool
+		ok     bool
+	}{
+		{"127.0.0.1:8080", false, true},
+		{"localhost:8080", false, true},
+		{"[::1]:8080", false, true},
+		{":8080", false, false},
+		{"0.0.0.0:8080", false, false},
+		{"10.0.0.1:8080", false, false},
+		{":8080", true, true},
+	} {
+		cfg := defaultConfig()
+		cfg.Listen = c.listen
+		cfg.Auth.Enabled = c.auth
+		if c.auth {
+			cfg.Auth.AdminToken = "0123456789abcdef"
+		}
+		if ok := len(cfg.validate()) == 0; ok != c.ok {
+			t.Errorf("listen %q, auth %v: %v, want ok %v", c.listen, c.auth, cfg.validate(), c.ok)
+		}
+	}
+}
@@ -1,5 +1,76 @@
 var app = angular.module("cdkey", [])
 
+// The server accepts the cdkey_token cookie only with this header, which other
+// sites can not send, see tokenFromRequest in auth.go.
+app.config(function($httpProvider) {
+    $httpProvider.defaults.headers.common["X-Requested-With"] = "XMLHttpRequest"
+})
+
+var keyStatuses = ["Ready", "Used", "Reserved", "Revoked"]
+
+app.filter("encodeURIComponent", function() {
+    return window.encodeURIComponent
+})
+
+// query returns the value of a parameter of the page URL.
+function query(name) {
+    var m = new RegExp("[?&]" + name + "=([^&]*)").exec(window.location.search)
+    return m ? decodeURIComponent(m[1].replace(/\+/g, " ")) : ""
+}
+
+// downloadKeys lists the keys of a pack matching q through key.list and saves
+// them as a CSV file.
+function downloadKeys($http, pack, q, err) {
+    var req = {pack:pack, status:q.status || "", match:q.match || ""}
+    $http.post("/key.list", req).success(function(data) {
+        var lines = ["key,status"]
+        angular.forEach(data.keys, function(k) {
+            lines.push(k.key + "," + k.status)
+        })
+
+        var name = pack.replace(/\//g, "_") + (req.status ? "-" + req.status : "") + ".csv"
+        var a = document.createElement("a")
+        a.href = URL.createObjectURL(new Blob([lines.join("\n") + "\n"], {type:"text/csv"}))
+        a.download = name
+        document.body.appendChild(a)
+        a.click()
+        document.body.removeChild(a)
+        URL.revokeObjectURL(a.href)
+    }).error(err)
+}
+
+// errHandler asks for an API token on 401 and alerts other errors.
+function errHandler(retry) {
+    return function(data, status) {
+        if (status == 401) {
+            var token = prompt("API token")
+            if (token) {
+                document.cookie = "cdkey_token=" + encodeURIComponent(token) + "; path=/; SameSite=Strict"
+                retry()
+            }
+            return
+        }
+        if (data && data.code) {
+            alert("code " + data.code + "\n" + data.msg)
+        } else {
+            alert("HTTP " + status)
+        }
+    }
+}
+
+// progress adds the shares of used, reserved and revoked keys in percent to a
+// pack, for the progress bar.
+function progress(pack) {
+    var st = pack.stats || {ready:0, used:0, reserved:0, revoked:0}
+    var total = st.ready + st.used + st.reserved + st.revoked
+    var pct = function(n) { return total ? (100 * n / total).toFixed(1) : 0 }
+
+    pack.stats = st
+    pack.total = total
+    pack.pct = {used:pct(st.used), reserved:pct(st.reserved), revoked:pct(st.revoked)}
+    return pack
+}
+
 app.controller("packctl", function($scope, $http) {
     $scope.addPack = function() {
     	$scope.add.keylen = Number($scope.add.keylen)
@@ -11,8 +82,13 @@ app.controller("packctl", function($scope, $http) {
     	}).error($scope.err)
     }
 
-    $scope.deletePack = function(name) {
-        $http.post("/pack.remove", {pack:name}).success(function(data) {
+    $scope.deletePack = function(pack) {
+        var msg = "Delete pack " + pack.name + " with all its " + pack.total + " keys?\n" +
+            pack.stats.used + " keys are used. This can not be undone."
+        if (!confirm(msg)) {
+            return
+        }
+        $http.post("/pack.remove", {pack:pack.name}).success(function(data) {
             $scope.reload()
         }).error($scope.err)
     }
@@ -24,20 +100,99 @@ app.controller("packctl", function($scope, $http) {
     }
 
     $scope.disablePack = function(name) {
-        $http.post("/pack.disable", {pack:name, msg:"disabled"}).success(function(data) {
+        var msg = prompt("Disable pack " + name + "? Redemptions will fail with this message:", "disabled")
+        if (msg === null) {
+            return
+        }
+        $http.post("/pack.disable", {pack:name, msg:msg || "disabled"}).success(function(data) {
             $scope.reload()
         }).error($scope.err)
     }
 
+    $scope.reloadPack = function(name) {
+        $http.post("/pack.reload", {pack:name}).success(function(data) {
+            $scope.reload()
+        }).error($scope.err)
+    }
+
+    $scope.exportPack = function(name, status) {
+        downloadKeys($http, name, {status:status}, $scope.err)
+    }
+
+    $scope.findKey = function() {
+        if (!$scope.search) {
+            $scope.found = null
+            return
+        }
+    	$http.post("/key.find", {key:$scope.search}).success(function(data) {
+    		$scope.found = data
+    	}).error($scope.err)
+    }
+
     $scope.reload = function() {
-    	$http.post("/pack.list").success(function(data) {
-    		$scope.packs = data.packs
+        var req = $scope.project ? {project:$scope.project} : undefined
+    	$http.post("/pack.list", req).success(function(data) {
+    		$scope.packs = (data.packs || []).map(progress)
+    	}).error($scope.err)
+    	$http.post("/project.list").success(function(data) {
+    		$scope.projects = data.projects
     	}).error($scope.err)
     }
 
-    $scope.err = function(data) {
-    	alert("code " + data.code + "\n" + data.msg)
+    $scope.err = errHandler(function() { $scope.reload() })
+
+    $scope.reload()
+})
+
+app.controller("keysctl", function($scope, $http) {
+    $scope.statuses = keyStatuses
+    $scope.pack = query("pack")
+    $scope.q = {status:query("status"), match:query("match"), offset:0, limit:100}
+
+    $scope.reload = function() {
+    	$http.get("/v1/packs/" + encodeURIComponent($scope.pack)).success(function(data) {
+    		$scope.info = progress(data)
+    	}).error($scope.err)
+
+        var req = angular.extend({pack:$scope.pack}, $scope.q)
+    	$http.post("/key.list", req).success(function(data) {
+    		$scope.keys = data.keys || []
+    		$scope.total = data.total
+    	}).error($scope.err)
+    }
+
+    $scope.filter = function() {
+        $scope.q.offset = 0
+        $scope.reload()
+    }
+
+    $scope.page = function(dir) {
+        var offset = $scope.q.offset + dir * $scope.q.limit
+        if (offset < 0 || offset >= $scope.total) {
+            return
+        }
+        $scope.q.offset = offset
+        $scope.reload()
+    }
+
+    $scope.last = function() {
+        return Math.min($scope.q.offset + $scope.q.limit, $scope.total)
+    }
+
+    $scope.revokeKey = function(key) {
+        if (!confirm("Revoke key " + key + " of pack " + $scope.pack + "? It can not be used anymore.")) {
+            return
+        }
+        $http.post("/key.revoke", {pack:$scope.pack, key:key}).success(function(data) {
+            $scope.reload()
+        }).error($scope.err)
     }
 
+    $scope.download = function() {
+        downloadKeys($http, $scope.pack, $scope.q, $scope.err)
+    }
+
+    $scope.err = errHandler(function() { $scope.reload() })
+
     $scope.reload()
-})
\ No newline at end of file
+})
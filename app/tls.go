@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// certReloader serves the certificate and client CAs of a TLSConfig, which
+// are read again by reload, e.g. on SIGHUP. A failed reload keeps the files
+// loaded before.
+type certReloader struct {
+	cfg TLSConfig
+
+	mtx       sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newCertReloader(cfg TLSConfig) (*certReloader, error) {
+	r := &certReloader{cfg: cfg}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	var pool *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		b, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificates in %v", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mtx.Lock()
+	r.cert = &cert
+	r.clientCAs = pool
+	r.mtx.Unlock()
+
+	if cert.Leaf != nil {
+		log.Println("[APP]   TLS certificate loaded, subject:", cert.Leaf.Subject, "expires:", cert.Leaf.NotAfter)
+	}
+	return nil
+}
+
+// tlsConfig returns the tls.Config of the HTTP and gRPC servers. Every
+// handshake gets the files loaded last.
+func (r *certReloader) tlsConfig() *tls.Config {
+	clientAuth := tls.NoClientCert
+	if r.cfg.ClientCAFile != "" {
+		clientAuth = tls.VerifyClientCertIfGiven
+		if r.cfg.ClientAuth == "require" {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mtx.RLock()
+			defer r.mtx.RUnlock()
+
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientCAs:    r.clientCAs,
+				ClientAuth:   clientAuth,
+				NextProtos:   []string{"h2", "http/1.1"},
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for cn, a server certificate for the host
+// "localhost" if server is set and a client certificate otherwise.
+func (ca *testCA) issue(t *testing.T, cn string, server bool) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.DNSNames = []string{"localhost"}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCA writes the PEM certificate of ca to path.
+func (ca *testCA) writeCA(t *testing.T, path string) {
+	t.Helper()
+
+	writePEM(t, path, "CERTIFICATE", ca.cert.Raw)
+}
+
+// writeCert writes the PEM certificate and key of cert to the files of cfg.
+func writeCert(t *testing.T, cfg TLSConfig, cert tls.Certificate) {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, cfg.CertFile, "CERTIFICATE", cert.Certificate[0])
+	writePEM(t, cfg.KeyFile, "PRIVATE KEY", der)
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+
+	b := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake runs a TLS handshake of a client with cfg against srv and
+// returns the server certificate seen by the client. The error is the one of
+// the side failing first.
+func handshake(t *testing.T, srv, cfg *tls.Config) (*x509.Certificate, error) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		s, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer s.Close()
+		done <- tls.Server(s, srv).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), cfg)
+	if err != nil {
+		<-done
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func testTLSConfig(t *testing.T, ca *testCA) TLSConfig {
+	t.Helper()
+
+	dir := t.TempDir()
+	cfg := TLSConfig{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	writeCert(t, cfg, ca.issue(t, "server", true))
+	ca.writeCA(t, cfg.ClientCAFile)
+	return cfg
+}
+
+func TestTLSHandshake(t *testing.T) {
+	ca := newTestCA(t)
+	cfg := testTLSConfig(t, ca)
+	client := ca.issue(t, "shop", false)
+
+	for _, c := range []struct {
+		clientAuth string
+		cert       bool
+		ok         bool
+	}{
+		{"", false, true},
+		{"", true, true},
+		{"require", true, true},
+		{"require", false, false},
+	} {
+		cfg.ClientAuth = c.clientAuth
+		r, err := newCertReloader(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clientCfg := &tls.Config{RootCAs: ca.pool, ServerName: "localhost"}
+		if c.cert {
+			clientCfg.Certificates = []tls.Certificate{client}
+		}
+		_, err = handshake(t, r.tlsConfig(), clientCfg)
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("client_auth %q, client cert %v: %v, want ok %v", c.clientAuth, c.cert, err, c.ok)
+		}
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	ca := newTestCA(t)
+	cfg := testTLSConfig(t, ca)
+	clientCfg := &tls.Config{RootCAs: ca.pool, ServerName: "localhost"}
+
+	r, err := newCertReloader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := r.tlsConfig()
+	old, err := handshake(t, srv, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := ca.issue(t, "server", true)
+	writeCert(t, cfg, next)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := handshake(t, srv, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.SerialNumber.Cmp(next.Leaf.SerialNumber) != 0 {
+		t.Errorf("serial %v after reload, want the new %v, old %v", leaf.SerialNumber, next.Leaf.SerialNumber, old.SerialNumber)
+	}
+
+	if err := os.WriteFile(cfg.KeyFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err == nil {
+		t.Error("reload of a broken key succeeded")
+	}
+	leaf, err = handshake(t, srv, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.SerialNumber.Cmp(next.Leaf.SerialNumber) != 0 {
+		t.Errorf("serial %v after a failed reload, want %v", leaf.SerialNumber, next.Leaf.SerialNumber)
+	}
+}
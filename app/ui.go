@@ -0,0 +1,47 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+)
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// uiAssets returns the web UI files, embedded in the binary unless dir is
+// set. Files served from dir are read on every request, so edits show up
+// without a restart.
+func uiAssets(dir string) fs.FS {
+	if dir != "" {
+		log.Println("[APP]   serve web UI from", dir)
+		return os.DirFS(dir)
+	}
+
+	assets, err := fs.Sub(embeddedStatic, "static")
+	if err != nil {
+		panic(err)
+	}
+	return assets
+}
+
+// registerUI adds the web UI pages under prefix, which starts and ends with
+// "/". The pages use relative links, so they work under any prefix, and get
+// their data from the JSON API.
+func registerUI(m *http.ServeMux, prefix string, assets fs.FS) {
+	if prefix != "/" {
+		m.Handle(prefix+"{$}", http.RedirectHandler(prefix+"packs", http.StatusFound))
+	}
+
+	m.HandleFunc(prefix+"packs", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, assets, "packs.html")
+	})
+
+	m.HandleFunc(prefix+"keys", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, assets, "keys.html")
+	})
+
+	m.Handle(prefix+"static/", http.StripPrefix(prefix+"static", http.FileServerFS(assets)))
+}
@@ -0,0 +1,489 @@
+package cdkey
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AuditConfig configures the rotation of audit.log and the aggregation of
+// repeated failed redemptions.
+//
+// audit.log is renamed to audit.log.1 once it is larger than MaxSize bytes or
+// its first record is older than MaxAge; older files are shifted to
+// audit.log.2 and so on. All of them are kept, unless MaxFiles is set, then
+// the files beyond MaxFiles are removed.
+//
+// A failed key.use or key.reserve is written, but the same failure, i.e. with
+// the same actor, client, pack and code, is then only counted for
+// AggregateWindow. At the end of the window a single record with the count is
+// written, so a brute-force attack does not fill the log.
+type AuditConfig struct {
+	MaxSize         int64         `json:"maxSize"`         // default 64 MiB
+	MaxAge          time.Duration `json:"maxAge"`          // default 0, no age limit
+	MaxFiles        int           `json:"maxFiles"`        // default 0, keeps all files
+	AggregateWindow time.Duration `json:"aggregateWindow"` // default 1 minute, negative disables
+}
+
+func (c AuditConfig) withDefaults() AuditConfig {
+	if c.MaxSize == 0 {
+		c.MaxSize = 64 << 20
+	}
+	if c.AggregateWindow == 0 {
+		c.AggregateWindow = time.Minute
+	}
+	return c
+}
+
+// AuditRecord is an entry of the audit log, written for every state-changing
+// Server call, whether it succeeded or not.
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Client string    `json:"client,omitempty"`
+	Cmd    string    `json:"cmd"`
+	Pack   string    `json:"pack,omitempty"`
+	Key    string    `json:"key,omitempty"`
+	Code   int       `json:"code"`
+	Msg    string    `json:"msg,omitempty"`
+
+	// Count, if set, is the number of failures aggregated into this record,
+	// see AuditConfig. Time is the last of them, and Key is empty.
+	Count int `json:"count,omitempty"`
+}
+
+// AuditQuery filters audit records. Zero fields match everything. If Limit is
+// positive only the latest Limit matching records are returned.
+type AuditQuery struct {
+	Pack  string    `json:"pack"`
+	Actor string    `json:"actor"`
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+	Limit int       `json:"limit"`
+}
+
+func (q AuditQuery) match(r AuditRecord) bool {
+	switch {
+	case q.Pack != "" && q.Pack != r.Pack:
+		return false
+	case q.Actor != "" && q.Actor != r.Actor:
+		return false
+	case !q.Since.IsZero() && r.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !r.Time.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// aggregated counts the failures of a record written by auditLog.write,
+// until the end of the aggregation window.
+type aggregated struct {
+	record AuditRecord
+	start  time.Time
+	count  int
+}
+
+// auditLog appends records as JSON lines to audit.log of the server directory,
+// rotated as configured by AuditConfig. The file is only ever opened for
+// appending. Aggregated failures are flushed by a background goroutine,
+// stopped by close.
+type auditLog struct {
+	path  string
+	cfg   AuditConfig
+	clock Clock
+	log   libLogger
+
+	f     *os.File
+	size  int64
+	first time.Time // of the first record in f, zero if empty
+	aggs  map[string]*aggregated
+	mtx   sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func openAuditLog(dir string, opts Options) (*auditLog, error) {
+	a := &auditLog{
+		path:  filepath.Join(dir, "audit.log"),
+		cfg:   opts.Audit,
+		clock: opts.Clock,
+		log:   opts.libLogger(),
+		aggs:  make(map[string]*aggregated),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+
+	if records, err := readAuditFile(a.log, a.path, AuditQuery{Limit: -1}); err == nil && len(records) > 0 {
+		a.first = records[0].Time
+	}
+
+	go a.run()
+	return a, nil
+}
+
+// open opens audit.log for appending. The caller must hold a.mtx.
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		a.log.error_log("failed open audit.log", "err", err)
+		return ErrFailedWriteAudit.affix(err)
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		a.log.error_log("failed stat audit.log", "err", err)
+		return ErrFailedWriteAudit.affix(err)
+	}
+
+	a.f, a.size, a.first = f, st.Size(), time.Time{}
+	return nil
+}
+
+func (a *auditLog) run() {
+	defer close(a.done)
+
+	if a.cfg.AggregateWindow < 0 {
+		<-a.stop
+		return
+	}
+
+	ticker := time.NewTicker(a.cfg.AggregateWindow)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+
+		a.mtx.Lock()
+		a.flush(a.clock.Now())
+		a.mtx.Unlock()
+	}
+}
+
+// aggregates reports whether r is a failure aggregated by AuditConfig.
+func aggregates(r AuditRecord) bool {
+	return r.Code != 0 && (r.Cmd == "key.use" || r.Cmd == "key.reserve")
+}
+
+func (a *auditLog) write(r AuditRecord) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.cfg.AggregateWindow < 0 || !aggregates(r) {
+		return a.append(r)
+	}
+
+	k := fmt.Sprintf("%v|%v|%v|%v|%v", r.Cmd, r.Pack, r.Actor, r.Client, r.Code)
+	if agg, ok := a.aggs[k]; ok && r.Time.Sub(agg.start) < a.cfg.AggregateWindow {
+		agg.count++
+		agg.record.Time = r.Time
+		return nil
+	}
+
+	if err := a.flushOne(k); err != nil {
+		return err
+	}
+	a.aggs[k] = &aggregated{record: r, start: r.Time}
+	return a.append(r)
+}
+
+// flush writes the aggregated failures whose window ended before now, or all
+// of them if now is zero. The caller must hold a.mtx.
+func (a *auditLog) flush(now time.Time) error {
+	var err error
+	for k, agg := range a.aggs {
+		if !now.IsZero() && now.Sub(agg.start) < a.cfg.AggregateWindow {
+			continue
+		}
+		if e := a.flushOne(k); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// flushOne writes the aggregated failures of k, if any, and ends its window.
+// The caller must hold a.mtx.
+func (a *auditLog) flushOne(k string) error {
+	agg, ok := a.aggs[k]
+	if !ok {
+		return nil
+	}
+	delete(a.aggs, k)
+
+	if agg.count == 0 {
+		return nil
+	}
+
+	r := agg.record
+	r.Key, r.Count = "", agg.count
+	if err := a.append(r); err != nil {
+		a.log.error_log("failed write aggregated audit record", "cmd", r.Cmd, "pack", r.Pack, "err", err)
+		return err
+	}
+	return nil
+}
+
+// append writes r to audit.log, rotating it first if needed. The caller must
+// hold a.mtx.
+func (a *auditLog) append(r AuditRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return ErrFailedWriteAudit.affix(err)
+	}
+
+	if a.size > 0 && (a.size+int64(len(b)) >= a.cfg.MaxSize ||
+		a.cfg.MaxAge > 0 && !a.first.IsZero() && r.Time.Sub(a.first) >= a.cfg.MaxAge) {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.f.Write(append(b, '\n'))
+	a.size += int64(n)
+	if err != nil {
+		return ErrFailedWriteAudit.affix(err)
+	}
+	if a.first.IsZero() {
+		a.first = r.Time
+	}
+	return nil
+}
+
+// rotate shifts audit.log to audit.log.1, audit.log.1 to audit.log.2 and so
+// on, removes the files beyond MaxFiles, if set, and opens a new audit.log.
+// The caller must hold a.mtx.
+func (a *auditLog) rotate() error {
+	if err := a.f.Close(); err != nil {
+		a.log.error_log("failed close audit.log", "err", err)
+	}
+
+	last := a.lastRotated()
+	if a.cfg.MaxFiles > 0 {
+		if err := os.Remove(a.rotated(a.cfg.MaxFiles)); err != nil && !os.IsNotExist(err) {
+			a.log.error_log("failed remove old audit log", "err", err)
+		}
+		last = a.cfg.MaxFiles - 1
+	}
+	for i := last; i >= 0; i-- {
+		if err := os.Rename(a.rotated(i), a.rotated(i+1)); err != nil && !os.IsNotExist(err) {
+			a.log.error_log("failed rotate audit log", "err", err)
+		}
+	}
+
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	a.log.info_log("audit log rotated", "files", a.cfg.MaxFiles)
+	return nil
+}
+
+// rotated returns the path of the i-th rotated file, audit.log itself for 0.
+func (a *auditLog) rotated(i int) string {
+	if i == 0 {
+		return a.path
+	}
+	return fmt.Sprintf("%v.%v", a.path, i)
+}
+
+// lastRotated returns the number of the oldest rotated file, 0 if there is
+// none.
+func (a *auditLog) lastRotated() int {
+	i := 0
+	for fileExists(a.rotated(i + 1)) {
+		i++
+	}
+	return i
+}
+
+// auditFile is a file of the audit log opened by snapshot, read up to size.
+type auditFile struct {
+	path string
+	f    *os.File
+	size int64
+}
+
+// snapshot opens the rotated files, oldest first, and then audit.log, limited
+// to the records written so far. Open files are not affected by a rotation, so
+// a query reads them without a.mtx and still sees every record exactly once.
+func (a *auditLog) snapshot() ([]auditFile, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var files []auditFile
+	for i := a.lastRotated(); i >= 0; i-- {
+		path := a.rotated(i)
+		f, err := os.Open(path)
+		if err != nil {
+			a.log.error_log("failed open audit log", "path", path, "err", err)
+			closeAuditFiles(files)
+			return nil, err
+		}
+
+		size := a.size
+		if i > 0 {
+			st, err := f.Stat()
+			if err != nil {
+				f.Close()
+				a.log.error_log("failed stat audit log", "path", path, "err", err)
+				closeAuditFiles(files)
+				return nil, err
+			}
+			size = st.Size()
+		}
+		files = append(files, auditFile{path: path, f: f, size: size})
+	}
+	return files, nil
+}
+
+func closeAuditFiles(files []auditFile) {
+	for _, af := range files {
+		af.f.Close()
+	}
+}
+
+// query reads the rotated files, oldest first, and then audit.log.
+func (a *auditLog) query(q AuditQuery) ([]AuditRecord, error) {
+	files, err := a.snapshot()
+	if err != nil {
+		return nil, ErrFailedReadAudit.affix(err)
+	}
+	defer closeAuditFiles(files)
+
+	var records []AuditRecord
+
+	for _, af := range files {
+		rs, err := readAudit(a.log, af.path, io.LimitReader(af.f, af.size), q)
+		if err != nil {
+			return nil, ErrFailedReadAudit.affix(err)
+		}
+
+		records = append(records, rs...)
+		if q.Limit > 0 && len(records) > q.Limit {
+			records = records[len(records)-q.Limit:]
+		}
+	}
+
+	return records, nil
+}
+
+// readAuditFile returns the records of the file at path matching q. A negative
+// q.Limit returns the first record only.
+func readAuditFile(log libLogger, path string, q AuditQuery) ([]AuditRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.error_log("failed open audit log", "path", path, "err", err)
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return readAudit(log, path, f, q)
+}
+
+// readAudit returns the records read from r matching q, like readAuditFile.
+func readAudit(log libLogger, path string, r io.Reader, q AuditQuery) ([]AuditRecord, error) {
+	var records []AuditRecord
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			log.error_log("skip bad audit record", "err", err)
+			continue
+		}
+
+		if !q.match(r) {
+			continue
+		}
+
+		records = append(records, r)
+		if q.Limit < 0 {
+			break
+		}
+		if q.Limit > 0 && len(records) > q.Limit {
+			records = records[1:]
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.error_log("failed read audit log", "path", path, "err", err)
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// close writes the pending aggregated failures and closes audit.log.
+func (a *auditLog) close() error {
+	close(a.stop)
+	<-a.done
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	a.flush(time.Time{})
+	return a.f.Close()
+}
+
+// actor returns who is behind ctx: the API token as "token:<id>", "anonymous"
+// for unauthenticated remote clients, and "local" for direct library calls.
+func actor(ctx context.Context) string {
+	if t, ok := PrincipalFromContext(ctx); ok {
+		return "token:" + t.ID
+	}
+	if _, ok := ClientAddrFromContext(ctx); ok {
+		return "anonymous"
+	}
+	return "local"
+}
+
+// audit writes the record of a state-changing call. Failures are logged only,
+// since the call itself has already taken effect.
+func (s *Server) audit(ctx context.Context, cmd, pack, key string, err error) {
+	r := AuditRecord{
+		Time:  s.opts.Clock.Now(),
+		Actor: actor(ctx),
+		Cmd:   cmd,
+		Pack:  pack,
+		Key:   key,
+	}
+	r.Client, _ = ClientAddrFromContext(ctx)
+
+	if err != nil {
+		var e *StatusError
+		if !errors.As(err, &e) {
+			e = ErrInternal.affix(err)
+		}
+		r.Code, r.Msg = e.Code(), e.Msg()
+	}
+
+	if err := s.auditLog.write(r); err != nil {
+		s.log.error_logc(ctx, "failed write audit record", "cmd", cmd, "pack", pack, "err", err)
+	}
+}
+
+// QueryAudit returns the audit records matching q, oldest first.
+func (s *Server) QueryAudit(q AuditQuery) ([]AuditRecord, error) {
+	return s.auditLog.query(q)
+}
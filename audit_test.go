@@ -0,0 +1,160 @@
+package cdkey
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAuditRotate(t *testing.T) {
+	dir := t.TempDir()
+	a, err := openAuditLog(dir, Options{
+		Clock: systemClock{},
+		Audit: AuditConfig{MaxSize: 200, MaxFiles: 2, AggregateWindow: -1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.close()
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := a.write(AuditRecord{Time: now.Add(time.Duration(i) * time.Second), Actor: "local", Cmd: "pack.add", Pack: "p"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(a.rotated(2)); err != nil {
+		t.Errorf("no second rotated file: %v", err)
+	}
+	if _, err := os.Stat(a.rotated(3)); !os.IsNotExist(err) {
+		t.Errorf("rotated file beyond MaxFiles kept: %v", err)
+	}
+
+	records, err := a.query(AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || len(records) >= 10 {
+		t.Fatalf("%v records, want some but not all", len(records))
+	}
+	for i := 1; i < len(records); i++ {
+		if !records[i-1].Time.Before(records[i].Time) {
+			t.Fatalf("records out of order: %v", records)
+		}
+	}
+	if last := records[len(records)-1].Time; !last.Equal(now.Add(9 * time.Second)) {
+		t.Errorf("last record at %v, want the latest", last)
+	}
+
+	limited, err := a.query(AuditQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limited) != 2 || !limited[1].Time.Equal(records[len(records)-1].Time) {
+		t.Errorf("limited query: %v", limited)
+	}
+}
+
+func TestAuditKeepAll(t *testing.T) {
+	a, err := openAuditLog(t.TempDir(), Options{
+		Clock: systemClock{},
+		Audit: AuditConfig{MaxSize: 200, AggregateWindow: -1}.withDefaults(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.close()
+
+	now := time.Now()
+	for i := 0; i < 30; i++ {
+		if err := a.write(AuditRecord{Time: now.Add(time.Duration(i) * time.Second), Actor: "local", Cmd: "pack.add", Pack: "p"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := a.lastRotated(); n < 11 {
+		t.Errorf("%v rotated files, want all of them kept", n)
+	}
+	records, err := a.query(AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 30 {
+		t.Errorf("%v records, want all 30", len(records))
+	}
+}
+
+func TestAuditQueryDuringRotation(t *testing.T) {
+	a, err := openAuditLog(t.TempDir(), Options{
+		Clock: systemClock{},
+		Audit: AuditConfig{MaxSize: 300, AggregateWindow: -1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.close()
+
+	now := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			a.write(AuditRecord{Time: now.Add(time.Duration(i) * time.Second), Actor: "local", Cmd: "pack.add", Pack: "p"})
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		records, err := a.query(AuditQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, r := range records {
+			if !r.Time.Equal(now.Add(time.Duration(i) * time.Second)) {
+				t.Fatalf("record %v at %v, want every record once and in order", i, r.Time.Sub(now))
+			}
+		}
+	}
+}
+
+func TestAuditAggregate(t *testing.T) {
+	a, err := openAuditLog(t.TempDir(), Options{
+		Clock: systemClock{},
+		Audit: AuditConfig{MaxSize: 1 << 20, MaxFiles: 1, AggregateWindow: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	fail := AuditRecord{Actor: "anonymous", Client: "192.0.2.1", Cmd: "key.use", Pack: "p", Code: ErrKeyNotFound.Code()}
+	for i := 0; i < 5; i++ {
+		fail.Time, fail.Key = now.Add(time.Duration(i)*time.Second), "KEY"
+		if err := a.write(fail); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.write(AuditRecord{Time: now, Actor: "anonymous", Cmd: "key.use", Pack: "p", Key: "GOOD"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := readAuditFile(a.log, a.path, AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("%v records, want the first failure, the success and the count: %+v", len(records), records)
+	}
+	if r := records[2]; r.Count != 4 || r.Key != "" || !r.Time.Equal(now.Add(4*time.Second)) {
+		t.Errorf("aggregated record %+v", r)
+	}
+}
@@ -0,0 +1,545 @@
+package cdkey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Role is a set of permissions, granted to an API token for the packs
+// matching a RoleBinding.
+type Role string
+
+const (
+	// RoleViewer can list packs and list or export their keys. Bound to "*"
+	// it can also read the metrics.
+	RoleViewer Role = "viewer"
+
+	// RoleOperator can do what a viewer can, and enable, disable, verify and
+	// reload packs.
+	RoleOperator Role = "operator"
+
+	// RoleRedeemer can use keys only.
+	RoleRedeemer Role = "redeemer"
+
+	// RoleAdmin can do everything, including adding and removing packs. Bound
+	// to the pattern "*" it also has the global permissions, e.g. managing API
+	// tokens and projects.
+	RoleAdmin Role = "admin"
+)
+
+// Permission is what a command requires from the API token.
+type Permission string
+
+const (
+	PermPackView     Permission = "pack.view"
+	PermPackManage   Permission = "pack.manage"
+	PermPackAdmin    Permission = "pack.admin"
+	PermKeyUse       Permission = "key.use"
+	PermTokenAdmin   Permission = "token.admin"
+	PermProjectAdmin Permission = "project.admin"
+	PermAuditView    Permission = "audit.view"
+	PermWebhookAdmin Permission = "webhook.admin"
+	PermMetricsView  Permission = "metrics.view"
+)
+
+// globalPermissions are not bound to packs, they require a role having them
+// bound to "*".
+var globalPermissions = map[Permission]bool{
+	PermTokenAdmin:   true,
+	PermProjectAdmin: true,
+	PermAuditView:    true,
+	PermWebhookAdmin: true,
+	PermMetricsView:  true,
+}
+
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:   {PermPackView, PermMetricsView},
+	RoleOperator: {PermPackView, PermPackManage, PermMetricsView},
+	RoleRedeemer: {PermKeyUse},
+	RoleAdmin:    {PermPackView, PermPackManage, PermPackAdmin, PermKeyUse, PermTokenAdmin, PermProjectAdmin, PermAuditView, PermWebhookAdmin, PermMetricsView},
+}
+
+func (r Role) has(perm Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleBinding grants Role on every pack whose project-qualified name matches
+// the Packs pattern, in path.Match syntax, e.g. "partnerX-*" or "game1/*".
+// The pattern "*" matches all packs of all projects.
+type RoleBinding struct {
+	Role  Role   `json:"role"`
+	Packs string `json:"packs"`
+}
+
+func (b RoleBinding) validate() error {
+	if _, ok := rolePermissions[b.Role]; !ok {
+		return ErrBadRequest.affix(fmt.Sprintf("role:%v", b.Role))
+	}
+	if _, err := path.Match(b.Packs, ""); err != nil {
+		return ErrBadRequest.affix(fmt.Sprintf("packs:%v, %v", b.Packs, err))
+	}
+	return nil
+}
+
+func (b RoleBinding) matches(pack string) bool {
+	if b.Packs == "*" {
+		return true
+	}
+	ok, _ := path.Match(b.Packs, pack)
+	return ok
+}
+
+// TokenInfo describes an API token. The token secret itself is never stored,
+// only its SHA-256 hash.
+type TokenInfo struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	Bindings   []RoleBinding `json:"bindings"`
+	CreateTime time.Time     `json:"createTime"`
+}
+
+// Allows reports whether the token has perm on the named pack. Permissions in
+// globalPermissions are not bound to a pack, they require a binding on "*".
+func (t TokenInfo) Allows(perm Permission, pack string) bool {
+	for _, b := range t.Bindings {
+		if !b.Role.has(perm) {
+			continue
+		}
+		if globalPermissions[perm] {
+			if b.Packs == "*" {
+				return true
+			}
+		} else if b.matches(pack) {
+			return true
+		}
+	}
+	return false
+}
+
+// StaticToken is an API token given in Options.Tokens, e.g. from a config
+// file, instead of created by CreateToken. Static tokens are not stored in
+// tokens.json and can not be listed or revoked through the API.
+type StaticToken struct {
+	Name     string        `json:"name"`
+	Secret   string        `json:"secret"`
+	Bindings []RoleBinding `json:"bindings"`
+}
+
+// ClientCert gives API permissions to TLS client certificates, see
+// Options.ClientCerts. Name is matched against the common name of the
+// certificate subject. The certificate must have been verified by the TLS
+// server, i.e. its tls.Config needs ClientCAs.
+type ClientCert struct {
+	Name     string        `json:"name"`
+	Bindings []RoleBinding `json:"bindings"`
+}
+
+type storedToken struct {
+	TokenInfo
+	Hash string `json:"hash"`
+
+	// Scope is the access level of tokens created before role bindings,
+	// either "admin" or "redeem". It is converted to Bindings on load.
+	Scope string `json:"scope,omitempty"`
+}
+
+// tokenStore keeps API tokens in tokens.json of the server directory.
+type tokenStore struct {
+	path   string
+	tokens map[string]storedToken // by hash
+	static map[string]TokenInfo   // by hash
+	certs  map[string]TokenInfo   // by common name
+	log    libLogger
+	mtx    sync.RWMutex
+}
+
+func loadTokenStore(dir string, log libLogger) (*tokenStore, error) {
+	ts := &tokenStore{
+		path:   filepath.Join(dir, "tokens.json"),
+		log:    log,
+		tokens: make(map[string]storedToken),
+		static: make(map[string]TokenInfo),
+		certs:  make(map[string]TokenInfo),
+	}
+
+	b, err := ioutil.ReadFile(ts.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ts, nil
+		}
+		log.error_log("failed read tokens.json", "err", err)
+		return nil, ErrFailedLoadTokens.affix(err)
+	}
+
+	var tokens []storedToken
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		log.error_log("failed parse tokens.json", "err", err)
+		return nil, ErrFailedLoadTokens.affix(err)
+	}
+
+	for _, t := range tokens {
+		switch t.Scope {
+		case "admin":
+			t.Bindings = append(t.Bindings, RoleBinding{RoleAdmin, "*"})
+		case "redeem":
+			t.Bindings = append(t.Bindings, RoleBinding{RoleRedeemer, "*"})
+		}
+		t.Scope = ""
+		ts.tokens[t.Hash] = t
+	}
+
+	log.info_log("tokens loaded", "count", len(ts.tokens))
+	return ts, nil
+}
+
+// addStatic adds the tokens of Options.Tokens. Their ids are "static-" and the
+// token name.
+func (ts *tokenStore) addStatic(tokens []StaticToken, now time.Time) error {
+	for _, t := range tokens {
+		if t.Name == "" || t.Secret == "" {
+			return ErrBadRequest.affix(fmt.Sprintf("static token:%v, name and secret required", t.Name))
+		}
+		if len(t.Bindings) == 0 {
+			return ErrBadRequest.affix(fmt.Sprintf("static token:%v, no role bindings", t.Name))
+		}
+		for _, b := range t.Bindings {
+			if err := b.validate(); err != nil {
+				return err
+			}
+		}
+
+		hash := hashToken(t.Secret)
+		if _, ok := ts.static[hash]; ok {
+			return ErrBadRequest.affix(fmt.Sprintf("static token:%v, duplicate secret", t.Name))
+		}
+
+		ts.static[hash] = TokenInfo{
+			ID:         "static-" + t.Name,
+			Name:       t.Name,
+			Bindings:   t.Bindings,
+			CreateTime: now,
+		}
+	}
+
+	if len(tokens) > 0 {
+		ts.log.info_log("static tokens added", "count", len(tokens))
+	}
+	return nil
+}
+
+// addCerts adds the principals of Options.ClientCerts. Their ids are "cert-"
+// and the common name.
+func (ts *tokenStore) addCerts(certs []ClientCert, now time.Time) error {
+	for _, c := range certs {
+		if c.Name == "" {
+			return ErrBadRequest.affix("client cert, name required")
+		}
+		if len(c.Bindings) == 0 {
+			return ErrBadRequest.affix(fmt.Sprintf("client cert:%v, no role bindings", c.Name))
+		}
+		for _, b := range c.Bindings {
+			if err := b.validate(); err != nil {
+				return err
+			}
+		}
+
+		ts.certs[c.Name] = TokenInfo{
+			ID:         "cert-" + c.Name,
+			Name:       c.Name,
+			Bindings:   c.Bindings,
+			CreateTime: now,
+		}
+	}
+
+	if len(certs) > 0 {
+		ts.log.info_log("client certs added", "count", len(certs))
+	}
+	return nil
+}
+
+// save writes all tokens to a temporary file and renames it over tokens.json.
+// The caller must hold ts.mtx.
+func (ts *tokenStore) save() error {
+	tokens := make([]storedToken, 0, len(ts.tokens))
+	for _, t := range ts.tokens {
+		tokens = append(tokens, t)
+	}
+
+	b, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return ErrFailedSaveTokens.affix(err)
+	}
+
+	tmp := ts.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		ts.log.error_log("failed save tokens.json", "err", err)
+		return ErrFailedSaveTokens.affix(err)
+	}
+	if err := os.Rename(tmp, ts.path); err != nil {
+		ts.log.error_log("failed save tokens.json", "err", err)
+		return ErrFailedSaveTokens.affix(err)
+	}
+	return nil
+}
+
+func hashToken(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (ts *tokenStore) create(name string, bindings []RoleBinding, now time.Time) (string, TokenInfo, error) {
+	if len(bindings) == 0 {
+		return "", TokenInfo{}, ErrBadRequest.affix("no role bindings")
+	}
+	for _, b := range bindings {
+		if err := b.validate(); err != nil {
+			return "", TokenInfo{}, err
+		}
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return "", TokenInfo{}, ErrInternal.affix(err)
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", TokenInfo{}, ErrInternal.affix(err)
+	}
+	secret = "cdk_" + secret
+
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	t := storedToken{
+		TokenInfo: TokenInfo{
+			ID:         id,
+			Name:       name,
+			Bindings:   bindings,
+			CreateTime: now,
+		},
+		Hash: hashToken(secret),
+	}
+
+	ts.tokens[t.Hash] = t
+	if err := ts.save(); err != nil {
+		delete(ts.tokens, t.Hash)
+		return "", TokenInfo{}, err
+	}
+
+	ts.log.info_log("token created", "id", id, "name", name, "bindings", bindings)
+	return secret, t.TokenInfo, nil
+}
+
+func (ts *tokenStore) list() []TokenInfo {
+	ts.mtx.RLock()
+	defer ts.mtx.RUnlock()
+
+	tokens := make([]TokenInfo, 0, len(ts.tokens))
+	for _, t := range ts.tokens {
+		tokens = append(tokens, t.TokenInfo)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreateTime.Before(tokens[j].CreateTime)
+	})
+	return tokens
+}
+
+func (ts *tokenStore) revoke(id string) error {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	for hash, t := range ts.tokens {
+		if t.ID != id {
+			continue
+		}
+
+		delete(ts.tokens, hash)
+		if err := ts.save(); err != nil {
+			ts.tokens[hash] = t
+			return err
+		}
+
+		ts.log.info_log("token revoked", "id", id, "name", t.Name)
+		return nil
+	}
+
+	ts.log.warn_log("token not found", "id", id)
+	return ErrTokenNotFound.affix(fmt.Sprintf("id:%v", id))
+}
+
+func (ts *tokenStore) authenticate(secret string) (TokenInfo, bool) {
+	ts.mtx.RLock()
+	defer ts.mtx.RUnlock()
+
+	hash := hashToken(secret)
+	if t, ok := ts.static[hash]; ok {
+		return t, true
+	}
+	t, ok := ts.tokens[hash]
+	return t.TokenInfo, ok
+}
+
+// authenticateCert returns the principal of the verified client certificate
+// of a TLS connection, if any.
+func (ts *tokenStore) authenticateCert(state *tls.ConnectionState) (TokenInfo, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return TokenInfo{}, false
+	}
+
+	t, ok := ts.certs[state.VerifiedChains[0][0].Subject.CommonName]
+	return t, ok
+}
+
+// CreateToken creates an API token with the given role bindings and returns
+// its secret, which is shown only once and can not be recovered later.
+func (s *Server) CreateToken(name string, bindings []RoleBinding) (string, TokenInfo, error) {
+	return s.CreateTokenContext(context.Background(), name, bindings)
+}
+
+func (s *Server) CreateTokenContext(ctx context.Context, name string, bindings []RoleBinding) (secret string, info TokenInfo, err error) {
+	defer func() { s.audit(ctx, "token.create", "", "", err) }()
+
+	return s.tokens.create(name, bindings, s.opts.Clock.Now())
+}
+
+func (s *Server) ListTokens() []TokenInfo {
+	return s.tokens.list()
+}
+
+func (s *Server) RevokeToken(id string) error {
+	return s.RevokeTokenContext(context.Background(), id)
+}
+
+func (s *Server) RevokeTokenContext(ctx context.Context, id string) (err error) {
+	defer func() { s.audit(ctx, "token.revoke", "", "", err) }()
+
+	return s.tokens.revoke(id)
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the API token which authenticated the request
+// carrying ctx. It returns false if the request was not authenticated.
+func PrincipalFromContext(ctx context.Context) (TokenInfo, bool) {
+	t, ok := ctx.Value(principalKey{}).(TokenInfo)
+	return t, ok
+}
+
+// tokenFromRequest reads the token secret from the Authorization bearer
+// header, the X-CDKey-Token header or the cdkey_token cookie used by the web
+// UI.
+//
+// Browsers send the cookie with cross-site requests too, e.g. a form posted to
+// /pack.remove by another site, so it is only accepted together with the
+// X-Requested-With header, which the web UI sets and other sites can not set
+// without a CORS preflight.
+func tokenFromRequest(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	if h := r.Header.Get("X-CDKey-Token"); h != "" {
+		return h
+	}
+	if c, err := r.Cookie("cdkey_token"); err == nil && r.Header.Get("X-Requested-With") != "" {
+		return c.Value
+	}
+	return ""
+}
+
+// AuthHandler wraps h so that it requires a valid API token, which is then
+// available to h through PrincipalFromContext. Requests without a valid token
+// are rejected with ErrUnauthorized (401). The token is only checked if
+// Options.Auth is set. Without a valid token, a verified TLS client
+// certificate listed in Options.ClientCerts is accepted. The client address
+// is always added to the request context, see WithClientAddr.
+//
+// AuthHandler only authenticates, h must check the permissions it needs with
+// Server.Authorize.
+//
+// Every request gets a request id, taken from the X-Request-Id header or
+// generated, which is sent back in X-Request-Id and added to the log entries
+// of the request, see WithLogFields.
+func (s *Server) AuthHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" {
+			id, _ = randomHex(8)
+		}
+		w.Header().Set("X-Request-Id", id)
+
+		ctx := WithClientAddr(r.Context(), s.clientAddr(r))
+		r = r.WithContext(WithLogFields(ctx, "requestId", id))
+
+		if !s.opts.Auth {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		t, ok := s.authenticate(r)
+		if !ok {
+			s.log.warn_logc(r.Context(), "unauthorized request", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cdkey"`)
+			putStatusError(w, strings.TrimPrefix(r.URL.Path, "/"), ErrUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, t)))
+	})
+}
+
+// authenticate returns the token of r, given as a secret or a client
+// certificate.
+func (s *Server) authenticate(r *http.Request) (TokenInfo, bool) {
+	t, ok := s.tokens.authenticate(tokenFromRequest(r))
+	if !ok {
+		t, ok = s.tokens.authenticateCert(r.TLS)
+	}
+	return t, ok
+}
+
+// Authorize returns ErrForbidden unless the principal of ctx has perm on the
+// named pack. It always succeeds if Options.Auth is not set.
+func (s *Server) Authorize(ctx context.Context, perm Permission, pack string) error {
+	if !s.opts.Auth {
+		return nil
+	}
+
+	t, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return ErrUnauthorized
+	}
+
+	if !t.Allows(perm, pack) {
+		s.log.warn_logc(ctx, "forbidden request", "token", t.ID, "perm", perm, "pack", pack)
+		return ErrForbidden.affix(fmt.Sprintf("token:%v, perm:%v, pack:%v", t.ID, perm, pack))
+	}
+
+	return nil
+}
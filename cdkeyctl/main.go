@@ -0,0 +1,351 @@
+// Command cdkeyctl administers cdkey packs and keys, either through the HTTP
+// API of a running server or directly in the data directory of a stopped
+// server.
+//
+//	cdkeyctl -server http://localhost:8080 -token $TOKEN pack list
+//	cdkeyctl -d /home/cdkey pack create -prefix AB -keylen 12 -size 1000 summer
+//	cdkeyctl -d /home/cdkey -o json key check summer AB12CD34EF56
+//
+// The server URL and token default to $CDKEY_SERVER and $CDKEY_TOKEN.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/yxpod/cdkey"
+	"github.com/yxpod/cdkey/client"
+)
+
+var (
+	serverURL = flag.String("server", os.Getenv("CDKEY_SERVER"), "URL of a running cdkey server")
+	token     = flag.String("token", os.Getenv("CDKEY_TOKEN"), "API token for -server")
+	dir       = flag.String("d", "", "data directory of a stopped cdkey server, instead of -server")
+	output    = flag.String("o", "table", "output format, table or json")
+)
+
+const usage = `usage: cdkeyctl [flags] <command> [args]
+
+commands:
+  pack list
+  pack create [-prefix P] [-keylen N] [-size N] [-note S] NAME
+  pack enable NAME
+  pack disable NAME [MSG]
+  pack remove NAME
+  pack extend NAME COUNT
+  pack export [-status S] NAME     keys as CSV, or JSON with -o json
+  key check PACK KEY
+  key use PACK KEY
+  key revoke PACK KEY
+
+flags:
+`
+
+// backend is implemented by client.Client for -server and by local for -d.
+type backend interface {
+	ListPacks(ctx context.Context) ([]cdkey.PackInfo, error)
+	AddPack(ctx context.Context, name string, prefix string, keylen, packsize int, note string) error
+	EnablePack(ctx context.Context, name string) error
+	DisablePack(ctx context.Context, name string, msg string) error
+	RemovePack(ctx context.Context, name string) error
+	ExtendPack(ctx context.Context, name string, count int) error
+	ListKeys(ctx context.Context, pack string) ([]cdkey.KeyInfo, error)
+	CheckKey(ctx context.Context, pack, key string) (cdkey.KeyInfo, error)
+	UseKey(ctx context.Context, pack, key string) error
+	RevokeKey(ctx context.Context, pack, key string) error
+}
+
+// local runs the commands on a Server opened on the data directory.
+type local struct {
+	s *cdkey.Server
+}
+
+func (l local) ListPacks(ctx context.Context) ([]cdkey.PackInfo, error) {
+	return l.s.ListPacks(), nil
+}
+
+func (l local) AddPack(ctx context.Context, name string, prefix string, keylen, packsize int, note string) error {
+	return l.s.AddPackContext(ctx, name, prefix, keylen, packsize, note)
+}
+
+func (l local) EnablePack(ctx context.Context, name string) error {
+	return l.s.EnablePackContext(ctx, name)
+}
+
+func (l local) DisablePack(ctx context.Context, name string, msg string) error {
+	return l.s.DisablePackContext(ctx, name, msg)
+}
+
+func (l local) RemovePack(ctx context.Context, name string) error {
+	return l.s.RemovePackContext(ctx, name)
+}
+
+func (l local) ExtendPack(ctx context.Context, name string, count int) error {
+	return l.s.ExtendPackContext(ctx, name, count)
+}
+
+func (l local) ListKeys(ctx context.Context, pack string) ([]cdkey.KeyInfo, error) {
+	return l.s.ListKeysContext(ctx, pack)
+}
+
+func (l local) CheckKey(ctx context.Context, pack, key string) (cdkey.KeyInfo, error) {
+	return l.s.CheckKeyContext(ctx, pack, key)
+}
+
+func (l local) UseKey(ctx context.Context, pack, key string) error {
+	return l.s.UseKeyContext(ctx, pack, key)
+}
+
+func (l local) RevokeKey(ctx context.Context, pack, key string) error {
+	return l.s.RevokeKeyContext(ctx, pack, key)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *output != "table" && *output != "json" {
+		fail(fmt.Errorf("unknown output format %q", *output))
+	}
+
+	var b backend
+	switch {
+	case *dir != "":
+		s, err := cdkey.NewServerWithOptions(*dir, cdkey.Options{})
+		if err != nil {
+			fail(err)
+		}
+		defer s.Stop()
+		b = local{s}
+	case *serverURL != "":
+		b = client.New(*serverURL, client.Options{Token: *token})
+	default:
+		fail(errors.New("either -server or -d is required"))
+	}
+
+	if err := run(context.Background(), b, flag.Args(), os.Stdout); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "cdkeyctl:", err)
+	os.Exit(1)
+}
+
+func run(ctx context.Context, b backend, args []string, w io.Writer) error {
+	cmd, args := args[0]+" "+args[1], args[2:]
+
+	switch cmd {
+	case "pack list":
+		packs, err := b.ListPacks(ctx)
+		if err != nil {
+			return err
+		}
+		return printPacks(w, packs)
+
+	case "pack create":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		prefix := fs.String("prefix", "", "key prefix, base32")
+		keylen := fs.Int("keylen", 12, "key length including the prefix")
+		size := fs.Int("size", 1000, "number of keys")
+		note := fs.String("note", "", "note")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			return errors.New("usage: pack create [-prefix P] [-keylen N] [-size N] [-note S] NAME")
+		}
+
+		name := fs.Arg(0)
+		if err := b.AddPack(ctx, name, *prefix, *keylen, *size, *note); err != nil {
+			return err
+		}
+		return printPack(ctx, b, w, name)
+
+	case "pack enable", "pack remove":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %v NAME", cmd)
+		}
+
+		if cmd == "pack remove" {
+			if err := b.RemovePack(ctx, args[0]); err != nil {
+				return err
+			}
+			return printResult(w, map[string]string{"pack": args[0], "status": "removed"})
+		}
+
+		if err := b.EnablePack(ctx, args[0]); err != nil {
+			return err
+		}
+		return printPack(ctx, b, w, args[0])
+
+	case "pack disable":
+		if len(args) != 1 && len(args) != 2 {
+			return errors.New("usage: pack disable NAME [MSG]")
+		}
+
+		msg := "disabled"
+		if len(args) == 2 {
+			msg = args[1]
+		}
+		if err := b.DisablePack(ctx, args[0], msg); err != nil {
+			return err
+		}
+		return printPack(ctx, b, w, args[0])
+
+	case "pack extend":
+		if len(args) != 2 {
+			return errors.New("usage: pack extend NAME COUNT")
+		}
+
+		count, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("bad count %q", args[1])
+		}
+		if err := b.ExtendPack(ctx, args[0], count); err != nil {
+			return err
+		}
+		return printPack(ctx, b, w, args[0])
+
+	case "pack export":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		status := fs.String("status", "", "only keys with this status, e.g. Ready")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			return errors.New("usage: pack export [-status S] NAME")
+		}
+
+		keys, err := b.ListKeys(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		return exportKeys(w, keys, *status)
+
+	case "key check", "key use", "key revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: %v PACK KEY", cmd)
+		}
+		pack, key := args[0], args[1]
+
+		switch cmd {
+		case "key use":
+			if err := b.UseKey(ctx, pack, key); err != nil {
+				return err
+			}
+		case "key revoke":
+			if err := b.RevokeKey(ctx, pack, key); err != nil {
+				return err
+			}
+		}
+
+		info, err := b.CheckKey(ctx, pack, key)
+		if err != nil {
+			return err
+		}
+		return printKeys(w, []cdkey.KeyInfo{info})
+	}
+
+	return fmt.Errorf("unknown command %q, see cdkeyctl -h", cmd)
+}
+
+// printPack prints the pack after a command changed it.
+func printPack(ctx context.Context, b backend, w io.Writer, name string) error {
+	packs, err := b.ListPacks(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range packs {
+		if p.Name == name {
+			return printPacks(w, []cdkey.PackInfo{p})
+		}
+	}
+	return printResult(w, map[string]string{"pack": name, "status": "ok"})
+}
+
+func printJson(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printPacks(w io.Writer, packs []cdkey.PackInfo) error {
+	if *output == "json" {
+		if packs == nil {
+			packs = []cdkey.PackInfo{}
+		}
+		return printJson(w, packs)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATUS\tPREFIX\tKEYLEN\tPACKSIZE\tREADY\tUSED\tRESERVED\tREVOKED\tNOTE")
+	for _, p := range packs {
+		var stats cdkey.PackStats
+		if p.Stats != nil {
+			stats = *p.Stats
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", p.Name, p.Status, p.Prefix, p.KeyLen,
+			p.PackSize, stats.Ready, stats.Used, stats.Reserved, stats.Revoked, p.Note)
+	}
+	return tw.Flush()
+}
+
+func printKeys(w io.Writer, keys []cdkey.KeyInfo) error {
+	if *output == "json" {
+		return printJson(w, keys)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSTATUS")
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%v\t%v\n", k.Key, k.Status)
+	}
+	return tw.Flush()
+}
+
+func printResult(w io.Writer, result map[string]string) error {
+	if *output == "json" {
+		return printJson(w, result)
+	}
+
+	_, err := fmt.Fprintf(w, "pack %v %v\n", result["pack"], result["status"])
+	return err
+}
+
+// exportKeys writes keys with the given status, or all keys if status is
+// empty, as CSV or as JSON with -o json.
+func exportKeys(w io.Writer, keys []cdkey.KeyInfo, status string) error {
+	selected := []cdkey.KeyInfo{}
+	for _, k := range keys {
+		if status == "" || k.Status == status {
+			selected = append(selected, k)
+		}
+	}
+
+	if *output == "json" {
+		return printJson(w, selected)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"key", "status"})
+	for _, k := range selected {
+		cw.Write([]string{k.Key, k.Status})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,877 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        v5.27.1
+// source: cdkey.proto
+
+package cdkeypb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type KeyStatus int32
+
+const (
+	KeyStatus_KEY_STATUS_UNSPECIFIED KeyStatus = 0
+	KeyStatus_KEY_STATUS_READY       KeyStatus = 1
+	KeyStatus_KEY_STATUS_USED        KeyStatus = 2
+	KeyStatus_KEY_STATUS_RESERVED    KeyStatus = 3
+	KeyStatus_KEY_STATUS_REVOKED     KeyStatus = 4
+)
+
+// Enum value maps for KeyStatus.
+var (
+	KeyStatus_name = map[int32]string{
+		0: "KEY_STATUS_UNSPECIFIED",
+		1: "KEY_STATUS_READY",
+		2: "KEY_STATUS_USED",
+		3: "KEY_STATUS_RESERVED",
+		4: "KEY_STATUS_REVOKED",
+	}
+	KeyStatus_value = map[string]int32{
+		"KEY_STATUS_UNSPECIFIED": 0,
+		"KEY_STATUS_READY":       1,
+		"KEY_STATUS_USED":        2,
+		"KEY_STATUS_RESERVED":    3,
+		"KEY_STATUS_REVOKED":     4,
+	}
+)
+
+func (x KeyStatus) Enum() *KeyStatus {
+	p := new(KeyStatus)
+	*p = x
+	return p
+}
+
+func (x KeyStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (KeyStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_cdkey_proto_enumTypes[0].Descriptor()
+}
+
+func (KeyStatus) Type() protoreflect.EnumType {
+	return &file_cdkey_proto_enumTypes[0]
+}
+
+func (x KeyStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use KeyStatus.Descriptor instead.
+func (KeyStatus) EnumDescriptor() ([]byte, []int) {
+	return file_cdkey_proto_rawDescGZIP(), []int{0}
+}
+
+type Pack struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// name is project-qualified, e.g. "game1/summer".
+	Name     string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Prefix   string `protobuf:"bytes,2,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	Keylen   int32  `protobuf:"varint,3,opt,name=keylen,proto3" json:"keylen,omitempty"`
+	Packsize int32  `protobuf:"varint,4,opt,name=packsize,proto3" json:"packsize,omitempty"`
+	// status is "ready" or the message the pack was disabled with.
+	Status     string                 `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`
+	Note       string                 `protobuf:"bytes,6,opt,name=note,proto3" json:"note,omitempty"`
+	CreateTime *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=create_time,json=createTime,proto3" json:"create_time,omitempty"`
+	Project    string                 `protobuf:"bytes,8,opt,name=project,proto3" json:"project,omitempty"`
+	// error is set for broken packs.
+	Error         string `protobuf:"bytes,9,opt,name=error,proto3" json:"error,omitempty"`
+	Ready         int32  `protobuf:"varint,10,opt,name=ready,proto3" json:"ready,omitempty"`
+	Used          int32  `protobuf:"varint,11,opt,name=used,proto3" json:"used,omitempty"`
+	Reserved      int32  `protobuf:"varint,12,opt,name=reserved,proto3" json:"reserved,omitempty"`
+	Revoked       int32  `protobuf:"varint,13,opt,name=revoked,proto3" json:"revoked,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Pack) Reset() {
+	*x = Pack{}
+	mi := &file_cdkey_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Pack) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Pack) ProtoMessage() {}
+
+func (x *Pack) ProtoReflect() protoreflect.Message {
+	mi := &file_cdkey_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Pack.ProtoReflect.Descriptor instead.
+func (*Pack) Descriptor() ([]byte, []int) {
+	return file_cdkey_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Pack) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Pack) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *Pack) GetKeylen() int32 {
+	if x != nil {
+		return x.Keylen
+	}
+	return 0
+}
+
+func (x *Pack) GetPacksize() int32 {
+	if x != nil {
+		return x.Packsize
+	}
+	return 0
+}
+
+func (x *Pack) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Pack) GetNote() string {
+	if x != nil {
+		return x.Note
+	}
+	return ""
+}
+
+func (x *Pack) GetCreateTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreateTime
+	}
+	return nil
+}
+
+func (x *Pack) GetProject() string {
+	if x != nil {
+		return x.Project
+	}
+	return ""
+}
+
+func (x *Pack) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Pack) GetReady() int32 {
+	if x != nil {
+		return x.Ready
+	}
+	return 0
+}
+
+func (x *Pack) GetUsed() int32 {
+	if x != nil {
+		return x.Used
+	}
+	return 0
+}
+
+func (x *Pack) GetReserved() int32 {
+	if x != nil {
+		return x.Reserved
+	}
+	return 0
+}
+
+func (x *Pack) GetRevoked() int32 {
+	if x != nil {
+		return x.Revoked
+	}
+	return 0
+}
+
+type ListPacksRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// project filters the packs by project, "" is the default project.
+	Project       *string `protobuf:"bytes,1,opt,name=project,proto3,oneof" json:"project,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPacksRequest) Reset() {
+	*x = ListPacksRequest{}
+	mi := &file_cdkey_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPacksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPacksRequest) ProtoMessage() {}
+
+func (x *ListPacksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_cdkey_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPacksRequest.ProtoReflect.Descriptor instead.
+func (*ListPacksRequest) Descriptor() ([]byte, []int) {
+	return file_cdkey_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListPacksRequest) GetProject() string {
+	if x != nil && x.Project != nil {
+		return *x.Project
+	}
+	return ""
+}
+
+type ListPacksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Packs         []*Pack                `protobuf:"bytes,1,rep,name=packs,proto3" json:"packs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPacksResponse) Reset() {
+	*x = ListPacksResponse{}
+	mi := &file_cdkey_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPacksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPacksResponse) ProtoMessage() {}
+
+func (x *ListPacksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_cdkey_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPacksResponse.ProtoReflect.Descriptor instead.
+func (*ListPacksResponse) Descriptor() ([]byte, []int) {
+	return file_cdkey_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListPacksResponse) GetPacks() []*Pack {
+	if x != nil {
+		return x.Packs
+	}
+	return nil
+}
+
+type PackRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pack          string                 `protobuf:"bytes,1,opt,name=pack,proto3" json:"pack,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PackRequest) Reset() {
+	*x = PackRequest{}
+	mi := &file_cdkey_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PackRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PackRequest) ProtoMessage() {}
+
+func (x *PackRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_cdkey_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PackRequest.ProtoReflect.Descriptor instead.
+func (*PackRequest) Descriptor() ([]byte, []int) {
+	return file_cdkey_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *PackRequest) GetPack() string {
+	if x != nil {
+		return x.Pack
+	}
+	return ""
+}
+
+type AddPackRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Prefix        string                 `protobuf:"bytes,2,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	Keylen        int32                  `protobuf:"varint,3,opt,name=keylen,proto3" json:"keylen,omitempty"`
+	Packsize      int32                  `protobuf:"varint,4,opt,name=packsize,proto3" json:"packsize,omitempty"`
+	Note          string                 `protobuf:"bytes,5,opt,name=note,proto3" json:"note,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddPackRequest) Reset() {
+	*x = AddPackRequest{}
+	mi := &file_cdkey_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddPackRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddPackRequest) ProtoMessage() {}
+
+func (x *AddPackRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_cdkey_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddPackRequest.ProtoReflect.Descriptor instead.
+func (*AddPackRequest) Descriptor() ([]byte, []int) {
+	return file_cdkey_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AddPackRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *AddPackRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *AddPackRequest) GetKeylen() int32 {
+	if x != nil {
+		return x.Keylen
+	}
+	return 0
+}
+
+func (x *AddPackRequest) GetPacksize() int32 {
+	if x != nil {
+		return x.Packsize
+	}
+	return 0
+}
+
+func (x *AddPackRequest) GetNote() string {
+	if x != nil {
+		return x.Note
+	}
+	return ""
+}
+
+type DisablePackRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pack          string                 `protobuf:"bytes,1,opt,name=pack,proto3" json:"pack,omitempty"`
+	Msg           string                 `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DisablePackRequest) Reset() {
+	*x = DisablePackRequest{}
+	mi := &file_cdkey_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DisablePackRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisablePackRequest) ProtoMessage() {}
+
+func (x *DisablePackRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_cdkey_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisablePackRequest.ProtoReflect.Descriptor instead.
+func (*DisablePackRequest) Descriptor() ([]byte, []int) {
+	return file_cdkey_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DisablePackRequest) GetPack() string {
+	if x != nil {
+		return x.Pack
+	}
+	return ""
+}
+
+func (x *DisablePackRequest) GetMsg() string {
+	if x != nil {
+		return x.Msg
+	}
+	return ""
+}
+
+type ExtendPackRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pack          string                 `protobuf:"bytes,1,opt,name=pack,proto3" json:"pack,omitempty"`
+	Count         int32                  `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExtendPackRequest) Reset() {
+	*x = ExtendPackRequest{}
+	mi := &file_cdkey_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExtendPackRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExtendPackRequest) ProtoMessage() {}
+
+func (x *ExtendPackRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_cdkey_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExtendPackRequest.ProtoReflect.Descriptor instead.
+func (*ExtendPackRequest) Descriptor() ([]byte, []int) {
+	return file_cdkey_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ExtendPackRequest) GetPack() string {
+	if x != nil {
+		return x.Pack
+	}
+	return ""
+}
+
+func (x *ExtendPackRequest) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type KeyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pack          string                 `protobuf:"bytes,1,opt,name=pack,proto3" json:"pack,omitempty"`
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KeyRequest) Reset() {
+	*x = KeyRequest{}
+	mi := &file_cdkey_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyRequest) ProtoMessage() {}
+
+func (x *KeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_cdkey_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyRequest.ProtoReflect.Descriptor instead.
+func (*KeyRequest) Descriptor() ([]byte, []int) {
+	return file_cdkey_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *KeyRequest) GetPack() string {
+	if x != nil {
+		return x.Pack
+	}
+	return ""
+}
+
+func (x *KeyRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+type Key struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Pack  string                 `protobuf:"bytes,1,opt,name=pack,proto3" json:"pack,omitempty"`
+	// key is normalized.
+	Key           string    `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Status        KeyStatus `protobuf:"varint,3,opt,name=status,proto3,enum=cdkey.v1.KeyStatus" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Key) Reset() {
+	*x = Key{}
+	mi := &file_cdkey_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Key) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Key) ProtoMessage() {}
+
+func (x *Key) ProtoReflect() protoreflect.Message {
+	mi := &file_cdkey_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Key.ProtoReflect.Descriptor instead.
+func (*Key) Descriptor() ([]byte, []int) {
+	return file_cdkey_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Key) GetPack() string {
+	if x != nil {
+		return x.Pack
+	}
+	return ""
+}
+
+func (x *Key) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *Key) GetStatus() KeyStatus {
+	if x != nil {
+		return x.Status
+	}
+	return KeyStatus_KEY_STATUS_UNSPECIFIED
+}
+
+// Error is the detail of every error status, see cdkey.StatusError.
+type Error struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          int32                  `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	Msg           string                 `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg,omitempty"`
+	Cmd           string                 `protobuf:"bytes,3,opt,name=cmd,proto3" json:"cmd,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Error) Reset() {
+	*x = Error{}
+	mi := &file_cdkey_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Error) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Error) ProtoMessage() {}
+
+func (x *Error) ProtoReflect() protoreflect.Message {
+	mi := &file_cdkey_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Error.ProtoReflect.Descriptor instead.
+func (*Error) Descriptor() ([]byte, []int) {
+	return file_cdkey_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Error) GetCode() int32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *Error) GetMsg() string {
+	if x != nil {
+		return x.Msg
+	}
+	return ""
+}
+
+func (x *Error) GetCmd() string {
+	if x != nil {
+		return x.Cmd
+	}
+	return ""
+}
+
+var File_cdkey_proto protoreflect.FileDescriptor
+
+const file_cdkey_proto_rawDesc = "" +
+	"\n" +
+	"\vcdkey.proto\x12\bcdkey.v1\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xdf\x02\n" +
+	"\x04Pack\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06prefix\x18\x02 \x01(\tR\x06prefix\x12\x16\n" +
+	"\x06keylen\x18\x03 \x01(\x05R\x06keylen\x12\x1a\n" +
+	"\bpacksize\x18\x04 \x01(\x05R\bpacksize\x12\x16\n" +
+	"\x06status\x18\x05 \x01(\tR\x06status\x12\x12\n" +
+	"\x04note\x18\x06 \x01(\tR\x04note\x12;\n" +
+	"\vcreate_time\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"createTime\x12\x18\n" +
+	"\aproject\x18\b \x01(\tR\aproject\x12\x14\n" +
+	"\x05error\x18\t \x01(\tR\x05error\x12\x14\n" +
+	"\x05ready\x18\n" +
+	" \x01(\x05R\x05ready\x12\x12\n" +
+	"\x04used\x18\v \x01(\x05R\x04used\x12\x1a\n" +
+	"\breserved\x18\f \x01(\x05R\breserved\x12\x18\n" +
+	"\arevoked\x18\r \x01(\x05R\arevoked\"=\n" +
+	"\x10ListPacksRequest\x12\x1d\n" +
+	"\aproject\x18\x01 \x01(\tH\x00R\aproject\x88\x01\x01B\n" +
+	"\n" +
+	"\b_project\"9\n" +
+	"\x11ListPacksResponse\x12$\n" +
+	"\x05packs\x18\x01 \x03(\v2\x0e.cdkey.v1.PackR\x05packs\"!\n" +
+	"\vPackRequest\x12\x12\n" +
+	"\x04pack\x18\x01 \x01(\tR\x04pack\"\x84\x01\n" +
+	"\x0eAddPackRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06prefix\x18\x02 \x01(\tR\x06prefix\x12\x16\n" +
+	"\x06keylen\x18\x03 \x01(\x05R\x06keylen\x12\x1a\n" +
+	"\bpacksize\x18\x04 \x01(\x05R\bpacksize\x12\x12\n" +
+	"\x04note\x18\x05 \x01(\tR\x04note\":\n" +
+	"\x12DisablePackRequest\x12\x12\n" +
+	"\x04pack\x18\x01 \x01(\tR\x04pack\x12\x10\n" +
+	"\x03msg\x18\x02 \x01(\tR\x03msg\"=\n" +
+	"\x11ExtendPackRequest\x12\x12\n" +
+	"\x04pack\x18\x01 \x01(\tR\x04pack\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x05R\x05count\"2\n" +
+	"\n" +
+	"KeyRequest\x12\x12\n" +
+	"\x04pack\x18\x01 \x01(\tR\x04pack\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\"X\n" +
+	"\x03Key\x12\x12\n" +
+	"\x04pack\x18\x01 \x01(\tR\x04pack\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\x12+\n" +
+	"\x06status\x18\x03 \x01(\x0e2\x13.cdkey.v1.KeyStatusR\x06status\"?\n" +
+	"\x05Error\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\x05R\x04code\x12\x10\n" +
+	"\x03msg\x18\x02 \x01(\tR\x03msg\x12\x10\n" +
+	"\x03cmd\x18\x03 \x01(\tR\x03cmd*\x83\x01\n" +
+	"\tKeyStatus\x12\x1a\n" +
+	"\x16KEY_STATUS_UNSPECIFIED\x10\x00\x12\x14\n" +
+	"\x10KEY_STATUS_READY\x10\x01\x12\x13\n" +
+	"\x0fKEY_STATUS_USED\x10\x02\x12\x17\n" +
+	"\x13KEY_STATUS_RESERVED\x10\x03\x12\x16\n" +
+	"\x12KEY_STATUS_REVOKED\x10\x042\x96\x05\n" +
+	"\x05CDKey\x12D\n" +
+	"\tListPacks\x12\x1a.cdkey.v1.ListPacksRequest\x1a\x1b.cdkey.v1.ListPacksResponse\x120\n" +
+	"\aGetPack\x12\x15.cdkey.v1.PackRequest\x1a\x0e.cdkey.v1.Pack\x123\n" +
+	"\aAddPack\x12\x18.cdkey.v1.AddPackRequest\x1a\x0e.cdkey.v1.Pack\x12;\n" +
+	"\n" +
+	"RemovePack\x12\x15.cdkey.v1.PackRequest\x1a\x16.google.protobuf.Empty\x123\n" +
+	"\n" +
+	"EnablePack\x12\x15.cdkey.v1.PackRequest\x1a\x0e.cdkey.v1.Pack\x12;\n" +
+	"\vDisablePack\x12\x1c.cdkey.v1.DisablePackRequest\x1a\x0e.cdkey.v1.Pack\x129\n" +
+	"\n" +
+	"ExtendPack\x12\x1b.cdkey.v1.ExtendPackRequest\x1a\x0e.cdkey.v1.Pack\x12/\n" +
+	"\bCheckKey\x12\x14.cdkey.v1.KeyRequest\x1a\r.cdkey.v1.Key\x12-\n" +
+	"\x06UseKey\x12\x14.cdkey.v1.KeyRequest\x1a\r.cdkey.v1.Key\x121\n" +
+	"\n" +
+	"ReserveKey\x12\x14.cdkey.v1.KeyRequest\x1a\r.cdkey.v1.Key\x121\n" +
+	"\n" +
+	"ReleaseKey\x12\x14.cdkey.v1.KeyRequest\x1a\r.cdkey.v1.Key\x120\n" +
+	"\tRevokeKey\x12\x14.cdkey.v1.KeyRequest\x1a\r.cdkey.v1.KeyB Z\x1egithub.com/yxpod/cdkey/cdkeypbb\x06proto3"
+
+var (
+	file_cdkey_proto_rawDescOnce sync.Once
+	file_cdkey_proto_rawDescData []byte
+)
+
+func file_cdkey_proto_rawDescGZIP() []byte {
+	file_cdkey_proto_rawDescOnce.Do(func() {
+		file_cdkey_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_cdkey_proto_rawDesc), len(file_cdkey_proto_rawDesc)))
+	})
+	return file_cdkey_proto_rawDescData
+}
+
+var file_cdkey_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_cdkey_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_cdkey_proto_goTypes = []any{
+	(KeyStatus)(0),                // 0: cdkey.v1.KeyStatus
+	(*Pack)(nil),                  // 1: cdkey.v1.Pack
+	(*ListPacksRequest)(nil),      // 2: cdkey.v1.ListPacksRequest
+	(*ListPacksResponse)(nil),     // 3: cdkey.v1.ListPacksResponse
+	(*PackRequest)(nil),           // 4: cdkey.v1.PackRequest
+	(*AddPackRequest)(nil),        // 5: cdkey.v1.AddPackRequest
+	(*DisablePackRequest)(nil),    // 6: cdkey.v1.DisablePackRequest
+	(*ExtendPackRequest)(nil),     // 7: cdkey.v1.ExtendPackRequest
+	(*KeyRequest)(nil),            // 8: cdkey.v1.KeyRequest
+	(*Key)(nil),                   // 9: cdkey.v1.Key
+	(*Error)(nil),                 // 10: cdkey.v1.Error
+	(*timestamppb.Timestamp)(nil), // 11: google.protobuf.Timestamp
+	(*emptypb.Empty)(nil),         // 12: google.protobuf.Empty
+}
+var file_cdkey_proto_depIdxs = []int32{
+	11, // 0: cdkey.v1.Pack.create_time:type_name -> google.protobuf.Timestamp
+	1,  // 1: cdkey.v1.ListPacksResponse.packs:type_name -> cdkey.v1.Pack
+	0,  // 2: cdkey.v1.Key.status:type_name -> cdkey.v1.KeyStatus
+	2,  // 3: cdkey.v1.CDKey.ListPacks:input_type -> cdkey.v1.ListPacksRequest
+	4,  // 4: cdkey.v1.CDKey.GetPack:input_type -> cdkey.v1.PackRequest
+	5,  // 5: cdkey.v1.CDKey.AddPack:input_type -> cdkey.v1.AddPackRequest
+	4,  // 6: cdkey.v1.CDKey.RemovePack:input_type -> cdkey.v1.PackRequest
+	4,  // 7: cdkey.v1.CDKey.EnablePack:input_type -> cdkey.v1.PackRequest
+	6,  // 8: cdkey.v1.CDKey.DisablePack:input_type -> cdkey.v1.DisablePackRequest
+	7,  // 9: cdkey.v1.CDKey.ExtendPack:input_type -> cdkey.v1.ExtendPackRequest
+	8,  // 10: cdkey.v1.CDKey.CheckKey:input_type -> cdkey.v1.KeyRequest
+	8,  // 11: cdkey.v1.CDKey.UseKey:input_type -> cdkey.v1.KeyRequest
+	8,  // 12: cdkey.v1.CDKey.ReserveKey:input_type -> cdkey.v1.KeyRequest
+	8,  // 13: cdkey.v1.CDKey.ReleaseKey:input_type -> cdkey.v1.KeyRequest
+	8,  // 14: cdkey.v1.CDKey.RevokeKey:input_type -> cdkey.v1.KeyRequest
+	3,  // 15: cdkey.v1.CDKey.ListPacks:output_type -> cdkey.v1.ListPacksResponse
+	1,  // 16: cdkey.v1.CDKey.GetPack:output_type -> cdkey.v1.Pack
+	1,  // 17: cdkey.v1.CDKey.AddPack:output_type -> cdkey.v1.Pack
+	12, // 18: cdkey.v1.CDKey.RemovePack:output_type -> google.protobuf.Empty
+	1,  // 19: cdkey.v1.CDKey.EnablePack:output_type -> cdkey.v1.Pack
+	1,  // 20: cdkey.v1.CDKey.DisablePack:output_type -> cdkey.v1.Pack
+	1,  // 21: cdkey.v1.CDKey.ExtendPack:output_type -> cdkey.v1.Pack
+	9,  // 22: cdkey.v1.CDKey.CheckKey:output_type -> cdkey.v1.Key
+	9,  // 23: cdkey.v1.CDKey.UseKey:output_type -> cdkey.v1.Key
+	9,  // 24: cdkey.v1.CDKey.ReserveKey:output_type -> cdkey.v1.Key
+	9,  // 25: cdkey.v1.CDKey.ReleaseKey:output_type -> cdkey.v1.Key
+	9,  // 26: cdkey.v1.CDKey.RevokeKey:output_type -> cdkey.v1.Key
+	15, // [15:27] is the sub-list for method output_type
+	3,  // [3:15] is the sub-list for method input_type
+	3,  // [3:3] is the sub-list for extension type_name
+	3,  // [3:3] is the sub-list for extension extendee
+	0,  // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_cdkey_proto_init() }
+func file_cdkey_proto_init() {
+	if File_cdkey_proto != nil {
+		return
+	}
+	file_cdkey_proto_msgTypes[1].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_cdkey_proto_rawDesc), len(file_cdkey_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_cdkey_proto_goTypes,
+		DependencyIndexes: file_cdkey_proto_depIdxs,
+		EnumInfos:         file_cdkey_proto_enumTypes,
+		MessageInfos:      file_cdkey_proto_msgTypes,
+	}.Build()
+	File_cdkey_proto = out.File
+	file_cdkey_proto_goTypes = nil
+	file_cdkey_proto_depIdxs = nil
+}
@@ -0,0 +1,555 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             v5.27.1
+// source: cdkey.proto
+
+package cdkeypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	CDKey_ListPacks_FullMethodName   = "/cdkey.v1.CDKey/ListPacks"
+	CDKey_GetPack_FullMethodName     = "/cdkey.v1.CDKey/GetPack"
+	CDKey_AddPack_FullMethodName     = "/cdkey.v1.CDKey/AddPack"
+	CDKey_RemovePack_FullMethodName  = "/cdkey.v1.CDKey/RemovePack"
+	CDKey_EnablePack_FullMethodName  = "/cdkey.v1.CDKey/EnablePack"
+	CDKey_DisablePack_FullMethodName = "/cdkey.v1.CDKey/DisablePack"
+	CDKey_ExtendPack_FullMethodName  = "/cdkey.v1.CDKey/ExtendPack"
+	CDKey_CheckKey_FullMethodName    = "/cdkey.v1.CDKey/CheckKey"
+	CDKey_UseKey_FullMethodName      = "/cdkey.v1.CDKey/UseKey"
+	CDKey_ReserveKey_FullMethodName  = "/cdkey.v1.CDKey/ReserveKey"
+	CDKey_ReleaseKey_FullMethodName  = "/cdkey.v1.CDKey/ReleaseKey"
+	CDKey_RevokeKey_FullMethodName   = "/cdkey.v1.CDKey/RevokeKey"
+)
+
+// CDKeyClient is the client API for CDKey service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// CDKey is the gRPC service of the cdkey server. It covers pack management and
+// key redemption, with the same semantics and permissions as the HTTP API.
+//
+// The API token is sent in the "authorization" metadata as "Bearer <token>".
+// Errors carry the cdkey StatusError as an Error detail, with a gRPC code
+// derived from its HTTP status.
+type CDKeyClient interface {
+	ListPacks(ctx context.Context, in *ListPacksRequest, opts ...grpc.CallOption) (*ListPacksResponse, error)
+	GetPack(ctx context.Context, in *PackRequest, opts ...grpc.CallOption) (*Pack, error)
+	AddPack(ctx context.Context, in *AddPackRequest, opts ...grpc.CallOption) (*Pack, error)
+	RemovePack(ctx context.Context, in *PackRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	EnablePack(ctx context.Context, in *PackRequest, opts ...grpc.CallOption) (*Pack, error)
+	DisablePack(ctx context.Context, in *DisablePackRequest, opts ...grpc.CallOption) (*Pack, error)
+	// ExtendPack generates more ready keys for a pack.
+	ExtendPack(ctx context.Context, in *ExtendPackRequest, opts ...grpc.CallOption) (*Pack, error)
+	// CheckKey returns the status of a key without changing it.
+	CheckKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Key, error)
+	// UseKey redeems a ready or reserved key.
+	UseKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Key, error)
+	// ReserveKey holds a ready key until it is used or released.
+	ReserveKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Key, error)
+	// ReleaseKey makes a reserved key ready again.
+	ReleaseKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Key, error)
+	// RevokeKey makes a ready or reserved key unusable.
+	RevokeKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Key, error)
+}
+
+type cDKeyClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCDKeyClient(cc grpc.ClientConnInterface) CDKeyClient {
+	return &cDKeyClient{cc}
+}
+
+func (c *cDKeyClient) ListPacks(ctx context.Context, in *ListPacksRequest, opts ...grpc.CallOption) (*ListPacksResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListPacksResponse)
+	err := c.cc.Invoke(ctx, CDKey_ListPacks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cDKeyClient) GetPack(ctx context.Context, in *PackRequest, opts ...grpc.CallOption) (*Pack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Pack)
+	err := c.cc.Invoke(ctx, CDKey_GetPack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cDKeyClient) AddPack(ctx context.Context, in *AddPackRequest, opts ...grpc.CallOption) (*Pack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Pack)
+	err := c.cc.Invoke(ctx, CDKey_AddPack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cDKeyClient) RemovePack(ctx context.Context, in *PackRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, CDKey_RemovePack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cDKeyClient) EnablePack(ctx context.Context, in *PackRequest, opts ...grpc.CallOption) (*Pack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Pack)
+	err := c.cc.Invoke(ctx, CDKey_EnablePack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cDKeyClient) DisablePack(ctx context.Context, in *DisablePackRequest, opts ...grpc.CallOption) (*Pack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Pack)
+	err := c.cc.Invoke(ctx, CDKey_DisablePack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cDKeyClient) ExtendPack(ctx context.Context, in *ExtendPackRequest, opts ...grpc.CallOption) (*Pack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Pack)
+	err := c.cc.Invoke(ctx, CDKey_ExtendPack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cDKeyClient) CheckKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Key, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Key)
+	err := c.cc.Invoke(ctx, CDKey_CheckKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cDKeyClient) UseKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Key, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Key)
+	err := c.cc.Invoke(ctx, CDKey_UseKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cDKeyClient) ReserveKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Key, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Key)
+	err := c.cc.Invoke(ctx, CDKey_ReserveKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cDKeyClient) ReleaseKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Key, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Key)
+	err := c.cc.Invoke(ctx, CDKey_ReleaseKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cDKeyClient) RevokeKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Key, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Key)
+	err := c.cc.Invoke(ctx, CDKey_RevokeKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CDKeyServer is the server API for CDKey service.
+// All implementations must embed UnimplementedCDKeyServer
+// for forward compatibility
+//
+// CDKey is the gRPC service of the cdkey server. It covers pack management and
+// key redemption, with the same semantics and permissions as the HTTP API.
+//
+// The API token is sent in the "authorization" metadata as "Bearer <token>".
+// Errors carry the cdkey StatusError as an Error detail, with a gRPC code
+// derived from its HTTP status.
+type CDKeyServer interface {
+	ListPacks(context.Context, *ListPacksRequest) (*ListPacksResponse, error)
+	GetPack(context.Context, *PackRequest) (*Pack, error)
+	AddPack(context.Context, *AddPackRequest) (*Pack, error)
+	RemovePack(context.Context, *PackRequest) (*emptypb.Empty, error)
+	EnablePack(context.Context, *PackRequest) (*Pack, error)
+	DisablePack(context.Context, *DisablePackRequest) (*Pack, error)
+	// ExtendPack generates more ready keys for a pack.
+	ExtendPack(context.Context, *ExtendPackRequest) (*Pack, error)
+	// CheckKey returns the status of a key without changing it.
+	CheckKey(context.Context, *KeyRequest) (*Key, error)
+	// UseKey redeems a ready or reserved key.
+	UseKey(context.Context, *KeyRequest) (*Key, error)
+	// ReserveKey holds a ready key until it is used or released.
+	ReserveKey(context.Context, *KeyRequest) (*Key, error)
+	// ReleaseKey makes a reserved key ready again.
+	ReleaseKey(context.Context, *KeyRequest) (*Key, error)
+	// RevokeKey makes a ready or reserved key unusable.
+	RevokeKey(context.Context, *KeyRequest) (*Key, error)
+	mustEmbedUnimplementedCDKeyServer()
+}
+
+// UnimplementedCDKeyServer must be embedded to have forward compatible implementations.
+type UnimplementedCDKeyServer struct {
+}
+
+func (UnimplementedCDKeyServer) ListPacks(context.Context, *ListPacksRequest) (*ListPacksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPacks not implemented")
+}
+func (UnimplementedCDKeyServer) GetPack(context.Context, *PackRequest) (*Pack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPack not implemented")
+}
+func (UnimplementedCDKeyServer) AddPack(context.Context, *AddPackRequest) (*Pack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddPack not implemented")
+}
+func (UnimplementedCDKeyServer) RemovePack(context.Context, *PackRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemovePack not implemented")
+}
+func (UnimplementedCDKeyServer) EnablePack(context.Context, *PackRequest) (*Pack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnablePack not implemented")
+}
+func (UnimplementedCDKeyServer) DisablePack(context.Context, *DisablePackRequest) (*Pack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisablePack not implemented")
+}
+func (UnimplementedCDKeyServer) ExtendPack(context.Context, *ExtendPackRequest) (*Pack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExtendPack not implemented")
+}
+func (UnimplementedCDKeyServer) CheckKey(context.Context, *KeyRequest) (*Key, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckKey not implemented")
+}
+func (UnimplementedCDKeyServer) UseKey(context.Context, *KeyRequest) (*Key, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UseKey not implemented")
+}
+func (UnimplementedCDKeyServer) ReserveKey(context.Context, *KeyRequest) (*Key, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReserveKey not implemented")
+}
+func (UnimplementedCDKeyServer) ReleaseKey(context.Context, *KeyRequest) (*Key, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseKey not implemented")
+}
+func (UnimplementedCDKeyServer) RevokeKey(context.Context, *KeyRequest) (*Key, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeKey not implemented")
+}
+func (UnimplementedCDKeyServer) mustEmbedUnimplementedCDKeyServer() {}
+
+// UnsafeCDKeyServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CDKeyServer will
+// result in compilation errors.
+type UnsafeCDKeyServer interface {
+	mustEmbedUnimplementedCDKeyServer()
+}
+
+func RegisterCDKeyServer(s grpc.ServiceRegistrar, srv CDKeyServer) {
+	s.RegisterService(&CDKey_ServiceDesc, srv)
+}
+
+func _CDKey_ListPacks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPacksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CDKeyServer).ListPacks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CDKey_ListPacks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CDKeyServer).ListPacks(ctx, req.(*ListPacksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CDKey_GetPack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CDKeyServer).GetPack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CDKey_GetPack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CDKeyServer).GetPack(ctx, req.(*PackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CDKey_AddPack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddPackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CDKeyServer).AddPack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CDKey_AddPack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CDKeyServer).AddPack(ctx, req.(*AddPackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CDKey_RemovePack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CDKeyServer).RemovePack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CDKey_RemovePack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CDKeyServer).RemovePack(ctx, req.(*PackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CDKey_EnablePack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CDKeyServer).EnablePack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CDKey_EnablePack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CDKeyServer).EnablePack(ctx, req.(*PackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CDKey_DisablePack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisablePackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CDKeyServer).DisablePack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CDKey_DisablePack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CDKeyServer).DisablePack(ctx, req.(*DisablePackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CDKey_ExtendPack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtendPackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CDKeyServer).ExtendPack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CDKey_ExtendPack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CDKeyServer).ExtendPack(ctx, req.(*ExtendPackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CDKey_CheckKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CDKeyServer).CheckKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CDKey_CheckKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CDKeyServer).CheckKey(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CDKey_UseKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CDKeyServer).UseKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CDKey_UseKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CDKeyServer).UseKey(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CDKey_ReserveKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CDKeyServer).ReserveKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CDKey_ReserveKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CDKeyServer).ReserveKey(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CDKey_ReleaseKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CDKeyServer).ReleaseKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CDKey_ReleaseKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CDKeyServer).ReleaseKey(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CDKey_RevokeKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CDKeyServer).RevokeKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CDKey_RevokeKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CDKeyServer).RevokeKey(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CDKey_ServiceDesc is the grpc.ServiceDesc for CDKey service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CDKey_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cdkey.v1.CDKey",
+	HandlerType: (*CDKeyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListPacks",
+			Handler:    _CDKey_ListPacks_Handler,
+		},
+		{
+			MethodName: "GetPack",
+			Handler:    _CDKey_GetPack_Handler,
+		},
+		{
+			MethodName: "AddPack",
+			Handler:    _CDKey_AddPack_Handler,
+		},
+		{
+			MethodName: "RemovePack",
+			Handler:    _CDKey_RemovePack_Handler,
+		},
+		{
+			MethodName: "EnablePack",
+			Handler:    _CDKey_EnablePack_Handler,
+		},
+		{
+			MethodName: "DisablePack",
+			Handler:    _CDKey_DisablePack_Handler,
+		},
+		{
+			MethodName: "ExtendPack",
+			Handler:    _CDKey_ExtendPack_Handler,
+		},
+		{
+			MethodName: "CheckKey",
+			Handler:    _CDKey_CheckKey_Handler,
+		},
+		{
+			MethodName: "UseKey",
+			Handler:    _CDKey_UseKey_Handler,
+		},
+		{
+			MethodName: "ReserveKey",
+			Handler:    _CDKey_ReserveKey_Handler,
+		},
+		{
+			MethodName: "ReleaseKey",
+			Handler:    _CDKey_ReleaseKey_Handler,
+		},
+		{
+			MethodName: "RevokeKey",
+			Handler:    _CDKey_RevokeKey_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "cdkey.proto",
+}
@@ -0,0 +1,5 @@
+// Package cdkeypb contains the protocol buffer messages and the gRPC service
+// of the cdkey server, generated from cdkey.proto.
+package cdkeypb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative cdkey.proto
@@ -0,0 +1,302 @@
+// Package client is a Go client of the cdkey HTTP API. It calls the dot
+// commands, e.g. /key.use, with the request and response types of the cdkey
+// package:
+//
+//	c := client.New("http://localhost:8080", client.Options{Token: token})
+//	err := c.UseKey(ctx, "game1/summer", "ABCD1234")
+//	if errors.Is(err, cdkey.ErrKeyUsed) { ... }
+//
+// Error responses are decoded into *cdkey.StatusError, so errors.Is works with
+// the ErrXxx sentinels. Commands failing with cdkey.ErrPackClosing, which
+// happens while a pack is reloaded or the server stops, are retried with
+// exponential backoff.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yxpod/cdkey"
+)
+
+// Options configures a Client. The zero value is usable.
+type Options struct {
+	// Token is the API token, sent as a bearer token.
+	Token string
+
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// MaxRetries is the number of retries of a command failing with
+	// ErrPackClosing, default 3. Negative disables retries.
+	MaxRetries int
+
+	// Backoff is the delay before the first retry, doubled on every retry,
+	// default 100ms.
+	Backoff time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 3
+	}
+	if o.Backoff == 0 {
+		o.Backoff = 100 * time.Millisecond
+	}
+	return o
+}
+
+// Client calls the commands of a cdkey server. It is safe for concurrent use.
+type Client struct {
+	url  string
+	opts Options
+}
+
+// New returns a Client of the server at url, e.g. "http://localhost:8080".
+func New(url string, opts Options) *Client {
+	return &Client{
+		url:  strings.TrimSuffix(url, "/"),
+		opts: opts.withDefaults(),
+	}
+}
+
+// call runs a command, retrying on ErrPackClosing. req may be nil for commands
+// without a body, rsp may be nil to discard the response.
+func (c *Client) call(ctx context.Context, cmd string, req, rsp interface{}) error {
+	body := []byte("{}")
+	if req != nil {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+
+	backoff := c.opts.Backoff
+	for retry := 0; ; retry++ {
+		err := c.post(ctx, cmd, body, rsp)
+		if !errors.Is(err, cdkey.ErrPackClosing) || retry >= c.opts.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) post(ctx context.Context, cmd string, body []byte, rsp interface{}) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/"+cmd, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if c.opts.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+
+	res, err := c.opts.HTTPClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		var e *cdkey.StatusError
+		if err := json.Unmarshal(b, &e); err != nil || e == nil || e.Code() == 0 {
+			return fmt.Errorf("cdkey: %v: %v", cmd, res.Status)
+		}
+		return e
+	}
+
+	if rsp == nil {
+		return nil
+	}
+	if err := json.Unmarshal(b, rsp); err != nil {
+		return fmt.Errorf("cdkey: %v: bad response: %w", cmd, err)
+	}
+	return nil
+}
+
+// ListPacks returns the packs visible to the token.
+func (c *Client) ListPacks(ctx context.Context) ([]cdkey.PackInfo, error) {
+	var rsp cdkey.PackListResponse
+	err := c.call(ctx, "pack.list", nil, &rsp)
+	return rsp.Packs, err
+}
+
+// ListProjectPacks returns the packs of a project visible to the token, ""
+// is the default project.
+func (c *Client) ListProjectPacks(ctx context.Context, project string) ([]cdkey.PackInfo, error) {
+	var rsp cdkey.PackListResponse
+	err := c.call(ctx, "pack.list", cdkey.PackListRequest{Project: &project}, &rsp)
+	return rsp.Packs, err
+}
+
+func (c *Client) AddPack(ctx context.Context, name string, prefix string, keylen, packsize int, note string) error {
+	return c.call(ctx, "pack.add", cdkey.PackAddRequest{
+		Name:     name,
+		Prefix:   prefix,
+		KeyLen:   keylen,
+		PackSize: packsize,
+		Note:     note,
+	}, nil)
+}
+
+func (c *Client) RemovePack(ctx context.Context, name string) error {
+	return c.call(ctx, "pack.remove", cdkey.PackRequest{Pack: name}, nil)
+}
+
+func (c *Client) EnablePack(ctx context.Context, name string) error {
+	return c.call(ctx, "pack.enable", cdkey.PackRequest{Pack: name}, nil)
+}
+
+func (c *Client) DisablePack(ctx context.Context, name string, msg string) error {
+	return c.call(ctx, "pack.disable", cdkey.PackDisableRequest{Pack: name, Msg: msg}, nil)
+}
+
+func (c *Client) ReloadPack(ctx context.Context, name string) error {
+	return c.call(ctx, "pack.reload", cdkey.PackRequest{Pack: name}, nil)
+}
+
+// ExtendPack generates count more keys for a pack.
+func (c *Client) ExtendPack(ctx context.Context, name string, count int) error {
+	return c.call(ctx, "pack.extend", cdkey.PackExtendRequest{Pack: name, Count: count}, nil)
+}
+
+func (c *Client) VerifyPack(ctx context.Context, name string, repair bool) (cdkey.VerifyReport, error) {
+	var rsp cdkey.PackVerifyResponse
+	err := c.call(ctx, "pack.verify", cdkey.PackVerifyRequest{Pack: name, Repair: repair}, &rsp)
+	return rsp.Report, err
+}
+
+// SetPackGuard sets the GuardConfig of a pack, nil restores the default.
+func (c *Client) SetPackGuard(ctx context.Context, name string, cfg *cdkey.GuardConfig) error {
+	return c.call(ctx, "pack.guard", cdkey.PackGuardRequest{Pack: name, Guard: cfg}, nil)
+}
+
+func (c *Client) ListKeys(ctx context.Context, pack string) ([]cdkey.KeyInfo, error) {
+	var rsp cdkey.KeyListResponse
+	err := c.call(ctx, "key.list", cdkey.PackRequest{Pack: pack}, &rsp)
+	return rsp.Keys, err
+}
+
+// QueryKeys returns a page of the keys of a pack and the number of all keys
+// matching q.
+func (c *Client) QueryKeys(ctx context.Context, pack string, q cdkey.KeyQuery) ([]cdkey.KeyInfo, int, error) {
+	var rsp cdkey.KeyListResponse
+	err := c.call(ctx, "key.list", cdkey.KeyListRequest{Pack: pack, KeyQuery: q}, &rsp)
+	return rsp.Keys, rsp.Total, err
+}
+
+// FindKey searches a key in the packs visible to the token.
+func (c *Client) FindKey(ctx context.Context, key string) ([]cdkey.KeyMatch, error) {
+	var rsp cdkey.KeyFindResponse
+	err := c.call(ctx, "key.find", cdkey.KeyFindRequest{Key: key}, &rsp)
+	return rsp.Matches, err
+}
+
+func (c *Client) UseKey(ctx context.Context, pack, key string) error {
+	return c.call(ctx, "key.use", cdkey.KeyUseRequest{Pack: pack, Key: key}, nil)
+}
+
+// CheckKey returns the status of a key without changing it.
+func (c *Client) CheckKey(ctx context.Context, pack, key string) (cdkey.KeyInfo, error) {
+	var rsp cdkey.KeyResponse
+	err := c.call(ctx, "key.check", cdkey.KeyRequest{Pack: pack, Key: key}, &rsp)
+	return cdkey.KeyInfo{Key: rsp.Key, Status: rsp.Status}, err
+}
+
+func (c *Client) RevokeKey(ctx context.Context, pack, key string) error {
+	return c.call(ctx, "key.revoke", cdkey.KeyRequest{Pack: pack, Key: key}, nil)
+}
+
+func (c *Client) AddProject(ctx context.Context, name string, maxPacks, maxKeys int, note string) error {
+	return c.call(ctx, "project.add", cdkey.ProjectAddRequest{
+		Name:     name,
+		MaxPacks: maxPacks,
+		MaxKeys:  maxKeys,
+		Note:     note,
+	}, nil)
+}
+
+func (c *Client) ListProjects(ctx context.Context) ([]cdkey.ProjectInfo, error) {
+	var rsp cdkey.ProjectListResponse
+	err := c.call(ctx, "project.list", nil, &rsp)
+	return rsp.Projects, err
+}
+
+func (c *Client) SetProjectQuota(ctx context.Context, name string, maxPacks, maxKeys int) error {
+	return c.call(ctx, "project.quota", cdkey.ProjectQuotaRequest{
+		Project:  name,
+		MaxPacks: maxPacks,
+		MaxKeys:  maxKeys,
+	}, nil)
+}
+
+func (c *Client) RemoveProject(ctx context.Context, name string) error {
+	return c.call(ctx, "project.remove", cdkey.ProjectRequest{Project: name}, nil)
+}
+
+func (c *Client) QueryAudit(ctx context.Context, q cdkey.AuditQuery) ([]cdkey.AuditRecord, error) {
+	var rsp cdkey.AuditQueryResponse
+	err := c.call(ctx, "audit.query", q, &rsp)
+	return rsp.Records, err
+}
+
+func (c *Client) AddWebhook(ctx context.Context, h cdkey.Webhook) (cdkey.Webhook, error) {
+	var rsp cdkey.WebhookResponse
+	err := c.call(ctx, "webhook.add", h, &rsp)
+	return rsp.Webhook, err
+}
+
+func (c *Client) ListWebhooks(ctx context.Context) ([]cdkey.Webhook, error) {
+	var rsp cdkey.WebhookListResponse
+	err := c.call(ctx, "webhook.list", nil, &rsp)
+	return rsp.Webhooks, err
+}
+
+func (c *Client) RemoveWebhook(ctx context.Context, id string) error {
+	return c.call(ctx, "webhook.remove", cdkey.IDRequest{ID: id}, nil)
+}
+
+func (c *Client) WebhookDeliveries(ctx context.Context, id string, limit int) ([]cdkey.Delivery, error) {
+	var rsp cdkey.WebhookDeliveriesResponse
+	err := c.call(ctx, "webhook.deliveries", cdkey.WebhookDeliveriesRequest{ID: id, Limit: limit}, &rsp)
+	return rsp.Deliveries, err
+}
+
+// CreateToken creates an API token and returns its secret.
+func (c *Client) CreateToken(ctx context.Context, name string, bindings []cdkey.RoleBinding) (string, cdkey.TokenInfo, error) {
+	var rsp cdkey.TokenCreateResponse
+	err := c.call(ctx, "token.create", cdkey.TokenCreateRequest{Name: name, Bindings: bindings}, &rsp)
+	return rsp.Token, rsp.TokenInfo, err
+}
+
+func (c *Client) ListTokens(ctx context.Context) ([]cdkey.TokenInfo, error) {
+	var rsp cdkey.TokenListResponse
+	err := c.call(ctx, "token.list", nil, &rsp)
+	return rsp.Tokens, err
+}
+
+func (c *Client) RevokeToken(ctx context.Context, id string) error {
+	return c.call(ctx, "token.revoke", cdkey.IDRequest{ID: id}, nil)
+}
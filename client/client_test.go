@@ -0,0 +1,242 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yxpod/cdkey"
+)
+
+// testServer serves a cdkey server on a temp dir, with the enabled pack
+// "summer", and returns its URL and the keys of the pack.
+func testServer(t *testing.T, opts cdkey.Options) (*cdkey.Server, string, []cdkey.KeyInfo) {
+	t.Helper()
+
+	s, err := cdkey.NewServerWithOptions(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+
+	if err := s.AddPack("summer", "S", 12, 20, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnablePack("summer"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := s.ListKeys("summer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(s.HTTPServeMux())
+	t.Cleanup(ts.Close)
+	return s, ts.URL, keys
+}
+
+func TestClientErrors(t *testing.T) {
+	_, url, keys := testServer(t, cdkey.Options{})
+	c := New(url, Options{})
+	ctx := context.Background()
+
+	if err := c.UseKey(ctx, "summer", keys[0].Key); err != nil {
+		t.Fatal(err)
+	}
+
+	err := c.UseKey(ctx, "summer", keys[0].Key)
+	if !errors.Is(err, cdkey.ErrKeyUsed) {
+		t.Errorf("use again: %v, want ErrKeyUsed", err)
+	}
+	var e *cdkey.StatusError
+	if !errors.As(err, &e) || e.Cmd() != "key.use" {
+		t.Errorf("use again: %#v, want a StatusError of key.use", err)
+	}
+
+	if err := c.UseKey(ctx, "summer", "S00000000000"); !errors.Is(err, cdkey.ErrKeyNotFound) {
+		t.Errorf("use unknown key: %v, want ErrKeyNotFound", err)
+	}
+	if err := c.UseKey(ctx, "winter", keys[1].Key); !errors.Is(err, cdkey.ErrPackNotFound) {
+		t.Errorf("use in unknown pack: %v, want ErrPackNotFound", err)
+	}
+	if err := c.AddPack(ctx, "summer", "T", 12, 20, ""); !errors.Is(err, cdkey.ErrPackAlreadyExists) {
+		t.Errorf("add existing pack: %v, want ErrPackAlreadyExists", err)
+	}
+
+	if err := c.RevokeKey(ctx, "summer", keys[1].Key); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UseKey(ctx, "summer", keys[1].Key); !errors.Is(err, cdkey.ErrKeyRevoked) {
+		t.Errorf("use revoked key: %v, want ErrKeyRevoked", err)
+	}
+	if info, err := c.CheckKey(ctx, "summer", keys[1].Key); err != nil || info.Status != "Revoked" {
+		t.Errorf("check revoked key: %+v, %v", info, err)
+	}
+
+	if err := c.DisablePack(ctx, "summer", "paused"); err != nil {
+		t.Fatal(err)
+	}
+	err = c.UseKey(ctx, "summer", keys[2].Key)
+	if !errors.Is(err, cdkey.ErrPackDisabled) {
+		t.Errorf("use in disabled pack: %v, want ErrPackDisabled", err)
+	}
+	if errors.Is(err, cdkey.ErrKeyUsed) {
+		t.Errorf("%v matches ErrKeyUsed", err)
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	s, url, keys := testServer(t, cdkey.Options{Auth: true})
+	secret, _, err := s.CreateToken("shop", []cdkey.RoleBinding{{Role: cdkey.RoleRedeemer, Packs: "summer"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := New(url, Options{}).UseKey(ctx, "summer", keys[0].Key); !errors.Is(err, cdkey.ErrUnauthorized) {
+		t.Errorf("no token: %v, want ErrUnauthorized", err)
+	}
+	if err := New(url, Options{Token: "wrong"}).UseKey(ctx, "summer", keys[0].Key); !errors.Is(err, cdkey.ErrUnauthorized) {
+		t.Errorf("wrong token: %v, want ErrUnauthorized", err)
+	}
+
+	c := New(url, Options{Token: secret})
+	if err := c.DisablePack(ctx, "summer", "paused"); !errors.Is(err, cdkey.ErrForbidden) {
+		t.Errorf("disable as redeemer: %v, want ErrForbidden", err)
+	}
+	if err := c.UseKey(ctx, "summer", keys[0].Key); err != nil {
+		t.Errorf("use with token: %v", err)
+	}
+}
+
+// flaky answers the first n requests with err and passes the others to h,
+// recording the time of every request.
+type flaky struct {
+	h   http.Handler
+	n   int
+	err *cdkey.StatusError
+
+	mtx   sync.Mutex
+	times []time.Time
+}
+
+func (f *flaky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	f.times = append(f.times, time.Now())
+	fail := len(f.times) <= f.n
+	f.mtx.Unlock()
+
+	if !fail {
+		f.h.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(f.err.HTTPCode())
+	w.Write([]byte(f.err.Json()))
+}
+
+func (f *flaky) requests() []time.Time {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return append([]time.Time(nil), f.times...)
+}
+
+func flakyServer(t *testing.T, n int, err *cdkey.StatusError) (*flaky, string, []cdkey.KeyInfo) {
+	t.Helper()
+
+	s, err2 := cdkey.NewServerWithOptions(t.TempDir(), cdkey.Options{})
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	t.Cleanup(s.Stop)
+
+	if err := s.AddPack("summer", "S", 12, 20, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnablePack("summer"); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := s.ListKeys("summer")
+
+	f := &flaky{h: s.HTTPServeMux(), n: n, err: err}
+	ts := httptest.NewServer(f)
+	t.Cleanup(ts.Close)
+	return f, ts.URL, keys
+}
+
+func TestClientRetry(t *testing.T) {
+	const backoff = 20 * time.Millisecond
+
+	f, url, keys := flakyServer(t, 2, cdkey.ErrPackClosing)
+	c := New(url, Options{MaxRetries: 3, Backoff: backoff})
+
+	if err := c.UseKey(context.Background(), "summer", keys[0].Key); err != nil {
+		t.Fatalf("use after 2 closing answers: %v", err)
+	}
+
+	times := f.requests()
+	if len(times) != 3 {
+		t.Fatalf("%v requests, want 3", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		want := backoff << uint(i-1)
+		if gap := times[i].Sub(times[i-1]); gap < want {
+			t.Errorf("retry %v after %v, want at least %v", i, gap, want)
+		}
+	}
+}
+
+func TestClientRetryGiveUp(t *testing.T) {
+	f, url, keys := flakyServer(t, 100, cdkey.ErrPackClosing)
+	c := New(url, Options{MaxRetries: 3, Backoff: time.Millisecond})
+
+	if err := c.UseKey(context.Background(), "summer", keys[0].Key); !errors.Is(err, cdkey.ErrPackClosing) {
+		t.Errorf("use: %v, want ErrPackClosing", err)
+	}
+	if n := len(f.requests()); n != 4 {
+		t.Errorf("%v requests, want 1 and 3 retries", n)
+	}
+}
+
+func TestClientNoRetry(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		err  *cdkey.StatusError
+		opts Options
+	}{
+		{"retries disabled", cdkey.ErrPackClosing, Options{MaxRetries: -1, Backoff: time.Millisecond}},
+		{"other error", cdkey.ErrTooManyRequests, Options{MaxRetries: 3, Backoff: time.Millisecond}},
+	} {
+		f, url, keys := flakyServer(t, 1, c.err)
+
+		if err := New(url, c.opts).UseKey(context.Background(), "summer", keys[0].Key); !errors.Is(err, c.err) {
+			t.Errorf("%v: %v, want %v", c.name, err, c.err)
+		}
+		if n := len(f.requests()); n != 1 {
+			t.Errorf("%v: %v requests, want 1", c.name, n)
+		}
+	}
+}
+
+func TestClientRetryCanceled(t *testing.T) {
+	f, url, keys := flakyServer(t, 100, cdkey.ErrPackClosing)
+	c := New(url, Options{MaxRetries: 3, Backoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := c.UseKey(ctx, "summer", keys[0].Key); !errors.Is(err, cdkey.ErrPackClosing) {
+		t.Errorf("use: %v, want ErrPackClosing", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("backoff not canceled, took %v", d)
+	}
+	if n := len(f.requests()); n != 1 {
+		t.Errorf("%v requests, want 1", n)
+	}
+}
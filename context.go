@@ -0,0 +1,17 @@
+package cdkey
+
+import "context"
+
+// checkInterval is how many keys are generated or iterated between two checks
+// of the context.
+const checkInterval = 1024
+
+// checkContext returns ErrCanceled, wrapping ctx.Err(), if ctx is done.
+func checkContext(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ErrCanceled.affix(ctx.Err())
+	default:
+		return nil
+	}
+}
@@ -6,45 +6,278 @@ import (
 	"net/http"
 )
 
-type statusError struct {
-	Code     int    `json:"code"`
-	HTTPCode int    `json:"-"`
-	Msg      string `json:"msg"`
-	Cmd      string `json:"cmd`
+// StatusError is the error type of all errors returned by the cdkey lib, and
+// of the error bodies returned by the HTTP API:
+//
+//	{"code":1006,"msg":"key already used, key:ABCD","cmd":"key.use"}
+//
+// Every StatusError carries a stable numeric code, use errors.Is with the
+// ErrXxx sentinels to test for it:
+//
+//	if errors.Is(err, cdkey.ErrKeyUsed) { ... }
+//
+// Errors caused by the storage or file system wrap the underlying error,
+// which is available through errors.Unwrap / errors.As.
+//
+// Code catalog. Codes are stable and never reused:
+//
+//	1xxx  request errors, caused by the caller
+//	1001  ErrBadRequest             400  malformed request
+//	1002  ErrPackNotFound           404  pack not found
+//	1003  ErrPackAlreadyExists      406  pack already exists
+//	1004  ErrPackDisabled           406  pack is disabled
+//	1005  ErrKeyNotFound            404  key not found
+//	1006  ErrKeyUsed                406  key already used
+//	1007  ErrInvalidPackName        406  invalid pack name
+//	1008  ErrInvalidPrefix          406  invalid prefix
+//	1009  ErrKeylenTooShort         406  keylen too short to generate packsize keys
+//	1010  ErrPackBroken             503  pack failed to load, see pack.reload
+//	1011  ErrUnauthorized           401  missing or invalid API token
+//	1012  ErrForbidden              403  API token not allowed to run the command
+//	1013  ErrTokenNotFound          404  API token not found
+//	1014  ErrProjectNotFound        404  project not found
+//	1015  ErrProjectAlreadyExists   406  project already exists
+//	1016  ErrProjectNotEmpty        406  project still has packs
+//	1017  ErrInvalidProjectName     406  invalid project name
+//	1018  ErrQuotaExceeded          406  project quota exceeded
+//	1019  ErrPrefixConflict         406  prefix overlaps another pack of the project
+//	1020  ErrTooManyRequests        429  redemption rate limited or source locked out
+//	1021  ErrWebhookNotFound        404  webhook not found
+//	1022  ErrKeyReserved            406  key already reserved
+//	1023  ErrKeyNotReserved         406  key.release of a key which is not reserved
+//	1024  ErrKeyRevoked             406  key revoked
+//
+//	2xxx  storage errors
+//	2001  ErrFailedCreateDB         503  failed create db on file system
+//	2002  ErrFailedLoadDB           503  failed load db from file system
+//	2003  ErrFailedLoadKeys         503  failed load keys from db
+//	2004  ErrFailedSaveKeys         503  failed save keys to db
+//	2005  ErrFailedLoadPackInfo     503  failed load pack info
+//	2006  ErrFailedSavePackInfo     503  failed save pack info
+//	2007  ErrFailedCreateDataDir    503  failed create data directory
+//	2008  ErrFailedAccessDataDir    503  failed access data directory
+//	2009  ErrFailedLoadTokens       503  failed load API tokens
+//	2010  ErrFailedSaveTokens       503  failed save API tokens
+//	2011  ErrFailedLoadProjectInfo  503  failed load project info
+//	2012  ErrFailedSaveProjectInfo  503  failed save project info
+//	2013  ErrFailedWriteAudit       503  failed write audit log
+//	2014  ErrFailedReadAudit        503  failed read audit log
+//	2015  ErrFailedLoadWebhooks     503  failed load webhooks or delivery queue
+//	2016  ErrFailedSaveWebhooks     503  failed save webhooks
+//
+//	3xxx  server errors
+//	3001  ErrInternal               500  unexpected error
+//	3002  ErrPackClosing            503  pack is closing, retry later
+//	3003  ErrCanceled               503  context canceled or deadline exceeded, wraps ctx.Err()
+//	3004  ErrServerStopping         503  server is shutting down, see Server.Drain
+type StatusError struct {
+	code     int
+	httpCode int
+	msg      string
+	cmd      string
+	err      error
 }
 
-func (e statusError) Json() string {
+func newStatusError(code, httpCode int, msg string) *StatusError {
+	return &StatusError{code: code, httpCode: httpCode, msg: msg}
+}
+
+// Code returns the cdkey error code, see the code catalog of StatusError.
+func (e *StatusError) Code() int {
+	return e.code
+}
+
+// HTTPCode returns the HTTP status code used when the error is returned by the
+// HTTP API.
+func (e *StatusError) HTTPCode() int {
+	return e.httpCode
+}
+
+// Msg returns the error message, including details added to the sentinel.
+func (e *StatusError) Msg() string {
+	return e.msg
+}
+
+// Cmd returns the command which caused the error, if known.
+func (e *StatusError) Cmd() string {
+	return e.cmd
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v:%v", e.code, e.msg)
+}
+
+// Unwrap returns the underlying cause, e.g. a LevelDB or file system error.
+func (e *StatusError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether target is a StatusError with the same code.
+func (e *StatusError) Is(target error) bool {
+	t, ok := target.(*StatusError)
+	return ok && t.code == e.code
+}
+
+func (e *StatusError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Cmd  string `json:"cmd"`
+	}{e.code, e.msg, e.cmd})
+}
+
+// UnmarshalJSON decodes an error body of the HTTP API. The HTTP code is taken
+// from the sentinel with the same code, so decoded errors work with errors.Is
+// and HTTPCode like the errors returned by the lib.
+func (e *StatusError) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Cmd  string `json:"cmd"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	*e = StatusError{code: v.Code, httpCode: http.StatusInternalServerError, msg: v.Msg, cmd: v.Cmd}
+	if s := lookupStatusError(v.Code); s != nil {
+		e.httpCode = s.httpCode
+	}
+	return nil
+}
+
+func (e *StatusError) Json() string {
 	b, _ := json.Marshal(e)
 	return string(b)
 }
 
-func (e statusError) Error() string {
-	return fmt.Sprintf("%v:%v", e.Code, e.Msg)
+// affix returns a copy of e with v appended to the message. If v is an error
+// it becomes the cause of the returned error.
+func (e *StatusError) affix(v interface{}) *StatusError {
+	c := *e
+	c.msg = fmt.Sprintf("%v, %v", e.msg, v)
+	if err, ok := v.(error); ok && c.err == nil {
+		c.err = err
+	}
+	return &c
 }
 
-func (e statusError) affix(v interface{}) statusError {
-	e.Msg = fmt.Sprintf("%v, %v", e.Msg, v)
-	return e
+func (e *StatusError) withCmd(cmd string) *StatusError {
+	c := *e
+	c.cmd = cmd
+	return &c
 }
 
 var (
-	errBadRequest        = statusError{1001, http.StatusBadRequest, "bad request", ""}
-	errPackNotFound      = statusError{1002, http.StatusNotFound, "pack not found", ""}
-	errPackAlreadyExists = statusError{1003, http.StatusNotAcceptable, "pack already exists", ""}
-	errPackDisabled      = statusError{1004, http.StatusNotAcceptable, "pack is disabled", ""}
-	errKeyNotFound       = statusError{1005, http.StatusNotFound, "key not found", ""}
-	errKeyUsed           = statusError{1006, http.StatusNotAcceptable, "key already used", ""}
-	errInvalidPackName   = statusError{1007, http.StatusNotAcceptable, "invalid pack name", ""}
-	errInvalidPrefix     = statusError{1008, http.StatusNotAcceptable, "invalid prefix, accept base32 only", ""}
-	errKeylenTooShort    = statusError{1009, http.StatusNotAcceptable, "keylen too short, unable to generate", ""}
-
-	errFailedCreateDB     = statusError{2001, http.StatusServiceUnavailable, "failed create db on file system", ""}
-	errFailedLoadDB       = statusError{2002, http.StatusServiceUnavailable, "failed load db from file system", ""}
-	errFailedLoadKeys     = statusError{2003, http.StatusServiceUnavailable, "failed load keys from db", ""}
-	errFailedSaveKeys     = statusError{2004, http.StatusServiceUnavailable, "failed save keys to db", ""}
-	errFailedLoadPackInfo = statusError{2005, http.StatusServiceUnavailable, "failed load pack info", ""}
-	errFailedSavePackInfo = statusError{2006, http.StatusServiceUnavailable, "failed save pack info", ""}
-
-	errInternal    = statusError{3001, http.StatusInternalServerError, "", ""}
-	errPackClosing = statusError{3002, http.StatusServiceUnavailable, "pack is closing", ""}
+	ErrBadRequest        = newStatusError(1001, http.StatusBadRequest, "bad request")
+	ErrPackNotFound      = newStatusError(1002, http.StatusNotFound, "pack not found")
+	ErrPackAlreadyExists = newStatusError(1003, http.StatusNotAcceptable, "pack already exists")
+	ErrPackDisabled      = newStatusError(1004, http.StatusNotAcceptable, "pack is disabled")
+	ErrKeyNotFound       = newStatusError(1005, http.StatusNotFound, "key not found")
+	ErrKeyUsed           = newStatusError(1006, http.StatusNotAcceptable, "key already used")
+	ErrInvalidPackName   = newStatusError(1007, http.StatusNotAcceptable, "invalid pack name")
+	ErrInvalidPrefix     = newStatusError(1008, http.StatusNotAcceptable, "invalid prefix, accept base32 only")
+	ErrKeylenTooShort    = newStatusError(1009, http.StatusNotAcceptable, "keylen too short, unable to generate")
+	ErrPackBroken        = newStatusError(1010, http.StatusServiceUnavailable, "pack is broken")
+	ErrUnauthorized      = newStatusError(1011, http.StatusUnauthorized, "unauthorized")
+	ErrForbidden         = newStatusError(1012, http.StatusForbidden, "forbidden")
+	ErrTokenNotFound     = newStatusError(1013, http.StatusNotFound, "token not found")
+
+	ErrProjectNotFound      = newStatusError(1014, http.StatusNotFound, "project not found")
+	ErrProjectAlreadyExists = newStatusError(1015, http.StatusNotAcceptable, "project already exists")
+	ErrProjectNotEmpty      = newStatusError(1016, http.StatusNotAcceptable, "project is not empty")
+	ErrInvalidProjectName   = newStatusError(1017, http.StatusNotAcceptable, "invalid project name")
+	ErrQuotaExceeded        = newStatusError(1018, http.StatusNotAcceptable, "project quota exceeded")
+	ErrPrefixConflict       = newStatusError(1019, http.StatusNotAcceptable, "prefix conflicts with another pack")
+	ErrTooManyRequests      = newStatusError(1020, http.StatusTooManyRequests, "too many requests")
+	ErrWebhookNotFound      = newStatusError(1021, http.StatusNotFound, "webhook not found")
+	ErrKeyReserved          = newStatusError(1022, http.StatusNotAcceptable, "key is reserved")
+	ErrKeyNotReserved       = newStatusError(1023, http.StatusNotAcceptable, "key is not reserved")
+	ErrKeyRevoked           = newStatusError(1024, http.StatusNotAcceptable, "key is revoked")
+
+	ErrFailedCreateDB      = newStatusError(2001, http.StatusServiceUnavailable, "failed create db on file system")
+	ErrFailedLoadDB        = newStatusError(2002, http.StatusServiceUnavailable, "failed load db from file system")
+	ErrFailedLoadKeys      = newStatusError(2003, http.StatusServiceUnavailable, "failed load keys from db")
+	ErrFailedSaveKeys      = newStatusError(2004, http.StatusServiceUnavailable, "failed save keys to db")
+	ErrFailedLoadPackInfo  = newStatusError(2005, http.StatusServiceUnavailable, "failed load pack info")
+	ErrFailedSavePackInfo  = newStatusError(2006, http.StatusServiceUnavailable, "failed save pack info")
+	ErrFailedCreateDataDir = newStatusError(2007, http.StatusServiceUnavailable, "failed create data directory")
+	ErrFailedAccessDataDir = newStatusError(2008, http.StatusServiceUnavailable, "failed access data directory")
+	ErrFailedLoadTokens    = newStatusError(2009, http.StatusServiceUnavailable, "failed load tokens")
+	ErrFailedSaveTokens    = newStatusError(2010, http.StatusServiceUnavailable, "failed save tokens")
+
+	ErrFailedLoadProjectInfo = newStatusError(2011, http.StatusServiceUnavailable, "failed load project info")
+	ErrFailedSaveProjectInfo = newStatusError(2012, http.StatusServiceUnavailable, "failed save project info")
+	ErrFailedWriteAudit      = newStatusError(2013, http.StatusServiceUnavailable, "failed write audit log")
+	ErrFailedReadAudit       = newStatusError(2014, http.StatusServiceUnavailable, "failed read audit log")
+	ErrFailedLoadWebhooks    = newStatusError(2015, http.StatusServiceUnavailable, "failed load webhooks")
+	ErrFailedSaveWebhooks    = newStatusError(2016, http.StatusServiceUnavailable, "failed save webhooks")
+
+	ErrInternal    = newStatusError(3001, http.StatusInternalServerError, "")
+	ErrPackClosing = newStatusError(3002, http.StatusServiceUnavailable, "pack is closing")
+	ErrCanceled    = newStatusError(3003, http.StatusServiceUnavailable, "request canceled")
+
+	ErrServerStopping = newStatusError(3004, http.StatusServiceUnavailable, "server is shutting down")
 )
+
+// errorCatalog lists every StatusError with its name, for the OpenAPI document
+// and for decoding errors. Keep it in sync with the code catalog above.
+var errorCatalog = []struct {
+	Name string
+	Err  *StatusError
+}{
+	{"ErrBadRequest", ErrBadRequest},
+	{"ErrPackNotFound", ErrPackNotFound},
+	{"ErrPackAlreadyExists", ErrPackAlreadyExists},
+	{"ErrPackDisabled", ErrPackDisabled},
+	{"ErrKeyNotFound", ErrKeyNotFound},
+	{"ErrKeyUsed", ErrKeyUsed},
+	{"ErrInvalidPackName", ErrInvalidPackName},
+	{"ErrInvalidPrefix", ErrInvalidPrefix},
+	{"ErrKeylenTooShort", ErrKeylenTooShort},
+	{"ErrPackBroken", ErrPackBroken},
+	{"ErrUnauthorized", ErrUnauthorized},
+	{"ErrForbidden", ErrForbidden},
+	{"ErrTokenNotFound", ErrTokenNotFound},
+	{"ErrProjectNotFound", ErrProjectNotFound},
+	{"ErrProjectAlreadyExists", ErrProjectAlreadyExists},
+	{"ErrProjectNotEmpty", ErrProjectNotEmpty},
+	{"ErrInvalidProjectName", ErrInvalidProjectName},
+	{"ErrQuotaExceeded", ErrQuotaExceeded},
+	{"ErrPrefixConflict", ErrPrefixConflict},
+	{"ErrTooManyRequests", ErrTooManyRequests},
+	{"ErrWebhookNotFound", ErrWebhookNotFound},
+	{"ErrKeyReserved", ErrKeyReserved},
+	{"ErrKeyNotReserved", ErrKeyNotReserved},
+	{"ErrKeyRevoked", ErrKeyRevoked},
+	{"ErrFailedCreateDB", ErrFailedCreateDB},
+	{"ErrFailedLoadDB", ErrFailedLoadDB},
+	{"ErrFailedLoadKeys", ErrFailedLoadKeys},
+	{"ErrFailedSaveKeys", ErrFailedSaveKeys},
+	{"ErrFailedLoadPackInfo", ErrFailedLoadPackInfo},
+	{"ErrFailedSavePackInfo", ErrFailedSavePackInfo},
+	{"ErrFailedCreateDataDir", ErrFailedCreateDataDir},
+	{"ErrFailedAccessDataDir", ErrFailedAccessDataDir},
+	{"ErrFailedLoadTokens", ErrFailedLoadTokens},
+	{"ErrFailedSaveTokens", ErrFailedSaveTokens},
+	{"ErrFailedLoadProjectInfo", ErrFailedLoadProjectInfo},
+	{"ErrFailedSaveProjectInfo", ErrFailedSaveProjectInfo},
+	{"ErrFailedWriteAudit", ErrFailedWriteAudit},
+	{"ErrFailedReadAudit", ErrFailedReadAudit},
+	{"ErrFailedLoadWebhooks", ErrFailedLoadWebhooks},
+	{"ErrFailedSaveWebhooks", ErrFailedSaveWebhooks},
+	{"ErrInternal", ErrInternal},
+	{"ErrPackClosing", ErrPackClosing},
+	{"ErrCanceled", ErrCanceled},
+	{"ErrServerStopping", ErrServerStopping},
+}
+
+// lookupStatusError returns the sentinel with the given code, or nil.
+func lookupStatusError(code int) *StatusError {
+	for _, c := range errorCatalog {
+		if c.Err.code == code {
+			return c.Err
+		}
+	}
+	return nil
+}
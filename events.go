@@ -0,0 +1,243 @@
+package cdkey
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types, published to the event hub.
+const (
+	EventKeyUsed       = "key.used"
+	EventKeyReserved   = "key.reserved"
+	EventKeyReleased   = "key.released"
+	EventKeyRevoked    = "key.revoked"
+	EventPackAdded     = "pack.added"
+	EventPackEnabled   = "pack.enabled"
+	EventPackDisabled  = "pack.disabled"
+	EventPackExhausted = "pack.exhausted"
+	EventPackExtended  = "pack.extended"
+	EventPackRemoved   = "pack.removed"
+)
+
+// Event is something that happened to a pack.
+type Event struct {
+	Type     string    `json:"type"`
+	Pack     string    `json:"pack"`
+	Key      string    `json:"key,omitempty"`
+	Ready    int       `json:"ready"`
+	Used     int       `json:"used"`
+	Reserved int       `json:"reserved"`
+	Revoked  int       `json:"revoked"`
+	PackSize int       `json:"packsize"`
+	Time     time.Time `json:"time"`
+}
+
+// hub is the internal publish/subscribe hub of events. Listeners are called
+// synchronously by publish and must be fast; subscribers receive events
+// through a buffered channel and miss events while their buffer is full.
+type hub struct {
+	clock     Clock
+	log       libLogger
+	listeners []func(Event)
+	subs      map[chan Event]func(Event) bool
+	closed    bool
+	done      chan struct{} // closed by close
+	mtx       sync.RWMutex
+}
+
+func newHub(clock Clock, log libLogger) *hub {
+	return &hub{
+		clock: clock,
+		log:   log,
+		subs:  make(map[chan Event]func(Event) bool),
+		done:  make(chan struct{}),
+	}
+}
+
+func (h *hub) listen(fn func(Event)) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	h.listeners = append(h.listeners, fn)
+}
+
+// subscribe returns a channel receiving the events accepted by filter. The
+// channel is closed by unsubscribe or when the hub is closed.
+func (h *hub) subscribe(filter func(Event) bool, buf int) chan Event {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	ch := make(chan Event, buf)
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.subs[ch] = filter
+	return ch
+}
+
+func (h *hub) unsubscribe(ch chan Event) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func (h *hub) publish(e Event) {
+	if h == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = h.clock.Now()
+	}
+
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+
+	for _, fn := range h.listeners {
+		fn(e)
+	}
+
+	for ch, filter := range h.subs {
+		if filter != nil && !filter(e) {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+			h.log.warn_log("event dropped, subscriber too slow", "type", e.Type, "pack", e.Pack)
+		}
+	}
+}
+
+func (h *hub) close() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+	close(h.done)
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// event returns an Event of the pack with its current stats.
+func (p *Pack) event(typ string, key string) Event {
+	p.infoMtx.RLock()
+	defer p.infoMtx.RUnlock()
+
+	return p.eventLocked(typ, key)
+}
+
+// eventLocked is like event, the caller must hold p.infoMtx.
+func (p *Pack) eventLocked(typ string, key string) Event {
+	stats := p.Stats()
+	return Event{
+		Type:     typ,
+		Pack:     p.Name,
+		Key:      key,
+		Ready:    stats.Ready,
+		Used:     stats.Used,
+		Reserved: stats.Reserved,
+		Revoked:  stats.Revoked,
+		PackSize: p.info.PackSize,
+	}
+}
+
+// Subscribe returns a channel receiving the events of the packs matching the
+// pattern, in the syntax of RoleBinding. The channel is closed once ctx is
+// done or the server stops. Events are dropped while the channel is full.
+func (s *Server) Subscribe(ctx context.Context, packs string) <-chan Event {
+	b := RoleBinding{Packs: packs}
+	ch := s.hub.subscribe(func(e Event) bool {
+		return b.matches(e.Pack)
+	}, 256)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.hub.unsubscribe(ch)
+		case <-s.hub.done:
+		}
+	}()
+
+	return ch
+}
+
+// handleEvents streams events as Server-Sent Events. The query parameters
+// pack (a pattern, default "*") and type (comma separated event types) filter
+// the stream. Only events of packs the caller may view are sent.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		putStatusError(w, "events", ErrInternal.affix("streaming unsupported"))
+		return
+	}
+
+	packs := r.URL.Query().Get("pack")
+	if packs == "" {
+		packs = "*"
+	}
+	if err := (RoleBinding{Role: RoleViewer, Packs: packs}).validate(); err != nil {
+		putStatusError(w, "events", err)
+		return
+	}
+
+	types := make(map[string]bool)
+	for _, typ := range strings.Split(r.URL.Query().Get("type"), ",") {
+		if typ != "" {
+			types[typ] = true
+		}
+	}
+
+	ctx := r.Context()
+	events := s.Subscribe(ctx, packs)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.log.info_logc(ctx, "events stream opened", "remote", r.RemoteAddr, "pack", packs)
+	defer s.log.info_logc(ctx, "events stream closed", "remote", r.RemoteAddr, "pack", packs)
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if len(types) > 0 && !types[e.Type] {
+				continue
+			}
+			if s.Authorize(ctx, PermPackView, e.Pack) != nil {
+				continue
+			}
+
+			b, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %v\ndata: %s\n\n", e.Type, b)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package cdkey
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSubscribeServerStop(t *testing.T) {
+	s, err := NewServerWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := runtime.NumGoroutine()
+	events := s.Subscribe(context.Background(), "*")
+	s.Stop()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("event after stop")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed on stop")
+	}
+
+	for i := 0; runtime.NumGoroutine() > before && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%v goroutines after stop, %v before subscribe", n, before)
+	}
+}
+
+func TestPublishOutsidePackLocks(t *testing.T) {
+	s, err := NewServerWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if err := s.AddPack("summer", "S", 12, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnablePack("summer"); err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.lookupPack("summer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A listener taking the pack locks deadlocks if events are published
+	// while holding them.
+	s.hub.listen(func(e Event) {
+		p.Info()
+		p.useMtx.Lock()
+		p.useMtx.Unlock()
+	})
+
+	keys, err := s.ListKeys("summer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.UseKey("summer", keys[0].Key) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("UseKey deadlocked with a listener taking the pack locks")
+	}
+}
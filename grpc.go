@@ -0,0 +1,322 @@
+package cdkey
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/yxpod/cdkey/cdkeypb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer returns a grpc.Server serving the CDKey service of cdkeypb on top
+// of s. Calls are authenticated like the HTTP API, with the API token in the
+// "authorization" metadata as "Bearer <token>" or, given TLS credentials in
+// opts, with a client certificate of Options.ClientCerts. They need the same
+// permissions as the matching dot commands.
+func (s *Server) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(s.grpcAuth))
+	gs := grpc.NewServer(opts...)
+	cdkeypb.RegisterCDKeyServer(gs, grpcService{s: s})
+	return gs
+}
+
+// grpcAuth is the gRPC counterpart of AuthHandler.
+func (s *Server) grpcAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	id := firstMD(md, "x-request-id")
+	if id == "" {
+		id, _ = randomHex(8)
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+
+	var tlsState *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr := p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		ctx = WithClientAddr(ctx, addr)
+
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			tlsState = &info.State
+		}
+	}
+	ctx = WithLogFields(ctx, "requestId", id)
+
+	if !s.opts.Auth {
+		return handler(ctx, req)
+	}
+
+	secret := strings.TrimPrefix(firstMD(md, "authorization"), "Bearer ")
+	if secret == "" {
+		secret = firstMD(md, "x-cdkey-token")
+	}
+
+	t, ok := s.tokens.authenticate(secret)
+	if !ok {
+		t, ok = s.tokens.authenticateCert(tlsState)
+	}
+	if !ok {
+		s.log.warn_logc(ctx, "unauthorized request", "method", info.FullMethod)
+		return nil, grpcError(info.FullMethod, ErrUnauthorized)
+	}
+
+	return handler(context.WithValue(ctx, principalKey{}, t), req)
+}
+
+func firstMD(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// grpcCode maps the HTTP status of a StatusError, as returned by the /v1 API,
+// to a gRPC code.
+func grpcCode(e *StatusError) codes.Code {
+	switch {
+	case errors.Is(e, ErrPackAlreadyExists), errors.Is(e, ErrProjectAlreadyExists):
+		return codes.AlreadyExists
+	case errors.Is(e, ErrCanceled):
+		if errors.Is(e, context.DeadlineExceeded) {
+			return codes.DeadlineExceeded
+		}
+		return codes.Canceled
+	}
+
+	switch restHTTPCode(e) {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict, http.StatusNotAcceptable:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+// grpcError converts err to a gRPC status error carrying the StatusError as a
+// cdkeypb.Error detail.
+func grpcError(cmd string, err error) error {
+	var e *StatusError
+	if !errors.As(err, &e) {
+		e = ErrInternal.affix(err)
+	}
+	e = e.withCmd(cmd)
+
+	st := status.New(grpcCode(e), e.Error())
+	if d, err := st.WithDetails(&cdkeypb.Error{Code: int32(e.Code()), Msg: e.Msg(), Cmd: e.Cmd()}); err == nil {
+		st = d
+	}
+	return st.Err()
+}
+
+// grpcService implements cdkeypb.CDKeyServer on top of Server.
+type grpcService struct {
+	cdkeypb.UnimplementedCDKeyServer
+	s *Server
+}
+
+func (g grpcService) pack(info PackInfo) *cdkeypb.Pack {
+	p := &cdkeypb.Pack{
+		Name:       info.Name,
+		Prefix:     info.Prefix,
+		Keylen:     int32(info.KeyLen),
+		Packsize:   int32(info.PackSize),
+		Status:     string(info.Status),
+		Note:       info.Note,
+		CreateTime: timestamppb.New(info.CreateTime),
+		Project:    info.Project,
+		Error:      info.Error,
+	}
+	if stats := info.Stats; stats != nil {
+		p.Ready, p.Used = int32(stats.Ready), int32(stats.Used)
+		p.Reserved, p.Revoked = int32(stats.Reserved), int32(stats.Revoked)
+	}
+	return p
+}
+
+func (g grpcService) packInfo(cmd, name string) (*cdkeypb.Pack, error) {
+	info, err := g.s.GetPackInfo(name)
+	if err != nil {
+		return nil, grpcError(cmd, err)
+	}
+	return g.pack(info), nil
+}
+
+func (g grpcService) ListPacks(ctx context.Context, req *cdkeypb.ListPacksRequest) (*cdkeypb.ListPacksResponse, error) {
+	all := g.s.ListPacks()
+	if req.Project != nil {
+		all = g.s.ListProjectPacks(req.GetProject())
+	}
+
+	rsp := &cdkeypb.ListPacksResponse{}
+	for _, p := range all {
+		if g.s.Authorize(ctx, PermPackView, p.Name) == nil {
+			rsp.Packs = append(rsp.Packs, g.pack(p))
+		}
+	}
+	return rsp, nil
+}
+
+func (g grpcService) GetPack(ctx context.Context, req *cdkeypb.PackRequest) (*cdkeypb.Pack, error) {
+	if err := g.s.Authorize(ctx, PermPackView, req.Pack); err != nil {
+		return nil, grpcError("pack.get", err)
+	}
+	return g.packInfo("pack.get", req.Pack)
+}
+
+func (g grpcService) AddPack(ctx context.Context, req *cdkeypb.AddPackRequest) (*cdkeypb.Pack, error) {
+	if err := g.s.Authorize(ctx, PermPackAdmin, req.Name); err != nil {
+		return nil, grpcError("pack.add", err)
+	}
+
+	if err := g.s.AddPackContext(ctx, req.Name, req.Prefix, int(req.Keylen), int(req.Packsize), req.Note); err != nil {
+		return nil, grpcError("pack.add", err)
+	}
+	return g.packInfo("pack.add", req.Name)
+}
+
+func (g grpcService) RemovePack(ctx context.Context, req *cdkeypb.PackRequest) (*emptypb.Empty, error) {
+	if err := g.s.Authorize(ctx, PermPackAdmin, req.Pack); err != nil {
+		return nil, grpcError("pack.remove", err)
+	}
+
+	if err := g.s.RemovePackContext(ctx, req.Pack); err != nil {
+		return nil, grpcError("pack.remove", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (g grpcService) EnablePack(ctx context.Context, req *cdkeypb.PackRequest) (*cdkeypb.Pack, error) {
+	if err := g.s.Authorize(ctx, PermPackManage, req.Pack); err != nil {
+		return nil, grpcError("pack.enable", err)
+	}
+
+	if err := g.s.EnablePackContext(ctx, req.Pack); err != nil {
+		return nil, grpcError("pack.enable", err)
+	}
+	return g.packInfo("pack.enable", req.Pack)
+}
+
+func (g grpcService) DisablePack(ctx context.Context, req *cdkeypb.DisablePackRequest) (*cdkeypb.Pack, error) {
+	if err := g.s.Authorize(ctx, PermPackManage, req.Pack); err != nil {
+		return nil, grpcError("pack.disable", err)
+	}
+
+	if err := g.s.DisablePackContext(ctx, req.Pack, req.Msg); err != nil {
+		return nil, grpcError("pack.disable", err)
+	}
+	return g.packInfo("pack.disable", req.Pack)
+}
+
+func (g grpcService) ExtendPack(ctx context.Context, req *cdkeypb.ExtendPackRequest) (*cdkeypb.Pack, error) {
+	if err := g.s.Authorize(ctx, PermPackAdmin, req.Pack); err != nil {
+		return nil, grpcError("pack.extend", err)
+	}
+
+	if err := g.s.ExtendPackContext(ctx, req.Pack, int(req.Count)); err != nil {
+		return nil, grpcError("pack.extend", err)
+	}
+	return g.packInfo("pack.extend", req.Pack)
+}
+
+func (g grpcService) key(pack, key string, status keyStatus) *cdkeypb.Key {
+	key, _ = NormalizeKey(key)
+
+	k := &cdkeypb.Key{Pack: pack, Key: key}
+	switch status {
+	case keyReady:
+		k.Status = cdkeypb.KeyStatus_KEY_STATUS_READY
+	case keyUsed:
+		k.Status = cdkeypb.KeyStatus_KEY_STATUS_USED
+	case keyReserved:
+		k.Status = cdkeypb.KeyStatus_KEY_STATUS_RESERVED
+	case keyRevoked:
+		k.Status = cdkeypb.KeyStatus_KEY_STATUS_REVOKED
+	}
+	return k
+}
+
+func (g grpcService) CheckKey(ctx context.Context, req *cdkeypb.KeyRequest) (*cdkeypb.Key, error) {
+	if err := g.s.Authorize(ctx, PermKeyUse, req.Pack); err != nil {
+		return nil, grpcError("key.check", err)
+	}
+
+	info, err := g.s.CheckKeyContext(ctx, req.Pack, req.Key)
+	if err != nil {
+		return nil, grpcError("key.check", err)
+	}
+
+	status := keyUsed
+	for _, s := range []keyStatus{keyReady, keyReserved, keyRevoked} {
+		if info.Status == s.String() {
+			status = s
+		}
+	}
+	return g.key(req.Pack, info.Key, status), nil
+}
+
+func (g grpcService) UseKey(ctx context.Context, req *cdkeypb.KeyRequest) (*cdkeypb.Key, error) {
+	if err := g.s.Authorize(ctx, PermKeyUse, req.Pack); err != nil {
+		return nil, grpcError("key.use", err)
+	}
+
+	if err := g.s.UseKeyContext(ctx, req.Pack, req.Key); err != nil {
+		return nil, grpcError("key.use", err)
+	}
+	return g.key(req.Pack, req.Key, keyUsed), nil
+}
+
+func (g grpcService) ReserveKey(ctx context.Context, req *cdkeypb.KeyRequest) (*cdkeypb.Key, error) {
+	if err := g.s.Authorize(ctx, PermKeyUse, req.Pack); err != nil {
+		return nil, grpcError("key.reserve", err)
+	}
+
+	if err := g.s.ReserveKeyContext(ctx, req.Pack, req.Key); err != nil {
+		return nil, grpcError("key.reserve", err)
+	}
+	return g.key(req.Pack, req.Key, keyReserved), nil
+}
+
+func (g grpcService) ReleaseKey(ctx context.Context, req *cdkeypb.KeyRequest) (*cdkeypb.Key, error) {
+	if err := g.s.Authorize(ctx, PermKeyUse, req.Pack); err != nil {
+		return nil, grpcError("key.release", err)
+	}
+
+	if err := g.s.ReleaseKeyContext(ctx, req.Pack, req.Key); err != nil {
+		return nil, grpcError("key.release", err)
+	}
+	return g.key(req.Pack, req.Key, keyReady), nil
+}
+
+func (g grpcService) RevokeKey(ctx context.Context, req *cdkeypb.KeyRequest) (*cdkeypb.Key, error) {
+	if err := g.s.Authorize(ctx, PermPackManage, req.Pack); err != nil {
+		return nil, grpcError("key.revoke", err)
+	}
+
+	if err := g.s.RevokeKeyContext(ctx, req.Pack, req.Key); err != nil {
+		return nil, grpcError("key.revoke", err)
+	}
+	return g.key(req.Pack, req.Key, keyRevoked), nil
+}
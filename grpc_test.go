@@ -0,0 +1,341 @@
+package cdkey
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yxpod/cdkey/cdkeypb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for cn, a server certificate for the host
+// "localhost" if server is set and a client certificate otherwise.
+func (ca *testCA) issue(t *testing.T, cn string, server bool) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.DNSNames = []string{"localhost"}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// dialGRPC serves s.GRPCServer over an in-memory connection and returns a
+// client connected to it.
+func dialGRPC(t *testing.T, s *Server, serverOpts []grpc.ServerOption, creds credentials.TransportCredentials) cdkeypb.CDKeyClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	gs := s.GRPCServer(serverOpts...)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.NewClient("passthrough:///localhost",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return cdkeypb.NewCDKeyClient(conn)
+}
+
+// grpcTestServer returns a server with the enabled pack "summer" and its keys.
+func grpcTestServer(t *testing.T, opts Options) (*Server, []KeyInfo) {
+	t.Helper()
+
+	s, err := NewServerWithOptions(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+
+	if err := s.AddPack("summer", "S", 12, 20, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnablePack("summer"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := s.ListKeys("summer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, keys
+}
+
+// checkGRPCError fails unless err is a gRPC status with code and a
+// cdkeypb.Error detail of want.
+func checkGRPCError(t *testing.T, what string, err error, code codes.Code, want *StatusError) {
+	t.Helper()
+
+	st, ok := status.FromError(err)
+	if !ok {
+		t.Errorf("%v: %v is not a gRPC status", what, err)
+		return
+	}
+	if st.Code() != code {
+		t.Errorf("%v: code %v, want %v: %v", what, st.Code(), code, err)
+	}
+
+	for _, d := range st.Details() {
+		if e, ok := d.(*cdkeypb.Error); ok {
+			if int(e.Code) != want.Code() {
+				t.Errorf("%v: detail code %v, want %v", what, e.Code, want.Code())
+			}
+			return
+		}
+	}
+	t.Errorf("%v: no cdkeypb.Error detail in %v", what, err)
+}
+
+func TestGRPCKeys(t *testing.T) {
+	s, keys := grpcTestServer(t, Options{})
+	c := dialGRPC(t, s, nil, nil)
+	ctx := context.Background()
+
+	k, err := c.CheckKey(ctx, &cdkeypb.KeyRequest{Pack: "summer", Key: keys[0].Key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Status != cdkeypb.KeyStatus_KEY_STATUS_READY {
+		t.Errorf("check: status %v, want ready", k.Status)
+	}
+
+	k, err = c.UseKey(ctx, &cdkeypb.KeyRequest{Pack: "summer", Key: keys[0].Key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Status != cdkeypb.KeyStatus_KEY_STATUS_USED || k.Key != keys[0].Key {
+		t.Errorf("use: %v", k)
+	}
+
+	_, err = c.UseKey(ctx, &cdkeypb.KeyRequest{Pack: "summer", Key: keys[0].Key})
+	checkGRPCError(t, "use again", err, codes.FailedPrecondition, ErrKeyUsed)
+
+	k, err = c.RevokeKey(ctx, &cdkeypb.KeyRequest{Pack: "summer", Key: keys[1].Key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Status != cdkeypb.KeyStatus_KEY_STATUS_REVOKED {
+		t.Errorf("revoke: status %v, want revoked", k.Status)
+	}
+
+	k, err = c.CheckKey(ctx, &cdkeypb.KeyRequest{Pack: "summer", Key: keys[1].Key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Status != cdkeypb.KeyStatus_KEY_STATUS_REVOKED {
+		t.Errorf("check revoked: status %v", k.Status)
+	}
+
+	_, err = c.UseKey(ctx, &cdkeypb.KeyRequest{Pack: "summer", Key: keys[1].Key})
+	checkGRPCError(t, "use revoked", err, codes.FailedPrecondition, ErrKeyRevoked)
+
+	_, err = c.RevokeKey(ctx, &cdkeypb.KeyRequest{Pack: "summer", Key: keys[0].Key})
+	checkGRPCError(t, "revoke used", err, codes.FailedPrecondition, ErrKeyUsed)
+
+	_, err = c.CheckKey(ctx, &cdkeypb.KeyRequest{Pack: "summer", Key: "S00000000000"})
+	checkGRPCError(t, "check unknown key", err, codes.NotFound, ErrKeyNotFound)
+
+	_, err = c.CheckKey(ctx, &cdkeypb.KeyRequest{Pack: "winter", Key: keys[2].Key})
+	checkGRPCError(t, "check unknown pack", err, codes.NotFound, ErrPackNotFound)
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	for _, c := range []struct {
+		err  error
+		code codes.Code
+		want *StatusError
+	}{
+		{ErrPackAlreadyExists, codes.AlreadyExists, ErrPackAlreadyExists},
+		{ErrProjectAlreadyExists, codes.AlreadyExists, ErrProjectAlreadyExists},
+		{checkContext(ctx), codes.DeadlineExceeded, ErrCanceled},
+		{ErrCanceled.affix(context.Canceled), codes.Canceled, ErrCanceled},
+		{ErrBadRequest, codes.InvalidArgument, ErrBadRequest},
+		{ErrInvalidPackName.affix("name:/x"), codes.InvalidArgument, ErrInvalidPackName},
+		{ErrUnauthorized, codes.Unauthenticated, ErrUnauthorized},
+		{ErrForbidden, codes.PermissionDenied, ErrForbidden},
+		{ErrPackNotFound, codes.NotFound, ErrPackNotFound},
+		{ErrKeyNotFound, codes.NotFound, ErrKeyNotFound},
+		{ErrKeyUsed, codes.FailedPrecondition, ErrKeyUsed},
+		{ErrPackDisabled, codes.FailedPrecondition, ErrPackDisabled},
+		{ErrTooManyRequests, codes.ResourceExhausted, ErrTooManyRequests},
+		{ErrPackClosing, codes.Unavailable, ErrPackClosing},
+		{ErrServerStopping, codes.Unavailable, ErrServerStopping},
+		{errors.New("boom"), codes.Internal, ErrInternal},
+	} {
+		err := grpcError("test", c.err)
+		checkGRPCError(t, fmt.Sprint(c.err), err, c.code, c.want)
+
+		for _, d := range status.Convert(err).Details() {
+			if e, ok := d.(*cdkeypb.Error); ok && e.Cmd != "test" {
+				t.Errorf("%v: cmd %q, want test", c.err, e.Cmd)
+			}
+		}
+	}
+}
+
+func TestGRPCTokenAuth(t *testing.T) {
+	s, keys := grpcTestServer(t, Options{Auth: true})
+	shop, _, err := s.CreateToken("shop", []RoleBinding{{Role: RoleRedeemer, Packs: "summer"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := s.CreateToken("other", []RoleBinding{{Role: RoleRedeemer, Packs: "winter"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := dialGRPC(t, s, nil, nil)
+
+	withToken := func(secret string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+secret)
+	}
+	req := &cdkeypb.KeyRequest{Pack: "summer", Key: keys[0].Key}
+
+	_, err = c.CheckKey(context.Background(), req)
+	checkGRPCError(t, "no token", err, codes.Unauthenticated, ErrUnauthorized)
+
+	_, err = c.CheckKey(withToken("wrong"), req)
+	checkGRPCError(t, "wrong token", err, codes.Unauthenticated, ErrUnauthorized)
+
+	_, err = c.CheckKey(withToken(other), req)
+	checkGRPCError(t, "token of another pack", err, codes.PermissionDenied, ErrForbidden)
+
+	_, err = c.RevokeKey(withToken(shop), req)
+	checkGRPCError(t, "revoke as redeemer", err, codes.PermissionDenied, ErrForbidden)
+
+	if _, err := c.UseKey(withToken(shop), req); err != nil {
+		t.Errorf("use with token: %v", err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-cdkey-token", shop)
+	if _, err := c.CheckKey(ctx, req); err != nil {
+		t.Errorf("check with x-cdkey-token: %v", err)
+	}
+}
+
+func TestGRPCClientCert(t *testing.T) {
+	ca := newTestCA(t)
+	other := newTestCA(t)
+
+	s, keys := grpcTestServer(t, Options{
+		Auth: true,
+		ClientCerts: []ClientCert{
+			{Name: "shop", Bindings: []RoleBinding{{Role: RoleRedeemer, Packs: "summer"}}},
+		},
+	})
+
+	serverCreds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "localhost", true)},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	dial := func(certs ...tls.Certificate) cdkeypb.CDKeyClient {
+		return dialGRPC(t, s, []grpc.ServerOption{grpc.Creds(serverCreds)}, credentials.NewTLS(&tls.Config{
+			RootCAs:      ca.pool,
+			ServerName:   "localhost",
+			Certificates: certs,
+		}))
+	}
+	req := &cdkeypb.KeyRequest{Pack: "summer", Key: keys[0].Key}
+	ctx := context.Background()
+
+	if _, err := dial(ca.issue(t, "shop", false)).UseKey(ctx, req); err != nil {
+		t.Errorf("use with client cert: %v", err)
+	}
+
+	_, err := dial().CheckKey(ctx, req)
+	checkGRPCError(t, "no client cert", err, codes.Unauthenticated, ErrUnauthorized)
+
+	_, err = dial(ca.issue(t, "stranger", false)).CheckKey(ctx, req)
+	checkGRPCError(t, "unknown client cert", err, codes.Unauthenticated, ErrUnauthorized)
+
+	_, err = dial(ca.issue(t, "shop", false)).RevokeKey(ctx, req)
+	checkGRPCError(t, "revoke with redeemer cert", err, codes.PermissionDenied, ErrForbidden)
+
+	// A certificate of another CA fails the handshake.
+	if _, err := dial(other.issue(t, "shop", false)).CheckKey(ctx, req); status.Code(err) != codes.Unavailable {
+		t.Errorf("cert of another CA: %v, want unavailable", err)
+	}
+}
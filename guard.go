@@ -0,0 +1,284 @@
+package cdkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GuardConfig configures the brute-force protection of key redemption. Every
+// client address and every API token is limited separately, per pack:
+//
+// Redemptions are rate limited by a token bucket refilled with RatePerMinute
+// and holding up to Burst attempts. After MaxFailures consecutive attempts
+// with a key not found, the source is locked out for LockoutSeconds; every
+// further lockout doubles the duration, up to MaxLockoutSeconds. A successful
+// redemption resets the failure count.
+type GuardConfig struct {
+	Disabled          bool `json:"disabled"`
+	RatePerMinute     int  `json:"ratePerMinute"`
+	Burst             int  `json:"burst"`
+	MaxFailures       int  `json:"maxFailures"`
+	LockoutSeconds    int  `json:"lockoutSeconds"`
+	MaxLockoutSeconds int  `json:"maxLockoutSeconds"`
+}
+
+// DefaultGuardConfig is used for packs without their own GuardConfig, unless
+// Options.Guard is set.
+var DefaultGuardConfig = GuardConfig{
+	RatePerMinute:     60,
+	Burst:             10,
+	MaxFailures:       10,
+	LockoutSeconds:    60,
+	MaxLockoutSeconds: 3600,
+}
+
+func (c GuardConfig) lockout(n int) time.Duration {
+	d := float64(c.LockoutSeconds) * math.Pow(2, float64(n-1))
+	if c.MaxLockoutSeconds > 0 && d > float64(c.MaxLockoutSeconds) {
+		d = float64(c.MaxLockoutSeconds)
+	}
+	return time.Duration(d) * time.Second
+}
+
+type guardState struct {
+	tokens      float64
+	last        time.Time
+	failures    int
+	lockouts    int
+	lockedUntil time.Time
+}
+
+// guardPruneInterval is how often idle states are dropped.
+const guardPruneInterval = time.Minute
+
+// guard keeps the rate limit and lockout state of all redemption sources.
+// Idle states are dropped by a background goroutine, stopped by close.
+type guard struct {
+	clock  Clock
+	log    libLogger
+	states map[string]*guardState
+	mtx    sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newGuard(clock Clock, log libLogger) *guard {
+	g := &guard{
+		clock:  clock,
+		log:    log,
+		states: make(map[string]*guardState),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go g.run()
+	return g
+}
+
+func (g *guard) run() {
+	defer close(g.done)
+
+	ticker := time.NewTicker(guardPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+		}
+
+		g.prune(g.clock.Now())
+	}
+}
+
+func (g *guard) close() {
+	close(g.stop)
+	<-g.done
+}
+
+// guardSources returns the sources of a redemption: the client address and
+// the API token found in ctx.
+func guardSources(ctx context.Context) []string {
+	var sources []string
+	if addr, ok := ClientAddrFromContext(ctx); ok {
+		sources = append(sources, "ip:"+addr)
+	}
+	if t, ok := PrincipalFromContext(ctx); ok {
+		sources = append(sources, "token:"+t.ID)
+	}
+	return sources
+}
+
+// allow takes one attempt from the bucket of every source, and fails if any
+// source is locked out or out of attempts. All sources are checked before any
+// is charged, so a rejected attempt costs none of them.
+func (g *guard) allow(pack string, cfg GuardConfig, sources []string, now time.Time) error {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	states := make([]*guardState, 0, len(sources))
+	for _, src := range sources {
+		st, ok := g.states[pack+"|"+src]
+		if !ok {
+			st = &guardState{tokens: float64(cfg.Burst), last: now}
+			g.states[pack+"|"+src] = st
+		}
+
+		if now.Before(st.lockedUntil) {
+			g.log.warn_log("redemption locked out", "pack", pack, "source", src, "until", st.lockedUntil)
+			return ErrTooManyRequests.affix(fmt.Sprintf("source:%v, retryAfter:%v", src, st.lockedUntil.Sub(now)/time.Second*time.Second))
+		}
+
+		st.tokens += now.Sub(st.last).Minutes() * float64(cfg.RatePerMinute)
+		if st.tokens > float64(cfg.Burst) {
+			st.tokens = float64(cfg.Burst)
+		}
+		st.last = now
+
+		if st.tokens < 1 {
+			g.log.warn_log("redemption rate limited", "pack", pack, "source", src)
+			return ErrTooManyRequests.affix(fmt.Sprintf("source:%v, rate:%v/min", src, cfg.RatePerMinute))
+		}
+		states = append(states, st)
+	}
+
+	for _, st := range states {
+		st.tokens--
+	}
+	return nil
+}
+
+// record updates the failure counts of all sources after a redemption.
+func (g *guard) record(pack string, cfg GuardConfig, sources []string, err error, now time.Time) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	for _, src := range sources {
+		st, ok := g.states[pack+"|"+src]
+		if !ok {
+			continue
+		}
+
+		switch {
+		case err == nil:
+			st.failures = 0
+		case errors.Is(err, ErrKeyNotFound):
+			st.failures++
+			if cfg.MaxFailures > 0 && st.failures >= cfg.MaxFailures {
+				st.lockouts++
+				st.failures = 0
+				st.lockedUntil = now.Add(cfg.lockout(st.lockouts))
+				g.log.warn_log("suspicious redemption source locked out", "pack", pack, "source", src,
+					"lockouts", st.lockouts, "until", st.lockedUntil)
+			}
+		}
+	}
+}
+
+// prune drops the states idle for an hour without a running lockout.
+func (g *guard) prune(now time.Time) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	for k, st := range g.states {
+		if now.Sub(st.last) > time.Hour && now.After(st.lockedUntil) {
+			delete(g.states, k)
+		}
+	}
+}
+
+type clientAddrKey struct{}
+
+// WithClientAddr returns a copy of ctx carrying the address of the client, used
+// to limit key redemptions per client.
+func WithClientAddr(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, clientAddrKey{}, addr)
+}
+
+// ClientAddrFromContext returns the client address set by WithClientAddr.
+func ClientAddrFromContext(ctx context.Context) (string, bool) {
+	addr, ok := ctx.Value(clientAddrKey{}).(string)
+	return addr, ok && addr != ""
+}
+
+// clientAddr returns the IP of r's client, without the port. If r comes from
+// one of Options.TrustedProxies, the client is the last address in
+// X-Forwarded-For which is not a trusted proxy; the header is ignored
+// otherwise, since any client can set it.
+func (s *Server) clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !s.trustedProxy(host) {
+		return host
+	}
+
+	addrs := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(addrs[i])
+		if addr == "" {
+			continue
+		}
+		if !s.trustedProxy(addr) {
+			return addr
+		}
+		host = addr
+	}
+	return host
+}
+
+// trustedProxy reports whether addr is in Options.TrustedProxies.
+func (s *Server) trustedProxy(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+
+	for _, p := range s.opts.TrustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// guardConfig returns the GuardConfig of a pack.
+func (s *Server) guardConfig(p *Pack) GuardConfig {
+	if cfg := p.Info().Guard; cfg != nil {
+		return *cfg
+	}
+	if s.opts.Guard != nil {
+		return *s.opts.Guard
+	}
+	return DefaultGuardConfig
+}
+
+// SetPackGuard sets the GuardConfig of a pack. A nil cfg restores the default.
+func (s *Server) SetPackGuard(name string, cfg *GuardConfig) error {
+	return s.SetPackGuardContext(context.Background(), name, cfg)
+}
+
+func (s *Server) SetPackGuardContext(ctx context.Context, name string, cfg *GuardConfig) (err error) {
+	defer func() { s.audit(ctx, "pack.guard", name, "", err) }()
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	p, err := s.lookupPack(name)
+	if err != nil {
+		return err
+	}
+	return p.SetGuard(cfg)
+}
@@ -0,0 +1,126 @@
+package cdkey
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestClientAddr(t *testing.T) {
+	s := &Server{opts: Options{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}}
+
+	for _, c := range []struct {
+		remote, xff, want string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "198.51.100.7", "192.0.2.1"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "198.51.100.7", "198.51.100.7"},
+		{"10.0.0.1:1234", "203.0.113.9, 198.51.100.7, 10.0.0.2", "198.51.100.7"},
+		{"10.0.0.1:1234", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if got := s.clientAddr(r); got != c.want {
+			t.Errorf("clientAddr(%v, %q) = %v, want %v", c.remote, c.xff, got, c.want)
+		}
+	}
+}
+
+func TestGuardPrune(t *testing.T) {
+	g := newGuard(systemClock{}, libLogger{})
+	defer g.close()
+
+	now := time.Now()
+	cfg := GuardConfig{RatePerMinute: 60, Burst: 10, MaxFailures: 1, LockoutSeconds: 7200}
+	if err := g.allow("p", cfg, []string{"ip:a", "ip:b"}, now); err != nil {
+		t.Fatal(err)
+	}
+	g.record("p", cfg, []string{"ip:b"}, ErrKeyNotFound, now)
+
+	g.prune(now.Add(2 * time.Hour))
+	if _, ok := g.states["p|ip:a"]; ok {
+		t.Error("idle state not pruned")
+	}
+	if _, ok := g.states["p|ip:b"]; !ok {
+		t.Error("locked out state pruned")
+	}
+}
+
+func TestGuardAllowAllOrNothing(t *testing.T) {
+	g := newGuard(systemClock{}, libLogger{})
+	defer g.close()
+
+	now := time.Now()
+	cfg := GuardConfig{RatePerMinute: 1, Burst: 2}
+	for i := 0; i < 2; i++ {
+		if err := g.allow("p", cfg, []string{"ip:a"}, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// ip:a is out of attempts, so the attempt must not cost token:shop one.
+	for i := 0; i < 3; i++ {
+		if err := g.allow("p", cfg, []string{"token:shop", "ip:a"}, now); !errors.Is(err, ErrTooManyRequests) {
+			t.Fatalf("allow with an exhausted source: %v, want ErrTooManyRequests", err)
+		}
+	}
+	if tokens := g.states["p|token:shop"].tokens; tokens != 2 {
+		t.Errorf("token:shop has %v attempts left after rejected attempts, want 2", tokens)
+	}
+}
+
+func TestFindKeyGuard(t *testing.T) {
+	s, err := NewServerWithOptions(t.TempDir(), Options{
+		Auth:  true,
+		Guard: &GuardConfig{RatePerMinute: 60, Burst: 10, MaxFailures: 2, LockoutSeconds: 60},
+		Tokens: []StaticToken{
+			{Name: "ops", Secret: "ops-secret", Bindings: []RoleBinding{{Role: RoleViewer, Packs: "summer"}}},
+			{Name: "shop", Secret: "shop-secret", Bindings: []RoleBinding{{Role: RoleRedeemer, Packs: "*"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if err := s.AddPack("summer", "S", 12, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := s.ListKeys("summer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := s.HTTPServeMux()
+	find := func(token, key string) int {
+		r := httptest.NewRequest("GET", "/v1/keys/"+key, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := find("shop-secret", keys[0].Key); code != http.StatusForbidden {
+		t.Errorf("find as redeemer: %v, want 403", code)
+	}
+	if code := find("ops-secret", keys[0].Key); code != http.StatusOK {
+		t.Errorf("find as viewer: %v, want 200", code)
+	}
+
+	// Searches without matches count as failures of the guard.
+	for i := 0; i < 2; i++ {
+		if code := find("ops-secret", "S00000000000"); code != http.StatusOK {
+			t.Errorf("find unknown key: %v, want 200", code)
+		}
+	}
+	if code := find("ops-secret", keys[0].Key); code != http.StatusTooManyRequests {
+		t.Errorf("find after 2 misses: %v, want 429", code)
+	}
+}
@@ -0,0 +1,227 @@
+package cdkey
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// probeTimeout bounds the read probe of a pack store.
+const probeTimeout = time.Second
+
+// readyTTL is how long /readyz answers with the same HealthReport, so that
+// frequent or hostile requests do not probe every pack each time.
+const readyTTL = 2 * time.Second
+
+// probeKey is read by the probe. It is never a valid key, so the read is
+// expected to fail with ErrStoreNotFound.
+const probeKey = "-probe-"
+
+// PackHealth is the health of a single pack.
+type PackHealth struct {
+	Name  string `json:"name"`
+	Open  bool   `json:"open"`
+	Error string `json:"error,omitempty"`
+
+	// ProbeMillis is the time of the read probe, if the pack is open.
+	ProbeMillis float64 `json:"probeMillis,omitempty"`
+}
+
+// DiskHealth is the space of the file system holding the data directory.
+type DiskHealth struct {
+	Path  string `json:"path"`
+	Free  uint64 `json:"free"`
+	Total uint64 `json:"total"`
+	Error string `json:"error,omitempty"`
+}
+
+// HealthReport is returned by /readyz. The server is ready if all packs are
+// open, loaded without error and answer the read probe, and the data directory
+// has at least Options.MinDiskFree bytes free, and it is not draining.
+//
+// Packs and Disk are only reported to callers with PermMetricsView, since pack
+// names, errors and paths are not for anyone able to reach the server.
+type HealthReport struct {
+	Ready     bool         `json:"ready"`
+	Draining  bool         `json:"draining,omitempty"`
+	PackCount int          `json:"packCount"`
+	Failing   int          `json:"failing"`
+	Packs     []PackHealth `json:"packs,omitempty"`
+	Disk      *DiskHealth  `json:"disk,omitempty"`
+	Time      time.Time    `json:"time"`
+}
+
+// summary returns the report without Packs and Disk.
+func (r HealthReport) summary() HealthReport {
+	r.Packs, r.Disk = nil, nil
+	return r
+}
+
+// LivenessReport is returned by /healthz.
+type LivenessReport struct {
+	Status string `json:"status"`
+	Packs  int    `json:"packs"`
+	Broken int    `json:"broken"`
+}
+
+// probe reads a key from the store of the pack, to check that it is open and
+// responds within timeout.
+func (p *Pack) probe(timeout time.Duration) error {
+	p.closeMtx.RLock()
+	if p.db == nil {
+		p.closeMtx.RUnlock()
+		return ErrPackClosing
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer p.closeMtx.RUnlock()
+
+		_, err := p.db.Get([]byte(probeKey))
+		if err == nil || err == ErrStoreNotFound {
+			done <- nil
+		} else {
+			done <- ErrFailedLoadKeys.affix(err)
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return ErrFailedLoadKeys.affix("probe timeout")
+	}
+}
+
+// Health checks all packs and the data directory.
+func (s *Server) Health() HealthReport {
+	report := HealthReport{
+		Ready: true,
+		Time:  s.opts.Clock.Now(),
+	}
+	if s.Draining() {
+		report.Ready = false
+		report.Draining = true
+	}
+
+	s.mtx.RLock()
+	packs := make([]*Pack, 0, len(s.packs))
+	for _, p := range s.packs {
+		packs = append(packs, p)
+	}
+	for name, err := range s.broken {
+		report.Packs = append(report.Packs, PackHealth{Name: name, Error: err.Error()})
+		report.Ready = false
+		report.Failing++
+	}
+	s.mtx.RUnlock()
+
+	for _, p := range packs {
+		h := PackHealth{Name: p.Name, Open: true}
+
+		start := time.Now()
+		if err := p.probe(probeTimeout); err != nil {
+			h.Open = err != ErrPackClosing
+			h.Error = err.Error()
+			report.Ready = false
+			report.Failing++
+		}
+		h.ProbeMillis = float64(time.Since(start).Microseconds()) / 1000
+
+		report.Packs = append(report.Packs, h)
+	}
+
+	sort.Slice(report.Packs, func(i, j int) bool {
+		return report.Packs[i].Name < report.Packs[j].Name
+	})
+
+	report.PackCount = len(report.Packs)
+
+	report.Disk = &DiskHealth{Path: s.path}
+	free, total, err := diskSpace(s.path)
+	if err != nil {
+		report.Disk.Error = err.Error()
+	} else {
+		report.Disk.Free, report.Disk.Total = free, total
+		if free < s.opts.MinDiskFree {
+			report.Ready = false
+		}
+	}
+
+	return report
+}
+
+// readyCache holds the HealthReport last computed for /readyz.
+type readyCache struct {
+	report HealthReport
+	mtx    sync.Mutex
+}
+
+// readyReport returns the cached HealthReport, checking again once it is
+// older than readyTTL. Concurrent callers wait for a single check. Draining is
+// applied to the cached report, so it is reported at once.
+func (s *Server) readyReport() HealthReport {
+	s.ready.mtx.Lock()
+	defer s.ready.mtx.Unlock()
+
+	now := s.opts.Clock.Now()
+	if t := s.ready.report.Time; t.IsZero() || now.Sub(t) >= readyTTL || now.Before(t) {
+		s.ready.report = s.Health()
+	}
+
+	report := s.ready.report
+	if s.Draining() {
+		report.Ready = false
+		report.Draining = true
+	}
+	return report
+}
+
+// handleHealthz reports that the server is alive. It is cheap and does not
+// touch the packs, use /readyz for that.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.mtx.RLock()
+	packs, broken := len(s.packs), len(s.broken)
+	s.mtx.RUnlock()
+
+	rsp, _ := json.Marshal(LivenessReport{
+		Status: "ok",
+		Packs:  packs,
+		Broken: broken,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
+
+// handleReadyz writes the HealthReport, cached for readyTTL, with status 503
+// if the server is not ready. The operation is public, but a token is still
+// checked, if sent, to give the details to callers with PermMetricsView.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := s.readyReport()
+	if !report.Ready {
+		s.log.info_log("server not ready", "path", s.path, "failing", report.Failing)
+	}
+
+	ctx := r.Context()
+	if t, ok := s.authenticate(r); ok {
+		ctx = context.WithValue(ctx, principalKey{}, t)
+	}
+	if s.Authorize(ctx, PermMetricsView, "") != nil {
+		report = report.summary()
+	}
+
+	rsp, _ := json.Marshal(report)
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(rsp)
+}
@@ -0,0 +1,95 @@
+package cdkey
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyzDetail(t *testing.T) {
+	s, err := NewServerWithOptions(t.TempDir(), Options{
+		Auth: true,
+		Tokens: []StaticToken{
+			{Name: "ops", Secret: "ops-secret", Bindings: []RoleBinding{{Role: RoleViewer, Packs: "*"}}},
+			{Name: "shop", Secret: "shop-secret", Bindings: []RoleBinding{{Role: RoleRedeemer, Packs: "*"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if err := s.AddPack("summer", "S", 12, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := s.HTTPServeMux()
+	for _, c := range []struct {
+		token  string
+		detail bool
+	}{
+		{"", false},
+		{"shop-secret", false},
+		{"wrong", false},
+		{"ops-secret", true},
+	} {
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		var report HealthReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("token %q: %v: %s", c.token, err, w.Body.Bytes())
+		}
+		if !report.Ready || report.PackCount != 1 {
+			t.Errorf("token %q: %+v", c.token, report)
+		}
+		if got := report.Disk != nil && len(report.Packs) == 1; got != c.detail {
+			t.Errorf("token %q: detail %v, want %v: %s", c.token, got, c.detail, w.Body.Bytes())
+		}
+	}
+}
+
+func TestReadyzCached(t *testing.T) {
+	s, err := NewServerWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	mux := s.HTTPServeMux()
+	readyz := func() HealthReport {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		var report HealthReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%v: %s", err, w.Body.Bytes())
+		}
+		return report
+	}
+
+	if r := readyz(); r.PackCount != 0 {
+		t.Fatalf("%+v, want no packs", r)
+	}
+	if err := s.AddPack("summer", "S", 12, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	if r := readyz(); r.PackCount != 0 {
+		t.Errorf("%+v, want the cached report without packs", r)
+	}
+
+	s.ready.mtx.Lock()
+	s.ready.report.Time = s.ready.report.Time.Add(-readyTTL)
+	s.ready.mtx.Unlock()
+	if r := readyz(); r.PackCount != 1 {
+		t.Errorf("%+v, want a new report after readyTTL", r)
+	}
+
+	s.Drain()
+	if r := readyz(); r.Ready || !r.Draining {
+		t.Errorf("%+v, want draining at once", r)
+	}
+}
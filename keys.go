@@ -1,6 +1,7 @@
 package cdkey
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
@@ -56,20 +57,31 @@ func keyGen1(prefix string, rndLen int) string {
 //	32^(keylen-len(prefix)) < size * 100,
 // which means a randomly generated key has a chance more than 1% to be valid.
 func KeyGenN(prefix string, keylen, size int) ([]string, error) {
+	return KeyGenNContext(context.Background(), prefix, keylen, size)
+}
+
+// KeyGenNContext is like KeyGenN, but stops generating and returns ErrCanceled
+// once ctx is done.
+func KeyGenNContext(ctx context.Context, prefix string, keylen, size int) ([]string, error) {
 	normalPrefix, ok := NormalizeKey(prefix)
 	if !ok {
-		return nil, errInvalidPrefix.affix(fmt.Sprintf("prefix:%v", prefix))
+		return nil, ErrInvalidPrefix.affix(fmt.Sprintf("prefix:%v", prefix))
 	}
 
 	rndLen := keylen - len(normalPrefix)
 	if math.Pow(float64(charSetLen), float64(rndLen)) < float64(size*100) {
-		return nil, errKeylenTooShort.affix(fmt.Sprintf("keylen:%v, rndLen:%v, size:%v", keylen, rndLen, size))
+		return nil, ErrKeylenTooShort.affix(fmt.Sprintf("keylen:%v, rndLen:%v, size:%v", keylen, rndLen, size))
 	}
 
 	rand.Seed(time.Now().Unix())
 
 	m := make(map[string]struct{})
-	for len(m) < size {
+	for i := 0; len(m) < size; i++ {
+		if i%checkInterval == 0 {
+			if err := checkContext(ctx); err != nil {
+				return nil, err
+			}
+		}
 		m[keyGen1(normalPrefix, rndLen)] = struct{}{}
 	}
 
@@ -1,41 +1,206 @@
 package cdkey
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// StructuredLogger is a leveled logger. The cdkey lib logs short constant
+// messages with details as alternating keys and values, like log/slog:
+//
+//	Info(ctx, "key use", "pack", "summer", "key", "ABCD")
+//
+// The fields added to ctx by WithLogFields are already part of kv, ctx is
+// passed on for loggers that read other values from it.
+type StructuredLogger interface {
+	Debug(ctx context.Context, msg string, kv ...interface{})
+	Info(ctx context.Context, msg string, kv ...interface{})
+	Warn(ctx context.Context, msg string, kv ...interface{})
+	Error(ctx context.Context, msg string, kv ...interface{})
+}
 
 // Logger is a simple interface contains 2 log levels. The cdkey lib user is
 // responsable for implementing Logger methods.
+//
+// Deprecated: use StructuredLogger. A Logger still works through SetLogger.
 type Logger interface {
 	Info(v ...interface{})
 	Error(v ...interface{})
 }
 
-var logger Logger
+var logger StructuredLogger
 
-// SetLogger sets a Logger as the cdkey lib's logger.
-func SetLogger(l Logger) {
+// SetStructuredLogger sets a StructuredLogger as the cdkey lib's default
+// logger, used by Packs outside a Server and by Servers without
+// Options.StructuredLogger. Set it once, before creating them, since it is
+// not synchronized.
+func SetStructuredLogger(l StructuredLogger) {
 	logger = l
 }
 
-func info_log(v ...interface{}) {
-	if logger != nil {
-		logger.Info(v...)
+// SetLogger sets a Logger as the cdkey lib's logger. Entries are formatted as
+// "msg (key:value, ...)"; Debug entries are dropped and Warn entries are
+// logged as errors.
+func SetLogger(l Logger) {
+	if l == nil {
+		logger = nil
+		return
 	}
+	logger = legacyLogger{l}
 }
 
-func error_log(v ...interface{}) {
-	if logger != nil {
-		logger.Error(v...)
+// legacyLogger adapts a Logger to StructuredLogger.
+type legacyLogger struct {
+	l Logger
+}
+
+func (a legacyLogger) format(msg string, kv []interface{}) string {
+	if len(kv) == 0 {
+		return msg
+	}
+
+	var pairs []string
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 < len(kv) {
+			pairs = append(pairs, fmt.Sprintf("%v:%v", kv[i], kv[i+1]))
+		} else {
+			pairs = append(pairs, fmt.Sprint(kv[i]))
+		}
 	}
+	return msg + " (" + strings.Join(pairs, ", ") + ")"
+}
+
+func (a legacyLogger) Debug(ctx context.Context, msg string, kv ...interface{}) {}
+
+func (a legacyLogger) Info(ctx context.Context, msg string, kv ...interface{}) {
+	a.l.Info(a.format(msg, kv))
+}
+
+func (a legacyLogger) Warn(ctx context.Context, msg string, kv ...interface{}) {
+	a.l.Error(a.format(msg, kv))
+}
+
+func (a legacyLogger) Error(ctx context.Context, msg string, kv ...interface{}) {
+	a.l.Error(a.format(msg, kv))
 }
 
-func info_logf(format string, v ...interface{}) {
-	if logger != nil {
-		logger.Info(fmt.Sprintf(format, v...))
+// NewSlogLogger returns a StructuredLogger logging to l, or to slog.Default()
+// if l is nil.
+func NewSlogLogger(l *slog.Logger) StructuredLogger {
+	if l == nil {
+		l = slog.Default()
 	}
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (a slogLogger) Debug(ctx context.Context, msg string, kv ...interface{}) {
+	a.l.DebugContext(ctx, msg, kv...)
+}
+
+func (a slogLogger) Info(ctx context.Context, msg string, kv ...interface{}) {
+	a.l.InfoContext(ctx, msg, kv...)
+}
+
+func (a slogLogger) Warn(ctx context.Context, msg string, kv ...interface{}) {
+	a.l.WarnContext(ctx, msg, kv...)
+}
+
+func (a slogLogger) Error(ctx context.Context, msg string, kv ...interface{}) {
+	a.l.ErrorContext(ctx, msg, kv...)
+}
+
+type logFieldsKey struct{}
+
+// WithLogFields returns a copy of ctx carrying key-value fields, which are
+// added to every entry the cdkey lib logs with ctx, e.g. a request id.
+func WithLogFields(ctx context.Context, kv ...interface{}) context.Context {
+	fields := append(append([]interface{}(nil), LogFieldsFromContext(ctx)...), kv...)
+	return context.WithValue(ctx, logFieldsKey{}, fields)
+}
+
+// LogFieldsFromContext returns the fields added by WithLogFields.
+func LogFieldsFromContext(ctx context.Context) []interface{} {
+	fields, _ := ctx.Value(logFieldsKey{}).([]interface{})
+	return fields
 }
 
-func error_logf(format string, v ...interface{}) {
-	if logger != nil {
-		logger.Error(fmt.Sprintf(format, v...))
+// logFields returns the fields of ctx followed by kv. Fields of ctx whose key
+// is also in kv are left out.
+func logFields(ctx context.Context, kv []interface{}) []interface{} {
+	fields := LogFieldsFromContext(ctx)
+	if len(fields) == 0 {
+		return kv
 	}
+
+	keys := make(map[interface{}]bool, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		keys[kv[i]] = true
+	}
+
+	var merged []interface{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		if !keys[fields[i]] {
+			merged = append(merged, fields[i], fields[i+1])
+		}
+	}
+	return append(merged, kv...)
+}
+
+// libLogger is the logger of a Server and its packs: l, or the logger set
+// with SetStructuredLogger if l is nil.
+type libLogger struct {
+	l StructuredLogger
+}
+
+func (g libLogger) get() StructuredLogger {
+	if g.l != nil {
+		return g.l
+	}
+	return logger
+}
+
+func (g libLogger) debug_logc(ctx context.Context, msg string, kv ...interface{}) {
+	if l := g.get(); l != nil {
+		l.Debug(ctx, msg, logFields(ctx, kv)...)
+	}
+}
+
+func (g libLogger) info_logc(ctx context.Context, msg string, kv ...interface{}) {
+	if l := g.get(); l != nil {
+		l.Info(ctx, msg, logFields(ctx, kv)...)
+	}
+}
+
+func (g libLogger) warn_logc(ctx context.Context, msg string, kv ...interface{}) {
+	if l := g.get(); l != nil {
+		l.Warn(ctx, msg, logFields(ctx, kv)...)
+	}
+}
+
+func (g libLogger) error_logc(ctx context.Context, msg string, kv ...interface{}) {
+	if l := g.get(); l != nil {
+		l.Error(ctx, msg, logFields(ctx, kv)...)
+	}
+}
+
+func (g libLogger) debug_log(msg string, kv ...interface{}) {
+	g.debug_logc(context.Background(), msg, kv...)
+}
+
+func (g libLogger) info_log(msg string, kv ...interface{}) {
+	g.info_logc(context.Background(), msg, kv...)
+}
+
+func (g libLogger) warn_log(msg string, kv ...interface{}) {
+	g.warn_logc(context.Background(), msg, kv...)
+}
+
+func (g libLogger) error_log(msg string, kv ...interface{}) {
+	g.error_logc(context.Background(), msg, kv...)
 }
@@ -0,0 +1,80 @@
+package cdkey
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// recordLogger records the messages logged at info level and above.
+type recordLogger struct {
+	mtx  sync.Mutex
+	msgs []string
+}
+
+func (l *recordLogger) add(msg string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.msgs = append(l.msgs, msg)
+}
+
+func (l *recordLogger) has(msg string) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	for _, m := range l.msgs {
+		if m == msg {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *recordLogger) Debug(ctx context.Context, msg string, kv ...interface{}) {}
+
+func (l *recordLogger) Info(ctx context.Context, msg string, kv ...interface{}) { l.add(msg) }
+
+func (l *recordLogger) Warn(ctx context.Context, msg string, kv ...interface{}) { l.add(msg) }
+
+func (l *recordLogger) Error(ctx context.Context, msg string, kv ...interface{}) { l.add(msg) }
+
+func TestServerLogger(t *testing.T) {
+	global := &recordLogger{}
+	SetStructuredLogger(global)
+	defer SetStructuredLogger(nil)
+
+	l1, l2 := &recordLogger{}, &recordLogger{}
+	s1, err := NewServerWithOptions(t.TempDir(), Options{StructuredLogger: l1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s1.Stop()
+	s2, err := NewServerWithOptions(t.TempDir(), Options{StructuredLogger: l2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Stop()
+
+	if err := s1.AddPack("summer", "S", 12, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	s1.Stop()
+
+	if !l1.has("new CDKeyServer") || !l1.has("pack closed") {
+		t.Errorf("server logger got %v", l1.msgs)
+	}
+	if l2.has("pack closed") {
+		t.Errorf("pack of another server logged to %v", l2.msgs)
+	}
+	if len(global.msgs) != 0 {
+		t.Errorf("servers with a logger logged to the default logger: %v", global.msgs)
+	}
+
+	s3, err := NewServerWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s3.Stop()
+	if !global.has("new CDKeyServer") {
+		t.Errorf("server without a logger did not log to the default logger: %v", global.msgs)
+	}
+}
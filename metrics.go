@@ -0,0 +1,310 @@
+package cdkey
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are exposed in the Prometheus text format by the /metrics command:
+//
+//	cdkey_key_use_total{pack,code}                  key.use results, code 0 is success
+//	cdkey_http_request_duration_seconds{cmd}        handler latency
+//	cdkey_store_operation_duration_seconds{op}      store operation time
+//	cdkey_pack_keys{pack,status}                    keys per pack by status
+//
+// They are implemented here to keep the lib free of metrics dependencies.
+
+// defaultBuckets are the histogram buckets in seconds, as used by the
+// Prometheus client libraries.
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// storeBuckets are finer, since store operations are mostly well below 1ms.
+var storeBuckets = []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1}
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// labelEscaper escapes label values as the Prometheus text format requires,
+// which differs from Go quoting for non-ASCII and control characters.
+var labelEscaper = strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`)
+
+// formatLabels formats names and values as {name="value",...}.
+func formatLabels(names, values []string, extra ...string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, name, labelEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, extra[i], labelEscaper.Replace(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	values map[string]float64
+	keys   map[string][]string
+	mtx    sync.Mutex
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		keys:   make(map[string][]string),
+	}
+}
+
+func (c *counterVec) inc(values ...string) {
+	k := labelKey(values)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.keys[k]; !ok {
+		c.keys[k] = values
+	}
+	c.values[k]++
+}
+
+func (c *counterVec) write(w *bufio.Writer) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n", c.name, c.help, c.name)
+	for _, k := range sortedKeys(c.keys) {
+		fmt.Fprintf(w, "%v%v %v\n", c.name, formatLabels(c.labels, c.keys[k]), formatFloat(c.values[k]))
+	}
+}
+
+type histogram struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	hs  map[string]*histogram
+	mtx sync.Mutex
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		hs:      make(map[string]*histogram),
+	}
+}
+
+func (v *histogramVec) observe(d time.Duration, values ...string) {
+	k := labelKey(values)
+	secs := d.Seconds()
+
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	h, ok := v.hs[k]
+	if !ok {
+		h = &histogram{values: values, counts: make([]uint64, len(v.buckets))}
+		v.hs[k] = h
+	}
+
+	for i, le := range v.buckets {
+		if secs <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += secs
+}
+
+func (v *histogramVec) write(w *bufio.Writer) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v histogram\n", v.name, v.help, v.name)
+	keys := make([]string, 0, len(v.hs))
+	for k := range v.hs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		h := v.hs[k]
+		for i, le := range v.buckets {
+			fmt.Fprintf(w, "%v_bucket%v %v\n", v.name, formatLabels(v.labels, h.values, "le", formatFloat(le)), h.counts[i])
+		}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", v.name, formatLabels(v.labels, h.values, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", v.name, formatLabels(v.labels, h.values), formatFloat(h.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", v.name, formatLabels(v.labels, h.values), h.count)
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metrics holds the metrics of a Server.
+type metrics struct {
+	keyUse        *counterVec
+	httpDuration  *histogramVec
+	storeDuration *histogramVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		keyUse: newCounterVec("cdkey_key_use_total",
+			"Key redemptions by pack and result code, 0 is success.", "pack", "code"),
+		httpDuration: newHistogramVec("cdkey_http_request_duration_seconds",
+			"Latency of HTTP commands.", defaultBuckets, "cmd"),
+		storeDuration: newHistogramVec("cdkey_store_operation_duration_seconds",
+			"Time of store operations.", storeBuckets, "op"),
+	}
+}
+
+// observeKeyUse counts a key.use result. Unknown packs are counted with an
+// empty pack label, to keep the label values bounded.
+func (m *metrics) observeKeyUse(pack string, err error) {
+	code := 0
+	if err != nil {
+		var e *StatusError
+		if errors.As(err, &e) {
+			code = e.Code()
+		} else {
+			code = ErrInternal.Code()
+		}
+	}
+	m.keyUse.inc(pack, strconv.Itoa(code))
+}
+
+// instrument wraps h to observe its latency as the command cmd.
+func (m *metrics) instrument(cmd string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		h.ServeHTTP(w, r)
+		m.httpDuration.observe(time.Since(start), cmd)
+	})
+}
+
+// timedStorage wraps a Storage to observe the time of store operations.
+type timedStorage struct {
+	Storage
+	m *metrics
+}
+
+func (s timedStorage) Create(path string) (Store, error) {
+	start := time.Now()
+	db, err := s.Storage.Create(path)
+	s.m.storeDuration.observe(time.Since(start), "create")
+	if err != nil {
+		return nil, err
+	}
+	return timedStore{db, s.m}, nil
+}
+
+func (s timedStorage) Open(path string) (Store, error) {
+	start := time.Now()
+	db, err := s.Storage.Open(path)
+	s.m.storeDuration.observe(time.Since(start), "open")
+	if err != nil {
+		return nil, err
+	}
+	return timedStore{db, s.m}, nil
+}
+
+type timedStore struct {
+	Store
+	m *metrics
+}
+
+func (s timedStore) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+	defer func() { s.m.storeDuration.observe(time.Since(start), "get") }()
+	return s.Store.Get(key)
+}
+
+func (s timedStore) Put(key, value []byte) error {
+	start := time.Now()
+	defer func() { s.m.storeDuration.observe(time.Since(start), "put") }()
+	return s.Store.Put(key, value)
+}
+
+func (s timedStore) Write(b *Batch) error {
+	start := time.Now()
+	defer func() { s.m.storeDuration.observe(time.Since(start), "write") }()
+	return s.Store.Write(b)
+}
+
+// writeMetrics writes all metrics of the server in the Prometheus text format.
+func (s *Server) writeMetrics(w *bufio.Writer) {
+	s.metrics.keyUse.write(w)
+	s.metrics.httpDuration.write(w)
+	s.metrics.storeDuration.write(w)
+
+	s.mtx.RLock()
+	packs := make([]*Pack, 0, len(s.packs))
+	for _, p := range s.packs {
+		packs = append(packs, p)
+	}
+	s.mtx.RUnlock()
+
+	sort.Slice(packs, func(i, j int) bool {
+		return packs[i].Name < packs[j].Name
+	})
+
+	labels := []string{"pack", "status"}
+	fmt.Fprintf(w, "# HELP cdkey_pack_keys Keys per pack by status.\n# TYPE cdkey_pack_keys gauge\n")
+	for _, p := range packs {
+		stats := p.Stats()
+		fmt.Fprintf(w, "cdkey_pack_keys%v %v\n", formatLabels(labels, []string{p.Name, "ready"}), stats.Ready)
+		fmt.Fprintf(w, "cdkey_pack_keys%v %v\n", formatLabels(labels, []string{p.Name, "used"}), stats.Used)
+		fmt.Fprintf(w, "cdkey_pack_keys%v %v\n", formatLabels(labels, []string{p.Name, "reserved"}), stats.Reserved)
+		fmt.Fprintf(w, "cdkey_pack_keys%v %v\n", formatLabels(labels, []string{p.Name, "revoked"}), stats.Revoked)
+	}
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if err := s.Authorize(r.Context(), PermMetricsView, ""); err != nil {
+		putStatusError(w, "metrics", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	bw := bufio.NewWriter(w)
+	s.writeMetrics(bw)
+	bw.Flush()
+}
@@ -0,0 +1,11 @@
+package cdkey
+
+import "testing"
+
+func TestFormatLabels(t *testing.T) {
+	got := formatLabels([]string{"pack"}, []string{"夏\"a\\b\nc"}, "code", "0")
+	want := `{pack="夏\"a\\b\nc",code="0"}`
+	if got != want {
+		t.Errorf("formatLabels = %v, want %v", got, want)
+	}
+}
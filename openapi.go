@@ -0,0 +1,274 @@
+package cdkey
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// apiParam is a path or query parameter of an apiOperation.
+type apiParam struct {
+	Name string
+	In   string // "path" or "query"
+	Type string // "string", "integer" or "boolean"
+	Desc string
+}
+
+// apiOperation is an operation of the HTTP API. HTTPServeMux registers the
+// handlers of all operations and the OpenAPI document is generated from the
+// same list, so every served operation is documented with the types its
+// handler decodes and encodes.
+type apiOperation struct {
+	// Method is the documented method. AnyMethod operations, like the dot
+	// commands, are served for every method.
+	Method    string
+	Path      string
+	AnyMethod bool
+
+	// ID is the operationId. It is also the metrics label of dot commands.
+	ID      string
+	Summary string
+	Perm    Permission
+
+	// Request and Response are zero values of the body types, nil if there is
+	// no body. ContentType is set for responses other than JSON.
+	Request     interface{}
+	Response    interface{}
+	ContentType string
+	Status      int
+	Params      []apiParam
+
+	Handler http.HandlerFunc
+
+	// Public operations do not require a token, even with Options.Auth.
+	Public bool
+
+	// Unobserved operations are not part of the latency metrics.
+	Unobserved bool
+}
+
+func (op apiOperation) pattern() string {
+	if op.AnyMethod {
+		return op.Path
+	}
+	return op.Method + " " + op.Path
+}
+
+// handler wraps the handler of op with authentication and metrics.
+func (s *Server) handler(op apiOperation) http.Handler {
+	var h http.Handler = op.Handler
+	if !op.Public {
+		h = s.AuthHandler(h)
+	}
+	if !op.Unobserved {
+		label := op.ID
+		if !op.AnyMethod {
+			label = op.pattern()
+		}
+		h = s.metrics.instrument(label, h)
+	}
+	return h
+}
+
+type object = map[string]interface{}
+
+// schemaBuilder generates JSON schemas from Go types, the way encoding/json
+// encodes them. Named struct types become components.
+type schemaBuilder struct {
+	schemas object
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (b *schemaBuilder) schema(t reflect.Type) object {
+	if t == timeType {
+		return object{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := b.schema(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			return object{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return object{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return object{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		if _, ok := b.schemas[t.Name()]; !ok {
+			b.schemas[t.Name()] = object{} // breaks recursion
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return object{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return object{}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) object {
+	props := object{}
+	b.addFields(t, props)
+	return object{"type": "object", "properties": props}
+}
+
+func (b *schemaBuilder) addFields(t reflect.Type, props object) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			b.addFields(f.Type, props)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = b.schema(f.Type)
+	}
+}
+
+func (b *schemaBuilder) body(v interface{}) object {
+	return object{"application/json": object{"schema": b.schema(reflect.TypeOf(v))}}
+}
+
+// OpenAPI returns the OpenAPI 3 document of the HTTP API served by
+// HTTPServeMux.
+func (s *Server) OpenAPI() []byte {
+	b := &schemaBuilder{schemas: object{}}
+
+	var codes []interface{}
+	var catalog []interface{}
+	var lines []string
+	for _, c := range errorCatalog {
+		codes = append(codes, c.Err.Code())
+		catalog = append(catalog, object{
+			"code":       c.Err.Code(),
+			"name":       c.Name,
+			"httpStatus": c.Err.HTTPCode(),
+			"msg":        c.Err.Msg(),
+		})
+		lines = append(lines, fmt.Sprintf("- %v %v (HTTP %v)", c.Err.Code(), c.Name, c.Err.HTTPCode()))
+	}
+
+	b.schemas["StatusError"] = object{
+		"type": "object",
+		"properties": object{
+			"code": object{"type": "integer", "enum": codes},
+			"msg":  object{"type": "string"},
+			"cmd":  object{"type": "string"},
+		},
+		"x-error-codes": catalog,
+	}
+
+	paths := object{}
+	for _, op := range s.apiOperations() {
+		method := strings.ToLower(op.Method)
+
+		o := object{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+		}
+		if op.Perm != "" {
+			o["x-permission"] = string(op.Perm)
+		}
+		if op.Public {
+			o["security"] = []interface{}{}
+		}
+
+		var params []interface{}
+		for _, p := range op.Params {
+			params = append(params, object{
+				"name":        p.Name,
+				"in":          p.In,
+				"required":    p.In == "path",
+				"description": p.Desc,
+				"schema":      object{"type": p.Type},
+			})
+		}
+		if len(params) > 0 {
+			o["parameters"] = params
+		}
+
+		if op.Request != nil {
+			o["requestBody"] = object{"content": b.body(op.Request)}
+		}
+
+		rsp := object{"description": http.StatusText(op.Status)}
+		switch {
+		case op.ContentType != "":
+			rsp["content"] = object{op.ContentType: object{"schema": object{"type": "string"}}}
+		case op.Response != nil:
+			rsp["content"] = b.body(op.Response)
+		}
+		o["responses"] = object{
+			fmt.Sprint(op.Status): rsp,
+			"default":             object{"$ref": "#/components/responses/Error"},
+		}
+
+		item, _ := paths[op.Path].(object)
+		if item == nil {
+			item = object{}
+			paths[op.Path] = item
+		}
+		item[method] = o
+	}
+
+	doc := object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   "cdkey",
+			"version": "1",
+			"description": "CD-Key server API. The dot commands like /pack.add take a JSON body; " +
+				"the /v1 API is the RESTful view of the same commands.",
+		},
+		"paths": paths,
+		"components": object{
+			"schemas": b.schemas,
+			"responses": object{
+				"Error": object{
+					"description": "StatusError, one of:\n" + strings.Join(lines, "\n"),
+					"content": object{"application/json": object{
+						"schema": object{"$ref": "#/components/schemas/StatusError"},
+					}},
+				},
+			},
+			"securitySchemes": object{
+				"bearer": object{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []interface{}{object{"bearer": []interface{}{}}},
+	}
+
+	out, _ := json.MarshalIndent(doc, "", "  ")
+	return out
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(s.OpenAPI())
+}
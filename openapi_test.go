@@ -0,0 +1,483 @@
+package cdkey
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// openAPIDoc parses the OpenAPI document of s.
+func openAPIDoc(t *testing.T, s *Server) object {
+	t.Helper()
+
+	var doc object
+	if err := json.Unmarshal(s.OpenAPI(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// docOperation returns the documented operation of op, nil if missing.
+func docOperation(doc object, op apiOperation) object {
+	item, _ := doc["paths"].(object)[op.Path].(object)
+	o, _ := item[strings.ToLower(op.Method)].(object)
+	return o
+}
+
+// bodySchema returns the JSON schema of a documented request or response
+// body, nil if there is none.
+func bodySchema(body interface{}) object {
+	content, _ := body.(object)["content"].(object)
+	media, _ := content["application/json"].(object)
+	schema, _ := media["schema"].(object)
+	return schema
+}
+
+// resolve follows $ref and nullable allOf wrappers of schema.
+func resolve(doc object, schema object) object {
+	for {
+		if all, ok := schema["allOf"].([]interface{}); ok && len(all) == 1 {
+			schema = all[0].(object)
+			continue
+		}
+		ref, ok := schema["$ref"].(string)
+		if !ok {
+			return schema
+		}
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		schema = doc["components"].(object)["schemas"].(object)[name].(object)
+	}
+}
+
+// jsonFields returns the names encoding/json uses for the fields of the
+// struct type t.
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			names = append(names, jsonFields(f.Type)...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func schemaFields(schema object) []string {
+	var names []string
+	for name := range schema["properties"].(object) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkType fails unless the body schema of an operation documents the
+// fields of the Go value v.
+func checkType(t *testing.T, doc object, id, what string, schema object, v interface{}) {
+	t.Helper()
+
+	if v == nil {
+		if schema != nil {
+			t.Errorf("%v: %v documented, but the operation has none", id, what)
+		}
+		return
+	}
+	if schema == nil {
+		t.Errorf("%v: %v %T not documented", id, what, v)
+		return
+	}
+
+	want := jsonFields(reflect.TypeOf(v))
+	got := schemaFields(resolve(doc, schema))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%v: %v schema has fields %v, %T has %v", id, what, got, v, want)
+	}
+}
+
+// checkValue fails unless the decoded JSON value v matches schema. Null is
+// accepted everywhere, since nil slices and maps encode as null.
+func checkValue(t *testing.T, doc object, id, where string, schema object, v interface{}) {
+	t.Helper()
+
+	schema = resolve(doc, schema)
+	if v == nil {
+		return
+	}
+
+	switch schema["type"] {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			t.Errorf("%v: %v is %T, documented as object", id, where, v)
+			return
+		}
+		props, _ := schema["properties"].(object)
+		extra, _ := schema["additionalProperties"].(object)
+		for k, fv := range m {
+			switch {
+			case props[k] != nil:
+				checkValue(t, doc, id, where+"."+k, props[k].(object), fv)
+			case extra != nil:
+				checkValue(t, doc, id, where+"."+k, extra, fv)
+			default:
+				t.Errorf("%v: %v.%v is not documented", id, where, k)
+			}
+		}
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			t.Errorf("%v: %v is %T, documented as array", id, where, v)
+			return
+		}
+		for i, ev := range a {
+			checkValue(t, doc, id, fmt.Sprintf("%v[%v]", where, i), schema["items"].(object), ev)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			t.Errorf("%v: %v is %T, documented as string", id, where, v)
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != float64(int64(f)) {
+			t.Errorf("%v: %v is %v, documented as integer", id, where, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			t.Errorf("%v: %v is %T, documented as number", id, where, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			t.Errorf("%v: %v is %T, documented as boolean", id, where, v)
+		}
+	}
+}
+
+// TestOpenAPIOperations checks that every operation is routed by
+// HTTPServeMux and documented with the request and response types of its
+// handler.
+func TestOpenAPIOperations(t *testing.T) {
+	s, err := NewServerWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	doc := openAPIDoc(t, s)
+	mux := s.HTTPServeMux()
+
+	ids := make(map[string]bool)
+	for _, op := range s.apiOperations() {
+		if ids[op.ID] {
+			t.Errorf("%v: duplicate operation id", op.ID)
+		}
+		ids[op.ID] = true
+
+		path := strings.NewReplacer("{name}", "x", "{key}", "x", "{id}", "x").Replace(op.Path)
+		if _, pattern := mux.Handler(httptest.NewRequest(op.Method, path, nil)); pattern != op.pattern() {
+			t.Errorf("%v: %v %v routed to %q, want %q", op.ID, op.Method, path, pattern, op.pattern())
+		}
+
+		o := docOperation(doc, op)
+		if o == nil {
+			t.Errorf("%v: %v %v missing in the document", op.ID, op.Method, op.Path)
+			continue
+		}
+		if o["operationId"] != op.ID {
+			t.Errorf("%v: documented as %v", op.ID, o["operationId"])
+		}
+
+		var params []string
+		ps, _ := o["parameters"].([]interface{})
+		for _, p := range ps {
+			if p := p.(object); p["in"] == "path" {
+				params = append(params, p["name"].(string))
+			}
+		}
+		for _, seg := range strings.Split(op.Path, "/") {
+			if strings.HasPrefix(seg, "{") && !contains(params, strings.Trim(seg, "{}")) {
+				t.Errorf("%v: path parameter %v not documented", op.ID, seg)
+			}
+		}
+
+		var req object
+		if body, ok := o["requestBody"]; ok {
+			req = bodySchema(body)
+		}
+		checkType(t, doc, op.ID, "request", req, op.Request)
+
+		rsp, ok := o["responses"].(object)[fmt.Sprint(op.Status)]
+		if !ok {
+			t.Errorf("%v: status %v not documented", op.ID, op.Status)
+			continue
+		}
+		if op.ContentType == "" {
+			checkType(t, doc, op.ID, "response", bodySchema(rsp), op.Response)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// apiCall is a call of an operation in TestOpenAPIResponses. path and body
+// are functions, since they may depend on the results of earlier calls.
+type apiCall struct {
+	id   string
+	path func() map[string]string
+	body func() string
+}
+
+func pathOf(kv ...string) func() map[string]string {
+	return func() map[string]string {
+		m := make(map[string]string)
+		for i := 0; i+1 < len(kv); i += 2 {
+			m[kv[i]] = kv[i+1]
+		}
+		return m
+	}
+}
+
+func bodyOf(s string) func() string {
+	return func() string { return s }
+}
+
+// TestOpenAPIResponses calls every operation and checks that the handler
+// answers with the documented status and a body matching the documented
+// schema, and that the test sends only documented request fields.
+func TestOpenAPIResponses(t *testing.T) {
+	s, err := NewServerWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	doc := openAPIDoc(t, s)
+	mux := s.HTTPServeMux()
+
+	ops := make(map[string]apiOperation)
+	for _, op := range s.apiOperations() {
+		ops[op.ID] = op
+	}
+
+	// Keys of the packs, filled once they are added.
+	var summer, winter []KeyInfo
+	key := func(keys *[]KeyInfo, i int) string { return (*keys)[i].Key }
+
+	results := make(map[string]map[string]interface{})
+	result := func(id, field string) string {
+		v := results[id]
+		for _, f := range strings.Split(field, ".") {
+			if m, ok := v[f].(map[string]interface{}); ok {
+				v = m
+				continue
+			}
+			return fmt.Sprint(v[f])
+		}
+		return ""
+	}
+
+	calls := []apiCall{
+		{id: "project.add", body: bodyOf(`{"name":"game1"}`)},
+		{id: "v1.project.add", body: bodyOf(`{"name":"game2","maxPacks":2}`)},
+		{id: "pack.add", body: bodyOf(`{"name":"game1/summer","prefix":"S","keylen":12,"packsize":20}`)},
+		{id: "v1.pack.add", body: bodyOf(`{"name":"winter","prefix":"W","keylen":12,"packsize":20}`)},
+		{id: "pack.list", body: bodyOf(`{}`)},
+		{id: "v1.pack.list"},
+		{id: "v1.pack.get", path: pathOf("name", "game1/summer")},
+		{id: "pack.enable", body: bodyOf(`{"pack":"game1/summer"}`)},
+		{id: "v1.pack.enable", path: pathOf("name", "winter")},
+		{id: "key.list", body: bodyOf(`{"pack":"game1/summer","limit":5}`)},
+		{id: "v1.key.list", path: pathOf("name", "winter")},
+		{id: "key.find", body: func() string { return fmt.Sprintf(`{"key":%q}`, key(&summer, 0)) }},
+		{id: "v1.key.find", path: func() map[string]string { return pathOf("key", key(&winter, 0))() }},
+		{id: "key.check", body: func() string { return fmt.Sprintf(`{"pack":"game1/summer","key":%q}`, key(&summer, 0)) }},
+		{id: "v1.key.check", path: func() map[string]string { return pathOf("name", "winter", "key", key(&winter, 0))() }},
+		{id: "key.use", body: func() string { return fmt.Sprintf(`{"pack":"game1/summer","key":%q}`, key(&summer, 0)) }},
+		{id: "v1.key.redeem", path: func() map[string]string { return pathOf("name", "winter", "key", key(&winter, 0))() }},
+		{id: "key.revoke", body: func() string { return fmt.Sprintf(`{"pack":"game1/summer","key":%q}`, key(&summer, 1)) }},
+		{id: "v1.key.revoke", path: func() map[string]string { return pathOf("name", "winter", "key", key(&winter, 1))() }},
+		{id: "pack.verify", body: bodyOf(`{"pack":"game1/summer","repair":false}`)},
+		{id: "v1.pack.verify", path: pathOf("name", "winter")},
+		{id: "pack.extend", body: bodyOf(`{"pack":"game1/summer","count":5}`)},
+		{id: "v1.pack.extend", path: pathOf("name", "winter"), body: bodyOf(`{"count":5}`)},
+		{id: "pack.guard", body: bodyOf(`{"pack":"game1/summer","guard":{"ratePerMinute":10,"burst":5}}`)},
+		{id: "v1.pack.guard.set", path: pathOf("name", "winter"), body: bodyOf(`{"ratePerMinute":10,"burst":5}`)},
+		{id: "v1.pack.guard.reset", path: pathOf("name", "winter")},
+		{id: "pack.disable", body: bodyOf(`{"pack":"game1/summer","msg":"paused"}`)},
+		{id: "v1.pack.disable", path: pathOf("name", "winter"), body: bodyOf(`{"msg":"paused"}`)},
+		{id: "pack.reload", body: bodyOf(`{"pack":"game1/summer"}`)},
+		{id: "v1.pack.reload", path: pathOf("name", "winter")},
+
+		{id: "project.list"},
+		{id: "v1.project.list"},
+		{id: "v1.project.get", path: pathOf("name", "game1")},
+		{id: "v1.project.packs", path: pathOf("name", "game1")},
+		{id: "project.quota", body: bodyOf(`{"project":"game1","maxPacks":5,"maxKeys":1000}`)},
+		{id: "v1.project.quota", path: pathOf("name", "game2"), body: bodyOf(`{"maxPacks":5,"maxKeys":1000}`)},
+
+		{id: "token.create", body: bodyOf(`{"name":"shop","bindings":[{"role":"redeemer","packs":"*"}]}`)},
+		{id: "v1.token.create", body: bodyOf(`{"name":"ops","bindings":[{"role":"viewer","packs":"*"}]}`)},
+		{id: "token.list"},
+		{id: "v1.token.list"},
+		{id: "token.revoke", body: func() string { return fmt.Sprintf(`{"id":%q}`, result("token.create", "info.id")) }},
+		{id: "v1.token.revoke", path: func() map[string]string { return pathOf("id", result("v1.token.create", "info.id"))() }},
+
+		{id: "webhook.add", body: bodyOf(`{"url":"http://127.0.0.1:1/hook","packs":"game1/*"}`)},
+		{id: "v1.webhook.add", body: bodyOf(`{"url":"http://127.0.0.1:1/hook","events":["key.used"]}`)},
+		{id: "webhook.list"},
+		{id: "v1.webhook.list"},
+		{id: "webhook.deliveries", body: func() string { return fmt.Sprintf(`{"id":%q,"limit":10}`, result("webhook.add", "webhook.id")) }},
+		{id: "v1.webhook.deliveries", path: func() map[string]string { return pathOf("id", result("v1.webhook.add", "id"))() }},
+		{id: "webhook.remove", body: func() string { return fmt.Sprintf(`{"id":%q}`, result("webhook.add", "webhook.id")) }},
+		{id: "v1.webhook.remove", path: func() map[string]string { return pathOf("id", result("v1.webhook.add", "id"))() }},
+
+		{id: "audit.query", body: bodyOf(`{"pack":"game1/summer","limit":10}`)},
+		{id: "v1.audit.query"},
+
+		{id: "healthz"},
+		{id: "readyz"},
+		{id: "metrics"},
+		{id: "openapi"},
+
+		{id: "pack.remove", body: bodyOf(`{"pack":"game1/summer"}`)},
+		{id: "v1.pack.remove", path: pathOf("name", "winter")},
+		{id: "project.remove", body: bodyOf(`{"project":"game1"}`)},
+		{id: "v1.project.remove", path: pathOf("name", "game2")},
+	}
+
+	// The event streams do not end, they are covered by TestOpenAPIOperations
+	// only.
+	called := map[string]bool{"events": true, "v1.events": true}
+
+	for _, c := range calls {
+		op, ok := ops[c.id]
+		if !ok {
+			t.Errorf("%v: no such operation", c.id)
+			continue
+		}
+		called[c.id] = true
+		o := docOperation(doc, op)
+
+		path := op.Path
+		if c.path != nil {
+			for k, v := range c.path() {
+				path = strings.Replace(path, "{"+k+"}", url.PathEscape(v), 1)
+			}
+		}
+
+		var body []byte
+		if c.body != nil {
+			body = []byte(c.body())
+
+			var req interface{}
+			if err := json.Unmarshal(body, &req); err != nil {
+				t.Fatalf("%v: bad test body: %v", c.id, err)
+			}
+			if rb, ok := o["requestBody"]; ok {
+				checkValue(t, doc, c.id, "request", bodySchema(rb), req)
+			} else {
+				t.Errorf("%v: test sends a body to an operation without one", c.id)
+			}
+		}
+
+		r := httptest.NewRequest(op.Method, path, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		if w.Code != op.Status {
+			t.Errorf("%v: %v %v: status %v, documented %v: %s", c.id, op.Method, path, w.Code, op.Status, w.Body.Bytes())
+			continue
+		}
+
+		switch {
+		case op.ContentType != "":
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, op.ContentType) {
+				t.Errorf("%v: content type %v, documented %v", c.id, ct, op.ContentType)
+			}
+		case op.Response == nil:
+			if w.Body.Len() > 0 {
+				t.Errorf("%v: undocumented body %s", c.id, w.Body.Bytes())
+			}
+		default:
+			var v interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+				t.Errorf("%v: %v: %s", c.id, err, w.Body.Bytes())
+				continue
+			}
+			rsp := o["responses"].(object)[fmt.Sprint(op.Status)]
+			checkValue(t, doc, c.id, "response", bodySchema(rsp), v)
+			if m, ok := v.(map[string]interface{}); ok {
+				results[c.id] = m
+			}
+		}
+
+		switch c.id {
+		case "pack.add":
+			summer, _ = s.ListKeys("game1/summer")
+		case "v1.pack.add":
+			winter, _ = s.ListKeys("winter")
+		}
+	}
+
+	for id := range ops {
+		if !called[id] {
+			t.Errorf("%v: operation not called", id)
+		}
+	}
+}
+
+// TestOpenAPIErrors checks that error responses are StatusError bodies.
+func TestOpenAPIErrors(t *testing.T) {
+	s, err := NewServerWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	doc := openAPIDoc(t, s)
+	mux := s.HTTPServeMux()
+	schema := object{"$ref": "#/components/schemas/StatusError"}
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest("POST", "/pack.enable", strings.NewReader(`{"pack":"nope"}`)),
+		httptest.NewRequest("GET", "/v1/packs/nope", nil),
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		var v interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+			t.Fatalf("%v: %v: %s", r.URL.Path, err, w.Body.Bytes())
+		}
+		checkValue(t, doc, r.URL.Path, "error", schema, v)
+		if code, _ := v.(map[string]interface{})["code"].(float64); int(code) != ErrPackNotFound.Code() {
+			t.Errorf("%v: code %v, want %v", r.URL.Path, code, ErrPackNotFound.Code())
+		}
+	}
+}
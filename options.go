@@ -0,0 +1,100 @@
+package cdkey
+
+import (
+	"net/netip"
+	"os"
+	"time"
+)
+
+// Clock provides the current time. It can be replaced in Options, e.g. to
+// make pack creation times deterministic.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Options configures a Server created by NewServerWithOptions. The zero value
+// is valid and gives the defaults.
+type Options struct {
+	// StructuredLogger is the logger of the Server and its packs. Default is
+	// the logger set with SetStructuredLogger.
+	StructuredLogger StructuredLogger
+
+	// Logger is used like StructuredLogger, if StructuredLogger is nil.
+	//
+	// Deprecated: use StructuredLogger.
+	Logger Logger
+
+	// Storage is the backend for pack databases. Default is LevelDBStorage.
+	Storage Storage
+
+	// Clock is the time source. Default is the system clock.
+	Clock Clock
+
+	// DirPerm is the permission of created directories. Default is 0755.
+	DirPerm os.FileMode
+
+	// Auth requires an API token for every HTTP command, see AuthHandler.
+	// Tokens are kept in tokens.json of the server directory. Without Auth
+	// anyone reaching the API can remove packs and read keys, so it must not
+	// be exposed to untrusted clients.
+	Auth bool
+
+	// Tokens are API tokens accepted in addition to those in tokens.json.
+	Tokens []StaticToken
+
+	// ClientCerts are the TLS client certificates accepted instead of an API
+	// token.
+	ClientCerts []ClientCert
+
+	// Guard is the brute-force protection of packs without their own
+	// GuardConfig. Default is DefaultGuardConfig.
+	Guard *GuardConfig
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header is
+	// taken as the client address, which the Guard limits. Requests from
+	// other addresses are limited by their own address.
+	TrustedProxies []netip.Prefix
+
+	// Audit configures the rotation of audit.log, see AuditConfig.
+	Audit AuditConfig
+
+	// MinDiskFree is the free space in bytes the data directory needs for
+	// /readyz to report the server ready. Default 0 only reports the space.
+	MinDiskFree uint64
+}
+
+func defaultOptions() Options {
+	return Options{}.withDefaults()
+}
+
+func (o Options) withDefaults() Options {
+	if o.Storage == nil {
+		o.Storage = LevelDBStorage{}
+	}
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+	if o.DirPerm == 0 {
+		o.DirPerm = 0755
+	}
+	o.Audit = o.Audit.withDefaults()
+	return o
+}
+
+// libLogger returns the logger configured by StructuredLogger or Logger.
+func (o Options) libLogger() libLogger {
+	switch {
+	case o.StructuredLogger != nil:
+		return libLogger{o.StructuredLogger}
+	case o.Logger != nil:
+		return libLogger{legacyLogger{o.Logger}}
+	default:
+		return libLogger{}
+	}
+}
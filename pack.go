@@ -1,17 +1,17 @@
 package cdkey
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
 type packStatus string
@@ -20,40 +20,59 @@ func (s packStatus) Ready() bool {
 	return string(s) == "ready"
 }
 
-type keyStatus bool
+// keyStatus is the status of a key, stored as a single byte value.
+type keyStatus byte
+
+const (
+	keyReady    keyStatus = 'R'
+	keyUsed     keyStatus = 'U'
+	keyReserved keyStatus = 'S'
+	keyRevoked  keyStatus = 'X'
+)
 
 func (s keyStatus) dbVal() []byte {
-	if s {
-		return []byte("R")
-	} else {
-		return []byte("U")
-	}
+	return []byte{byte(s)}
 }
 
 func (s keyStatus) String() string {
-	if s {
+	switch s {
+	case keyReady:
 		return "Ready"
-	} else {
+	case keyReserved:
+		return "Reserved"
+	case keyRevoked:
+		return "Revoked"
+	default:
 		return "Used"
 	}
 }
 
+// loadKeyStatus parses a stored key status. Unknown values are treated as
+// used.
 func loadKeyStatus(b []byte) keyStatus {
-	if string(b) == "R" {
-		return keyStatus(true)
-	} else {
-		return keyStatus(false)
+	if len(b) == 1 {
+		switch s := keyStatus(b[0]); s {
+		case keyReady, keyReserved, keyRevoked:
+			return s
+		}
 	}
+	return keyUsed
 }
 
 type PackInfo struct {
-	Name       string     `json:"name"`
-	Prefix     string     `json:"prefix"`
-	KeyLen     int        `json:"keylen"`
-	PackSize   int        `json:"packsize"`
-	Status     packStatus `json:"status"`
-	Note       string     `json:"note"`
-	CreateTime time.Time  `json:"createTime"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix"`
+	KeyLen     int          `json:"keylen"`
+	PackSize   int          `json:"packsize"`
+	Status     packStatus   `json:"status"`
+	Note       string       `json:"note"`
+	CreateTime time.Time    `json:"createTime"`
+	Guard      *GuardConfig `json:"guard,omitempty"`
+	Project    string       `json:"project,omitempty"`
+	Error      string       `json:"error,omitempty"`
+
+	// Stats is set by Info, it is not stored.
+	Stats *PackStats `json:"stats,omitempty"`
 }
 
 type Pack struct {
@@ -62,60 +81,180 @@ type Pack struct {
 
 	path     string
 	Name     string
-	db       *leveldb.DB
+	db       Store
 	closeMtx sync.RWMutex
+
+	// useMtx makes checking and marking a key used atomic.
+	useMtx sync.Mutex
+
+	// ready, used, reserved and revoked count the keys by status, accessed
+	// atomically.
+	ready, used, reserved, revoked int64
+
+	// extendMtx serializes Extend, so concurrent extensions can not generate
+	// the same key.
+	extendMtx sync.Mutex
+
+	// events receives the events of the pack, if the pack belongs to a Server.
+	events *hub
+
+	log libLogger
+}
+
+// PackStats counts the keys of a pack by status.
+type PackStats struct {
+	Ready    int `json:"ready"`
+	Used     int `json:"used"`
+	Reserved int `json:"reserved"`
+	Revoked  int `json:"revoked"`
+}
+
+// Stats returns the number of keys by status. It is maintained in memory and
+// does not access the database.
+func (p *Pack) Stats() PackStats {
+	return PackStats{
+		Ready:    int(atomic.LoadInt64(&p.ready)),
+		Used:     int(atomic.LoadInt64(&p.used)),
+		Reserved: int(atomic.LoadInt64(&p.reserved)),
+		Revoked:  int(atomic.LoadInt64(&p.revoked)),
+	}
+}
+
+func (p *Pack) setStats(stats PackStats) {
+	atomic.StoreInt64(&p.ready, int64(stats.Ready))
+	atomic.StoreInt64(&p.used, int64(stats.Used))
+	atomic.StoreInt64(&p.reserved, int64(stats.Reserved))
+	atomic.StoreInt64(&p.revoked, int64(stats.Revoked))
+}
+
+// count adds a key with status s.
+func (st *PackStats) count(s keyStatus) {
+	switch s {
+	case keyReady:
+		st.Ready++
+	case keyReserved:
+		st.Reserved++
+	case keyRevoked:
+		st.Revoked++
+	default:
+		st.Used++
+	}
+}
+
+// countStatus moves a key from status from to status to in the Stats.
+func (p *Pack) countStatus(from, to keyStatus) {
+	counter := func(s keyStatus) *int64 {
+		switch s {
+		case keyReady:
+			return &p.ready
+		case keyReserved:
+			return &p.reserved
+		case keyRevoked:
+			return &p.revoked
+		default:
+			return &p.used
+		}
+	}
+	atomic.AddInt64(counter(from), -1)
+	atomic.AddInt64(counter(to), 1)
+}
+
+// countKeys counts the keys by status, for the initial Stats of a pack.
+func (p *Pack) countKeys() error {
+	var stats PackStats
+
+	iter := p.db.NewIterator()
+	defer iter.Release()
+
+	for iter.Next() {
+		stats.count(loadKeyStatus(iter.Value()))
+	}
+
+	if iter.Error() != nil {
+		p.log.error_log("failed count keys", "path", p.path, "err", iter.Error())
+		return ErrFailedLoadKeys.affix(iter.Error())
+	}
+
+	p.setStats(stats)
+	return nil
 }
 
+// LoadPack loads the pack stored at path, using the default LevelDB storage.
 func LoadPack(path string) (*Pack, error) {
-	info_logf("start load pack (path:%v)", path)
+	return loadPack(defaultOptions(), path)
+}
+
+func loadPack(opts Options, path string) (*Pack, error) {
+	log := opts.libLogger()
+	log.debug_log("start load pack", "path", path)
 
 	b, err := ioutil.ReadFile(filepath.Join(path, "pack.json"))
 	if err != nil {
-		error_log(err)
-		return nil, errFailedLoadPackInfo.affix(err)
+		log.error_log("failed read pack.json", "path", path, "err", err)
+		return nil, ErrFailedLoadPackInfo.affix(err)
 	}
 
 	var info PackInfo
 	if err := json.Unmarshal(b, &info); err != nil {
-		error_log(err)
-		return nil, errFailedLoadPackInfo.affix(err)
+		log.error_log("failed parse pack.json", "path", path, "err", err)
+		return nil, ErrFailedLoadPackInfo.affix(err)
 	}
 
-	db, err := leveldb.OpenFile(path, nil)
+	db, err := opts.Storage.Open(path)
 	if err != nil {
-		error_log(err)
-		return nil, errFailedLoadDB.affix(err)
+		log.error_log("failed open pack db", "path", path, "err", err)
+		return nil, ErrFailedLoadDB.affix(err)
 	}
 
-	info_logf("pack %v loaded", info.Name)
-
-	return &Pack{
+	p := &Pack{
 		info: info,
 		path: path,
 		Name: info.Name,
 		db:   db,
-	}, nil
+		log:  log,
+	}
+
+	if err := p.countKeys(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	log.info_log("pack loaded", "name", info.Name)
+
+	return p, nil
 }
 
+// CreatePack generates a new pack in a sub directory of path, using the default
+// LevelDB storage.
 func CreatePack(path string, name, prefix string, keylen, packsize int, note string) (*Pack, error) {
-	if strings.ContainsAny(name, `<>:"/\|?*_`) {
-		error_logf("invalid pack name: %v", name)
-		return nil, errInvalidPackName.affix(fmt.Sprintf("name:%v", name))
+	return createPack(context.Background(), defaultOptions(), path, name, prefix, keylen, packsize, note)
+}
+
+func createPack(ctx context.Context, opts Options, path string, name, prefix string, keylen, packsize int, note string) (*Pack, error) {
+	log := opts.libLogger()
+
+	if !validName(name) {
+		log.warn_logc(ctx, "invalid pack name", "name", name)
+		return nil, ErrInvalidPackName.affix(fmt.Sprintf("name:%v", name))
 	}
 
 	prefix, ok := NormalizeKey(prefix)
 	if !ok {
-		error_logf("invalid pack prefix: %v", prefix)
-		return nil, errInvalidPrefix.affix(fmt.Sprintf("prefix:%v", prefix))
+		log.warn_logc(ctx, "invalid pack prefix", "prefix", prefix)
+		return nil, ErrInvalidPrefix.affix(fmt.Sprintf("prefix:%v", prefix))
 	}
 
-	info_logf("start generate keys (prefix:%v, keylen:%v, packsize:%v)", prefix, keylen, packsize)
-	keys, err := KeyGenN(prefix, keylen, packsize)
+	log.debug_logc(ctx, "start generate keys", "prefix", prefix, "keylen", keylen, "packsize", packsize)
+	keys, err := KeyGenNContext(ctx, prefix, keylen, packsize)
 	if err != nil {
-		error_log(err)
+		log.error_logc(ctx, "failed generate keys", "prefix", prefix, "err", err)
+		return nil, err
+	}
+
+	if err := checkContext(ctx); err != nil {
 		return nil, err
 	}
-	info_logf("keys generated (prefix:%v, keylen:%v, packsize:%v)", name, keylen, packsize)
+	log.debug_logc(ctx, "keys generated", "prefix", prefix, "keylen", keylen, "packsize", packsize)
 
 	info := PackInfo{
 		Name:       name,
@@ -124,38 +263,44 @@ func CreatePack(path string, name, prefix string, keylen, packsize int, note str
 		PackSize:   packsize,
 		Status:     packStatus("initial"),
 		Note:       note,
-		CreateTime: time.Now(),
+		CreateTime: opts.Clock.Now(),
 	}
 
 	fullPath := filepath.Join(path, name)
 
-	info_logf("start create pack db (name:%v, path:%v)", name, fullPath)
-	db, err := leveldb.OpenFile(fullPath, &opt.Options{ErrorIfExist: true})
+	log.debug_logc(ctx, "start create pack db", "name", name, "path", fullPath)
+	if err := os.MkdirAll(fullPath, opts.DirPerm); err != nil {
+		log.error_logc(ctx, "failed mkdir", "path", fullPath, "err", err)
+		return nil, ErrFailedCreateDB.affix(err)
+	}
+
+	db, err := opts.Storage.Create(fullPath)
 	if err != nil {
-		error_log(err)
-		return nil, errFailedCreateDB.affix(err)
+		log.error_logc(ctx, "failed create pack db", "path", fullPath, "err", err)
+		return nil, ErrFailedCreateDB.affix(err)
 	}
-	info_logf("pack db created (name:%v, path:%v)", name, fullPath)
+	log.debug_logc(ctx, "pack db created", "name", name, "path", fullPath)
 
-	info_logf("start write keys to db (prefix:%v, keylen:%v, packsize:%v)", prefix, keylen, packsize)
-	batch := &leveldb.Batch{}
+	log.debug_logc(ctx, "start write keys to db", "prefix", prefix, "keylen", keylen, "packsize", packsize)
+	batch := &Batch{}
 	for _, k := range keys {
-		batch.Put([]byte(k), keyStatus(true).dbVal())
+		batch.Put([]byte(k), keyReady.dbVal())
 	}
 
-	if err := db.Write(batch, nil); err != nil {
-		error_log("failed save keys: ", err)
+	if err := db.Write(batch); err != nil {
+		log.error_logc(ctx, "failed save keys", "name", name, "err", err)
 		db.Close()
 		os.RemoveAll(fullPath)
-		return nil, errFailedSaveKeys.affix(err)
+		return nil, ErrFailedSaveKeys.affix(err)
 	}
-	info_logf("finish write keys to db (prefix:%v, keylen:%v, packsize:%v)", prefix, keylen, packsize)
+	log.debug_logc(ctx, "finish write keys to db", "prefix", prefix, "keylen", keylen, "packsize", packsize)
 
 	p := &Pack{
 		info: info,
 		path: fullPath,
 		Name: info.Name,
 		db:   db,
+		log:  log,
 	}
 
 	if err := p.saveInfo(); err != nil {
@@ -164,48 +309,75 @@ func CreatePack(path string, name, prefix string, keylen, packsize int, note str
 		return nil, err
 	}
 
+	p.setStats(PackStats{Ready: len(keys)})
 	return p, nil
 }
 
 func (p *Pack) saveInfo() error {
-	info_logf("start save PackInfo (name:%v, path:%v)", p.Name, p.path)
+	p.log.debug_log("start save PackInfo", "name", p.Name, "path", p.path)
 	b, err := json.Marshal(p.info)
 	if err != nil {
-		error_logf("failed marshal PackInfo: %v", err)
-		return errFailedSavePackInfo.affix(err)
+		p.log.error_log("failed marshal PackInfo", "err", err)
+		return ErrFailedSavePackInfo.affix(err)
 	}
 	err = ioutil.WriteFile(filepath.Join(p.path, "pack.json"), b, 0644)
 	if err != nil {
-		error_logf("failed save pack.json: %v", err)
-		return errFailedSavePackInfo.affix(err)
+		p.log.error_log("failed save pack.json", "err", err)
+		return ErrFailedSavePackInfo.affix(err)
 	}
-	info_logf("finish save PackInfo (name:%v, path:%v)", p.Name, p.path)
+	p.log.debug_log("finish save PackInfo", "name", p.Name, "path", p.path)
 	return nil
 }
 
+// Info returns the PackInfo, with Name set to the project-qualified name of
+// the pack and the current Stats.
 func (p *Pack) Info() PackInfo {
 	p.infoMtx.RLock()
 	defer p.infoMtx.RUnlock()
 
-	return p.info
+	info := p.info
+	info.Name = p.Name
+	info.Project, _ = splitPackName(p.Name)
+	stats := p.Stats()
+	info.Stats = &stats
+	return info
 }
 
 func (p *Pack) Enable() error {
+	return p.setStatus(packStatus("ready"), EventPackEnabled)
+}
+
+func (p *Pack) Disable(msg string) error {
+	if msg == "ready" {
+		msg = ""
+	}
+	return p.setStatus(packStatus(msg), EventPackDisabled)
+}
+
+// setStatus saves the status of the pack and then publishes an event of typ,
+// outside of p.infoMtx, since listeners may be slow.
+func (p *Pack) setStatus(status packStatus, typ string) error {
 	p.infoMtx.Lock()
-	defer p.infoMtx.Unlock()
+	p.info.Status = status
+	err := p.saveInfo()
+	e := p.eventLocked(typ, "")
+	p.infoMtx.Unlock()
 
-	p.info.Status = packStatus("ready")
-	return p.saveInfo()
+	if err != nil {
+		return err
+	}
+
+	p.events.publish(e)
+	return nil
 }
 
-func (p *Pack) Disable(msg string) error {
+// SetGuard sets the brute-force protection of the pack, see GuardConfig. A nil
+// cfg restores the server default.
+func (p *Pack) SetGuard(cfg *GuardConfig) error {
 	p.infoMtx.Lock()
 	defer p.infoMtx.Unlock()
 
-	if msg == "ready" {
-		msg = ""
-	}
-	p.info.Status = packStatus(msg)
+	p.info.Guard = cfg
 	return p.saveInfo()
 }
 
@@ -215,86 +387,408 @@ type KeyInfo struct {
 }
 
 func (p *Pack) ListKeys() ([]KeyInfo, error) {
+	return p.ListKeysContext(context.Background())
+}
+
+// ListKeysContext is like ListKeys, but stops iterating and returns
+// ErrCanceled once ctx is done.
+func (p *Pack) ListKeysContext(ctx context.Context) ([]KeyInfo, error) {
+	ks, _, err := p.QueryKeysContext(ctx, KeyQuery{})
+	return ks, err
+}
+
+// KeyQuery selects a page of the keys of a pack, in key order. Zero fields
+// match everything. Match is a key prefix, normalized like keys. If Limit is
+// positive at most Limit keys are returned, after skipping Offset matching
+// keys.
+type KeyQuery struct {
+	Status string `json:"status"`
+	Match  string `json:"match"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+}
+
+func (q KeyQuery) match(key string, status keyStatus) bool {
+	switch {
+	case q.Status != "" && q.Status != status.String():
+		return false
+	case q.Match != "" && !strings.HasPrefix(key, q.Match):
+		return false
+	}
+	return true
+}
+
+// QueryKeys returns the keys selected by q and the number of all keys
+// matching q.
+func (p *Pack) QueryKeys(q KeyQuery) ([]KeyInfo, int, error) {
+	return p.QueryKeysContext(context.Background(), q)
+}
+
+// QueryKeysContext is like QueryKeys, but stops iterating and returns
+// ErrCanceled once ctx is done.
+func (p *Pack) QueryKeysContext(ctx context.Context, q KeyQuery) ([]KeyInfo, int, error) {
 	p.closeMtx.RLock()
 	defer p.closeMtx.RUnlock()
 
 	if p.db == nil {
-		return nil, errPackClosing
+		return nil, 0, ErrPackClosing
+	}
+
+	if m, ok := NormalizeKey(q.Match); ok {
+		q.Match = m
 	}
 
 	var ks []KeyInfo
-	iter := p.db.NewIterator(nil, nil)
+	total := 0
+	iter := p.db.NewIterator()
 	defer iter.Release()
 
-	for iter.Next() {
+	for i := 0; iter.Next(); i++ {
+		if i%checkInterval == 0 {
+			if err := checkContext(ctx); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		key, status := string(iter.Key()), loadKeyStatus(iter.Value())
+		if !q.match(key, status) {
+			continue
+		}
+
+		total++
+		if total <= q.Offset || (q.Limit > 0 && len(ks) >= q.Limit) {
+			continue
+		}
 		ks = append(ks, KeyInfo{
-			Key:    string(iter.Key()),
-			Status: loadKeyStatus(iter.Value()).String(),
+			Key:    key,
+			Status: status.String(),
 		})
 	}
 
 	if iter.Error() != nil {
-		error_log(iter.Error())
-		return nil, errFailedLoadKeys.affix(iter.Error())
+		p.log.error_logc(ctx, "failed list keys", "pack", p.Name, "err", iter.Error())
+		return nil, 0, ErrFailedLoadKeys.affix(iter.Error())
 	}
 
-	info_logf("list keys (pack:%v, packsize:%v)", p.Name, len(ks))
+	p.log.debug_logc(ctx, "list keys", "pack", p.Name, "total", total, "returned", len(ks))
 
-	return ks, nil
+	return ks, total, nil
 }
 
 func (p *Pack) UseKey(key string) error {
+	return p.UseKeyContext(context.Background(), key)
+}
+
+// UseKeyContext is like UseKey, but returns ErrCanceled without using the key
+// if ctx is already done. Reserved keys can be used, see ReserveKey.
+func (p *Pack) UseKeyContext(ctx context.Context, key string) error {
+	return p.setKeyStatus(ctx, key, keyUsed, true)
+}
+
+// ReserveKey marks a ready key as reserved: it can no longer be used or
+// reserved by anyone else, until it is used with UseKey or returned with
+// ReleaseKey. Reservations do not expire.
+func (p *Pack) ReserveKey(key string) error {
+	return p.ReserveKeyContext(context.Background(), key)
+}
+
+// ReserveKeyContext is like ReserveKey, but returns ErrCanceled without
+// reserving the key if ctx is already done.
+func (p *Pack) ReserveKeyContext(ctx context.Context, key string) error {
+	return p.setKeyStatus(ctx, key, keyReserved, true)
+}
+
+// ReleaseKey marks a reserved key as ready again. It works on disabled packs,
+// so reservations can be returned at any time.
+func (p *Pack) ReleaseKey(key string) error {
+	return p.ReleaseKeyContext(context.Background(), key)
+}
+
+// ReleaseKeyContext is like ReleaseKey, but returns ErrCanceled without
+// releasing the key if ctx is already done.
+func (p *Pack) ReleaseKeyContext(ctx context.Context, key string) error {
+	return p.setKeyStatus(ctx, key, keyReady, false)
+}
+
+// RevokeKey marks a ready or reserved key as revoked, e.g. because it leaked.
+// Revoked keys can not be used, reserved or released.
+func (p *Pack) RevokeKey(key string) error {
+	return p.RevokeKeyContext(context.Background(), key)
+}
+
+// RevokeKeyContext is like RevokeKey, but returns ErrCanceled without
+// revoking the key if ctx is already done.
+func (p *Pack) RevokeKeyContext(ctx context.Context, key string) error {
+	return p.setKeyStatus(ctx, key, keyRevoked, false)
+}
+
+// CheckKey returns the status of a key without changing it.
+func (p *Pack) CheckKey(key string) (KeyInfo, error) {
+	return p.CheckKeyContext(context.Background(), key)
+}
+
+// CheckKeyContext is like CheckKey, but returns ErrCanceled if ctx is already
+// done.
+func (p *Pack) CheckKeyContext(ctx context.Context, key string) (KeyInfo, error) {
+	if err := checkContext(ctx); err != nil {
+		return KeyInfo{}, err
+	}
+
+	p.closeMtx.RLock()
+	defer p.closeMtx.RUnlock()
+
+	if p.db == nil {
+		return KeyInfo{}, ErrPackClosing
+	}
+
+	key, _ = NormalizeKey(key)
+	status, err := p.loadKey(ctx, key)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	return KeyInfo{Key: key, Status: status.String()}, nil
+}
+
+func (p *Pack) loadKey(ctx context.Context, key string) (keyStatus, error) {
+	b, err := p.db.Get([]byte(key))
+	if err != nil {
+		if err == ErrStoreNotFound {
+			p.log.info_logc(ctx, "key not found", "pack", p.Name, "key", key)
+			return keyUsed, ErrKeyNotFound.affix(fmt.Sprintf("key:%v", key))
+		} else {
+			p.log.error_logc(ctx, "failed load key", "pack", p.Name, "key", key, "err", err)
+			return keyUsed, ErrFailedLoadKeys.affix(err)
+		}
+	}
+	return loadKeyStatus(b), nil
+}
+
+// setKeyStatus changes the status of a key to status, if the current status
+// allows it:
+//
+//	keyUsed      from ready or reserved
+//	keyReserved  from ready
+//	keyReady     from reserved, i.e. a release
+//	keyRevoked   from ready or reserved
+//
+// If needReady is set, the pack must be enabled. The event of the change is
+// published once the locks of the pack are released, since listeners may be
+// slow.
+func (p *Pack) setKeyStatus(ctx context.Context, key string, status keyStatus, needReady bool) error {
+	e, err := p.changeKeyStatus(ctx, key, status, needReady)
+	if err != nil {
+		return err
+	}
+
+	p.events.publish(e)
+	return nil
+}
+
+// changeKeyStatus does the work of setKeyStatus and returns the event to
+// publish.
+func (p *Pack) changeKeyStatus(ctx context.Context, key string, status keyStatus, needReady bool) (Event, error) {
+	if err := checkContext(ctx); err != nil {
+		return Event{}, err
+	}
+
 	p.closeMtx.RLock()
 	defer p.closeMtx.RUnlock()
 
 	if p.db == nil {
-		return errPackClosing
+		return Event{}, ErrPackClosing
 	}
 
 	p.infoMtx.RLock()
 	defer p.infoMtx.RUnlock()
 
-	if !p.info.Status.Ready() {
-		info_logf("pack is disabled (pack:%v, msg:%v)", p.Name, p.info.Status)
-		return errPackDisabled.affix(fmt.Sprintf("msg:%v", p.info.Status))
+	if needReady && !p.info.Status.Ready() {
+		p.log.info_logc(ctx, "pack is disabled", "pack", p.Name, "msg", p.info.Status)
+		return Event{}, ErrPackDisabled.affix(fmt.Sprintf("msg:%v", p.info.Status))
 	}
 
 	key, _ = NormalizeKey(key)
 
-	b, err := p.db.Get([]byte(key), nil)
+	p.useMtx.Lock()
+	defer p.useMtx.Unlock()
+
+	old, err := p.loadKey(ctx, key)
 	if err != nil {
-		if err == leveldb.ErrNotFound {
-			info_logf("key not found (pack:%v, key:%v)", p.Name, key)
-			return errKeyNotFound.affix(fmt.Sprintf("key:%v", key))
-		} else {
-			error_log(err)
-			return errFailedLoadKeys.affix(err)
-		}
+		return Event{}, err
 	}
 
-	status := loadKeyStatus(b)
+	switch {
+	case status == keyReady && old != keyReserved:
+		return Event{}, ErrKeyNotReserved.affix(fmt.Sprintf("key:%v", key))
+	case old == keyUsed:
+		return Event{}, ErrKeyUsed.affix(fmt.Sprintf("key:%v", key))
+	case old == keyRevoked:
+		return Event{}, ErrKeyRevoked.affix(fmt.Sprintf("key:%v", key))
+	case status == keyReserved && old == keyReserved:
+		return Event{}, ErrKeyReserved.affix(fmt.Sprintf("key:%v", key))
+	}
 
-	if !status {
-		return errKeyUsed.affix(fmt.Sprintf("key:%v", key))
+	if err := p.db.Put([]byte(key), status.dbVal()); err != nil {
+		p.log.error_logc(ctx, "failed save key", "pack", p.Name, "key", key, "err", err)
+		return Event{}, ErrFailedSaveKeys.affix(err)
 	}
 
-	if err := p.db.Put([]byte(key), keyStatus(false).dbVal(), nil); err != nil {
-		error_log(err)
-		return errFailedSaveKeys.affix(err)
+	p.countStatus(old, status)
+
+	var typ string
+	switch status {
+	case keyUsed:
+		p.log.info_logc(ctx, "key use", "pack", p.Name, "key", key)
+		typ = EventKeyUsed
+	case keyReserved:
+		p.log.info_logc(ctx, "key reserve", "pack", p.Name, "key", key)
+		typ = EventKeyReserved
+	case keyReady:
+		p.log.info_logc(ctx, "key release", "pack", p.Name, "key", key)
+		typ = EventKeyReleased
+	case keyRevoked:
+		p.log.info_logc(ctx, "key revoke", "pack", p.Name, "key", key)
+		typ = EventKeyRevoked
 	}
+	return p.eventLocked(typ, key), nil
+}
+
+// Extend generates n more ready keys with the Prefix and KeyLen of the pack
+// and grows its PackSize by n.
+func (p *Pack) Extend(n int) error {
+	return p.ExtendContext(context.Background(), n)
+}
 
-	info_logf("key use (pack:%v, key:%v)", p.Name, key)
+// ExtendContext is like Extend, but stops generating keys and returns
+// ErrCanceled once ctx is done.
+func (p *Pack) ExtendContext(ctx context.Context, n int) error {
+	e, err := p.extend(ctx, n)
+	if err != nil {
+		return err
+	}
+
+	p.events.publish(e)
 	return nil
 }
 
+// extend does the work of ExtendContext and returns the event to publish
+// once the locks of the pack are released.
+func (p *Pack) extend(ctx context.Context, n int) (Event, error) {
+	if n <= 0 {
+		return Event{}, ErrBadRequest.affix(fmt.Sprintf("count:%v", n))
+	}
+
+	p.extendMtx.Lock()
+	defer p.extendMtx.Unlock()
+
+	info := p.Info()
+	rndLen := info.KeyLen - len(info.Prefix)
+	size := info.PackSize + n
+	if math.Pow(float64(charSetLen), float64(rndLen)) < float64(size*100) {
+		return Event{}, ErrKeylenTooShort.affix(fmt.Sprintf("keylen:%v, rndLen:%v, size:%v", info.KeyLen, rndLen, size))
+	}
+
+	p.log.debug_logc(ctx, "start extend pack", "name", p.Name, "count", n)
+
+	// The keys are generated without closeMtx, which is only held to look up
+	// a chunk of them and for the write, so that an extension does not hold
+	// up closing the pack. extendMtx keeps other extensions from adding keys
+	// meanwhile.
+	keys := make(map[string]struct{})
+	for len(keys) < n {
+		if err := checkContext(ctx); err != nil {
+			return Event{}, err
+		}
+
+		var chunk []string
+		for len(chunk) < checkInterval && len(keys)+len(chunk) < n {
+			k := keyGen1(info.Prefix, rndLen)
+			if _, ok := keys[k]; !ok {
+				chunk = append(chunk, k)
+			}
+		}
+
+		fresh, err := p.absentKeys(ctx, chunk)
+		if err != nil {
+			return Event{}, err
+		}
+		for _, k := range fresh {
+			keys[k] = struct{}{}
+		}
+	}
+
+	batch := &Batch{}
+	for k := range keys {
+		batch.Put([]byte(k), keyReady.dbVal())
+	}
+
+	p.closeMtx.RLock()
+	defer p.closeMtx.RUnlock()
+
+	if p.db == nil {
+		return Event{}, ErrPackClosing
+	}
+
+	p.infoMtx.Lock()
+	defer p.infoMtx.Unlock()
+
+	if err := p.db.Write(batch); err != nil {
+		p.log.error_logc(ctx, "failed save keys", "name", p.Name, "err", err)
+		return Event{}, ErrFailedSaveKeys.affix(err)
+	}
+	atomic.AddInt64(&p.ready, int64(n))
+
+	p.info.PackSize += n
+	if err := p.saveInfo(); err != nil {
+		return Event{}, err
+	}
+
+	p.log.info_logc(ctx, "pack extended", "name", p.Name, "count", n, "packsize", p.info.PackSize)
+	return p.eventLocked(EventPackExtended, ""), nil
+}
+
+// absentKeys returns the keys which are not in the store yet. A key generated
+// twice within keys is returned once.
+func (p *Pack) absentKeys(ctx context.Context, keys []string) ([]string, error) {
+	p.closeMtx.RLock()
+	defer p.closeMtx.RUnlock()
+
+	if p.db == nil {
+		return nil, ErrPackClosing
+	}
+
+	var absent []string
+	seen := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+
+		if _, err := p.db.Get([]byte(k)); err == nil {
+			continue
+		} else if err != ErrStoreNotFound {
+			p.log.error_logc(ctx, "failed load key", "pack", p.Name, "key", k, "err", err)
+			return nil, ErrFailedLoadKeys.affix(err)
+		}
+		absent = append(absent, k)
+	}
+	return absent, nil
+}
+
+// Close closes the store of the pack. Closing a closed pack does nothing.
 func (p *Pack) Close() {
 	p.closeMtx.Lock()
 	defer p.closeMtx.Unlock()
 
-	info_logf("start close pack (name:%v)", p.Name)
+	if p.db == nil {
+		return
+	}
+
+	p.log.debug_log("start close pack", "name", p.Name)
 
 	p.db.Close()
 	p.db = nil
 
-	info_logf("pack closed (name:%v)", p.Name)
+	p.log.info_log("pack closed", "name", p.Name)
 }
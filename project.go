@@ -0,0 +1,393 @@
+package cdkey
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A project is a namespace of packs, e.g. one per game. The packs of project
+// "game1" are stored in the sub directory game1 of the server directory and
+// are addressed by project-qualified names like "game1/summer". Packs created
+// with a plain name belong to the default project "" and live in the server
+// directory itself.
+//
+// Within a project no pack prefix may be a prefix of another pack's prefix, so
+// that every key identifies its pack. Packs of different projects and of the
+// default project may share prefixes.
+
+// ProjectInfo describes a project and its quotas. A zero quota means unlimited.
+type ProjectInfo struct {
+	Name       string    `json:"name"`
+	MaxPacks   int       `json:"maxPacks"`
+	MaxKeys    int       `json:"maxKeys"`
+	Note       string    `json:"note"`
+	CreateTime time.Time `json:"createTime"`
+}
+
+// splitPackName splits a project-qualified pack name into project and pack.
+func splitPackName(name string) (project, pack string) {
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+func qualifyPackName(project, pack string) string {
+	if project == "" {
+		return pack
+	}
+	return project + "/" + pack
+}
+
+// validName reports whether name is a valid project or unqualified pack name.
+// Names starting with a dot, like "." and "..", are rejected since they would
+// address another directory or hide the one they create.
+func validName(name string) bool {
+	return name != "" && name[0] != '.' && !strings.ContainsAny(name, `<>:"/\|?*_`)
+}
+
+// validPackName reports whether name is a valid pack name, optionally
+// qualified by a single valid project name.
+func validPackName(name string) bool {
+	project, pack := splitPackName(name)
+	if strings.Contains(name, "/") && !validName(project) {
+		return false
+	}
+	return validName(pack)
+}
+
+// projectDir returns the directory of the project name, the server directory
+// for the default project "".
+func (s *Server) projectDir(name string) (string, error) {
+	if name == "" {
+		return s.path, nil
+	}
+	dir, ok := subDir(s.path, name)
+	if !ok {
+		return "", ErrInvalidProjectName.affix(fmt.Sprintf("name:%v", name))
+	}
+	return dir, nil
+}
+
+// packDir returns the directory of the pack with the project-qualified name.
+func (s *Server) packDir(name string) (string, error) {
+	project, pack := splitPackName(name)
+	projectDir, err := s.projectDir(project)
+	if err != nil {
+		return "", ErrInvalidPackName.affix(fmt.Sprintf("name:%v", name))
+	}
+	dir, ok := subDir(projectDir, pack)
+	if !ok {
+		return "", ErrInvalidPackName.affix(fmt.Sprintf("name:%v", name))
+	}
+	return dir, nil
+}
+
+// subDir joins parent and name and reports whether the cleaned path is still a
+// direct sub directory of parent, which it is not for names like "." or "..".
+func subDir(parent, name string) (string, bool) {
+	dir := filepath.Join(parent, name)
+	rel, err := filepath.Rel(parent, dir)
+	if err != nil || rel == "." || rel == ".." || strings.ContainsRune(rel, filepath.Separator) {
+		return "", false
+	}
+	return dir, true
+}
+
+func (s *Server) loadProjectInfo(dir string) (ProjectInfo, error) {
+	var info ProjectInfo
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "project.json"))
+	if err != nil {
+		s.log.error_log("failed read project.json", "dir", dir, "err", err)
+		return info, ErrFailedLoadProjectInfo.affix(err)
+	}
+
+	if err := json.Unmarshal(b, &info); err != nil {
+		s.log.error_log("failed parse project.json", "dir", dir, "err", err)
+		return info, ErrFailedLoadProjectInfo.affix(err)
+	}
+
+	return info, nil
+}
+
+func (s *Server) saveProjectInfo(dir string, info ProjectInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		s.log.error_log("failed marshal ProjectInfo", "err", err)
+		return ErrFailedSaveProjectInfo.affix(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "project.json"), b, 0644); err != nil {
+		s.log.error_log("failed save project.json", "err", err)
+		return ErrFailedSaveProjectInfo.affix(err)
+	}
+
+	return nil
+}
+
+// checkProjectLimits checks that a new pack fits into the quotas of its
+// project and that its prefix is isolated from the other packs of the project.
+// The keys reserved by running extensions count as well. The caller must hold
+// s.mtx.
+func (s *Server) checkProjectLimits(name, prefix string, packsize int) error {
+	project, _ := splitPackName(name)
+	if project == "" {
+		return nil
+	}
+
+	info, ok := s.projects[project]
+	if !ok {
+		s.log.warn_log("project not found", "name", project)
+		return ErrProjectNotFound.affix(fmt.Sprintf("name:%v", project))
+	}
+
+	prefix, _ = NormalizeKey(prefix)
+
+	packs, keys := 0, packsize+s.pending[project]
+	for _, p := range s.packs {
+		pi := p.Info()
+		if pi.Project != project {
+			continue
+		}
+
+		packs++
+		keys += pi.PackSize
+
+		if strings.HasPrefix(prefix, pi.Prefix) || strings.HasPrefix(pi.Prefix, prefix) {
+			s.log.warn_log("prefix conflict", "pack", name, "prefix", prefix, "other", pi.Name, "otherPrefix", pi.Prefix)
+			return ErrPrefixConflict.affix(fmt.Sprintf("prefix:%v, pack:%v, packPrefix:%v", prefix, pi.Name, pi.Prefix))
+		}
+	}
+
+	if info.MaxPacks > 0 && packs+1 > info.MaxPacks {
+		s.log.warn_log("project pack quota exceeded", "project", project, "maxPacks", info.MaxPacks)
+		return ErrQuotaExceeded.affix(fmt.Sprintf("project:%v, maxPacks:%v", project, info.MaxPacks))
+	}
+
+	if info.MaxKeys > 0 && keys > info.MaxKeys {
+		s.log.warn_log("project key quota exceeded", "project", project, "keys", keys, "maxKeys", info.MaxKeys)
+		return ErrQuotaExceeded.affix(fmt.Sprintf("project:%v, keys:%v, maxKeys:%v", project, keys, info.MaxKeys))
+	}
+
+	return nil
+}
+
+// checkProjectKeys checks that extra more keys for the named pack fit into the
+// key quota of its project, next to those reserved by running extensions. The
+// caller must hold s.mtx.
+func (s *Server) checkProjectKeys(name string, extra int) error {
+	project, _ := splitPackName(name)
+	if project == "" {
+		return nil
+	}
+
+	info, ok := s.projects[project]
+	if !ok || info.MaxKeys == 0 {
+		return nil
+	}
+
+	keys := extra + s.pending[project]
+	for _, p := range s.packs {
+		if pi := p.Info(); pi.Project == project {
+			keys += pi.PackSize
+		}
+	}
+
+	if keys > info.MaxKeys {
+		s.log.warn_log("project key quota exceeded", "project", project, "keys", keys, "maxKeys", info.MaxKeys)
+		return ErrQuotaExceeded.affix(fmt.Sprintf("project:%v, keys:%v, maxKeys:%v", project, keys, info.MaxKeys))
+	}
+
+	return nil
+}
+
+func (s *Server) AddProject(name string, maxPacks, maxKeys int, note string) error {
+	return s.AddProjectContext(context.Background(), name, maxPacks, maxKeys, note)
+}
+
+func (s *Server) AddProjectContext(ctx context.Context, name string, maxPacks, maxKeys int, note string) (err error) {
+	defer func() { s.audit(ctx, "project.add", name, "", err) }()
+
+	if !validName(name) {
+		s.log.warn_logc(ctx, "invalid project name", "name", name)
+		return ErrInvalidProjectName.affix(fmt.Sprintf("name:%v", name))
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	_, exists := s.projects[name]
+	_, loaded := s.packs[name]
+	_, broken := s.broken[name]
+	if exists || loaded || broken {
+		s.log.warn_logc(ctx, "project already exists", "name", name)
+		return ErrProjectAlreadyExists.affix(fmt.Sprintf("name:%v", name))
+	}
+
+	info := ProjectInfo{
+		Name:       name,
+		MaxPacks:   maxPacks,
+		MaxKeys:    maxKeys,
+		Note:       note,
+		CreateTime: s.opts.Clock.Now(),
+	}
+
+	dir, err := s.projectDir(name)
+	if err != nil {
+		s.log.warn_logc(ctx, "invalid project name", "name", name)
+		return err
+	}
+	if err := os.Mkdir(dir, s.opts.DirPerm); err != nil {
+		s.log.error_logc(ctx, "failed mkdir", "path", dir, "err", err)
+		return ErrFailedSaveProjectInfo.affix(err)
+	}
+
+	if err := s.saveProjectInfo(dir, info); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	s.projects[name] = info
+
+	s.log.info_logc(ctx, "project added", "name", name, "maxPacks", maxPacks, "maxKeys", maxKeys)
+	return nil
+}
+
+// SetProjectQuota changes the quotas of a project. Existing packs are kept even
+// if they exceed the new quotas.
+func (s *Server) SetProjectQuota(name string, maxPacks, maxKeys int) error {
+	return s.SetProjectQuotaContext(context.Background(), name, maxPacks, maxKeys)
+}
+
+func (s *Server) SetProjectQuotaContext(ctx context.Context, name string, maxPacks, maxKeys int) (err error) {
+	defer func() { s.audit(ctx, "project.quota", name, "", err) }()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	info, ok := s.projects[name]
+	if !ok {
+		s.log.warn_logc(ctx, "project not found", "name", name)
+		return ErrProjectNotFound.affix(fmt.Sprintf("name:%v", name))
+	}
+
+	dir, err := s.projectDir(name)
+	if err != nil {
+		return err
+	}
+
+	info.MaxPacks, info.MaxKeys = maxPacks, maxKeys
+	if err := s.saveProjectInfo(dir, info); err != nil {
+		return err
+	}
+
+	s.projects[name] = info
+
+	s.log.info_logc(ctx, "project quota set", "name", name, "maxPacks", maxPacks, "maxKeys", maxKeys)
+	return nil
+}
+
+// RemoveProject removes an empty project.
+func (s *Server) RemoveProject(name string) error {
+	return s.RemoveProjectContext(context.Background(), name)
+}
+
+func (s *Server) RemoveProjectContext(ctx context.Context, name string) (err error) {
+	defer func() { s.audit(ctx, "project.remove", name, "", err) }()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.projects[name]; !ok {
+		s.log.warn_logc(ctx, "project not found", "name", name)
+		return ErrProjectNotFound.affix(fmt.Sprintf("name:%v", name))
+	}
+
+	for packName := range s.packs {
+		if project, _ := splitPackName(packName); project == name {
+			s.log.warn_logc(ctx, "project not empty", "name", name)
+			return ErrProjectNotEmpty.affix(fmt.Sprintf("name:%v", name))
+		}
+	}
+	for packName := range s.broken {
+		if project, _ := splitPackName(packName); project == name {
+			s.log.warn_logc(ctx, "project not empty", "name", name)
+			return ErrProjectNotEmpty.affix(fmt.Sprintf("name:%v", name))
+		}
+	}
+
+	dir, err := s.projectDir(name)
+	if err != nil {
+		s.log.warn_logc(ctx, "invalid project name", "name", name)
+		return err
+	}
+
+	os.RemoveAll(dir)
+	delete(s.projects, name)
+
+	s.log.info_logc(ctx, "project removed", "name", name)
+	return nil
+}
+
+// GetProject returns the ProjectInfo of a project.
+func (s *Server) GetProject(name string) (ProjectInfo, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	info, ok := s.projects[name]
+	if !ok {
+		s.log.warn_log("project not found", "name", name)
+		return ProjectInfo{}, ErrProjectNotFound.affix(fmt.Sprintf("name:%v", name))
+	}
+	return info, nil
+}
+
+func (s *Server) ListProjects() []ProjectInfo {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	projects := make([]ProjectInfo, 0, len(s.projects))
+	for _, info := range s.projects {
+		projects = append(projects, info)
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].Name < projects[j].Name
+	})
+	return projects
+}
+
+// authorizeProject returns nil if the principal of ctx may see the project:
+// with a role bound to all of its packs, e.g. on "game1/*", or to one of them.
+func (s *Server) authorizeProject(ctx context.Context, name string) error {
+	err := s.Authorize(ctx, PermPackView, qualifyPackName(name, "*"))
+	if err == nil {
+		return nil
+	}
+
+	for _, p := range s.ListProjectPacks(name) {
+		if s.Authorize(ctx, PermPackView, p.Name) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// ListProjectPacks is like ListPacks, but only returns packs of the given
+// project. Use "" for the default project.
+func (s *Server) ListProjectPacks(project string) []PackInfo {
+	var packs []PackInfo
+	for _, p := range s.ListPacks() {
+		if p.Project == project {
+			packs = append(packs, p)
+		}
+	}
+	return packs
+}
@@ -0,0 +1,227 @@
+package cdkey
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestValidPackName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"summer":       true,
+		"game1/summer": true,
+		"":             false,
+		"/summer":      false,
+		"game1/":       false,
+		"a/b/c":        false,
+		"game_1/x":     false,
+		".":            false,
+		"..":           false,
+		".hidden":      false,
+		"game1/.":      false,
+		"game1/..":     false,
+		"../summer":    false,
+	} {
+		if got := validPackName(name); got != want {
+			t.Errorf("validPackName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestPackDirTraversal(t *testing.T) {
+	s, err := NewServerWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if err := s.AddProject("game1", 0, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"game1/..", "game1/.", "..", ".", "../game1"} {
+		if _, err := s.packDir(name); !errors.Is(err, ErrInvalidPackName) {
+			t.Errorf("packDir(%q): %v, want ErrInvalidPackName", name, err)
+		}
+		if err := s.AddPack(name, "S", 12, 20, ""); !errors.Is(err, ErrInvalidPackName) {
+			t.Errorf("AddPack(%q): %v, want ErrInvalidPackName", name, err)
+		}
+		if err := s.RemovePack(name); err == nil {
+			t.Errorf("RemovePack(%q) succeeded", name)
+		}
+	}
+	for _, name := range []string{"..", "."} {
+		if _, err := s.projectDir(name); !errors.Is(err, ErrInvalidProjectName) {
+			t.Errorf("projectDir(%q): %v, want ErrInvalidProjectName", name, err)
+		}
+		if err := s.AddProject(name, 0, 0, ""); !errors.Is(err, ErrInvalidProjectName) {
+			t.Errorf("AddProject(%q): %v, want ErrInvalidProjectName", name, err)
+		}
+	}
+
+	if !fileExists(filepath.Join(s.path, "game1", "project.json")) {
+		t.Error("project directory removed")
+	}
+}
+
+// gatedStorage is LevelDBStorage whose stores, once armed, report the next
+// Get on started and wait for release before doing it.
+type gatedStorage struct {
+	LevelDBStorage
+	armed   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g *gatedStorage) Create(path string) (Store, error) {
+	st, err := g.LevelDBStorage.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return gatedStore{st, g}, nil
+}
+
+func (g *gatedStorage) Open(path string) (Store, error) {
+	st, err := g.LevelDBStorage.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return gatedStore{st, g}, nil
+}
+
+type gatedStore struct {
+	Store
+	g *gatedStorage
+}
+
+func (st gatedStore) Get(key []byte) ([]byte, error) {
+	if atomic.CompareAndSwapInt32(&st.g.armed, 1, 0) {
+		close(st.g.started)
+		<-st.g.release
+	}
+	return st.Store.Get(key)
+}
+
+func TestExtendPackUnlocked(t *testing.T) {
+	g := &gatedStorage{started: make(chan struct{}), release: make(chan struct{})}
+	s, err := NewServerWithOptions(t.TempDir(), Options{Storage: g})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if err := s.AddProject("game1", 0, 40, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddPack("game1/summer", "S", 12, 20, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ExtendPack("game1/summer", 25); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("extend over quota: %v, want ErrQuotaExceeded", err)
+	}
+
+	// Hold the extension at its first lookup.
+	p := s.packs["game1/summer"]
+	atomic.StoreInt32(&g.armed, 1)
+	extended := make(chan error, 1)
+	go func() { extended <- s.ExtendPack("game1/summer", 10) }()
+	<-g.started
+
+	// The keys of the running extension are reserved in the quota.
+	if err := s.ExtendPack("game1/summer", 15); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("concurrent extend over quota: %v, want ErrQuotaExceeded", err)
+	}
+	if err := s.AddPack("game1/winter", "W", 12, 15, ""); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("add pack over quota during an extension: %v, want ErrQuotaExceeded", err)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		s.mtx.Lock()
+		s.mtx.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server lock held during the extension")
+	}
+
+	// Remove the pack once its Close waits for the lookup, then let the
+	// extension go on.
+	removed := make(chan error, 1)
+	go func() { removed <- s.RemovePack("game1/summer") }()
+	for p.closeMtx.TryRLock() {
+		p.closeMtx.RUnlock()
+		time.Sleep(time.Millisecond)
+	}
+	close(g.release)
+
+	if err := <-removed; err != nil {
+		t.Fatalf("remove during extension: %v", err)
+	}
+	if err := <-extended; !errors.Is(err, ErrPackClosing) {
+		t.Errorf("extension of a removed pack: %v, want ErrPackClosing", err)
+	}
+
+	if err := s.AddPack("game1/winter", "W", 12, 40, ""); err != nil {
+		t.Errorf("add pack after the extension failed: %v, want the quota free again", err)
+	}
+}
+
+func TestBrokenProject(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewServerWithOptions(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddProject("game1", 0, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddPack("game1/summer", "S", 12, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+
+	projectFile := filepath.Join(dir, "game1", "project.json")
+	good, err := os.ReadFile(projectFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(projectFile, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewServerWithOptions(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	info, err := s.GetPackInfo("game1")
+	if err != nil || info.Status != "broken" || info.Error == "" {
+		t.Fatalf("broken project: %+v, %v", info, err)
+	}
+	if err := s.RemovePack("game1"); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("remove broken project: %v, want ErrBadRequest", err)
+	}
+	if err := s.ReloadPack("game1"); !errors.Is(err, ErrFailedLoadProjectInfo) {
+		t.Errorf("reload still broken project: %v, want ErrFailedLoadProjectInfo", err)
+	}
+
+	if err := os.WriteFile(projectFile, good, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadPack("game1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetPackInfo("game1"); !errors.Is(err, ErrPackNotFound) {
+		t.Errorf("reloaded project still listed: %v", err)
+	}
+	if info, err := s.GetPackInfo("game1/summer"); err != nil || info.PackSize != 10 {
+		t.Errorf("pack of the reloaded project: %+v, %v", info, err)
+	}
+}
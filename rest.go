@@ -0,0 +1,808 @@
+package cdkey
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The /v1 REST API is a resource oriented view of the dot commands, built on
+// the same Server methods:
+//
+//	GET    /v1/packs                             list packs, ?project= filters
+//	POST   /v1/packs                             add a pack
+//	GET    /v1/packs/{name}                      pack info
+//	DELETE /v1/packs/{name}                      remove a pack
+//	POST   /v1/packs/{name}/enable               enable a pack
+//	POST   /v1/packs/{name}/disable              disable a pack, {"msg":...}
+//	POST   /v1/packs/{name}/reload               reload a broken pack
+//	POST   /v1/packs/{name}/verify               verify a pack, ?repair=true repairs
+//	POST   /v1/packs/{name}/extend               generate more keys, {"count":...}
+//	PUT    /v1/packs/{name}/guard                set the GuardConfig
+//	DELETE /v1/packs/{name}/guard                restore the default GuardConfig
+//	GET    /v1/packs/{name}/keys                 list keys, ?status=&match=&offset=&limit=
+//	GET    /v1/packs/{name}/keys/{key}           key status
+//	POST   /v1/packs/{name}/keys/{key}/redeem    use a key
+//	POST   /v1/packs/{name}/keys/{key}/revoke    revoke a key
+//	GET    /v1/keys/{key}                        search a key in all packs
+//	GET    /v1/projects                          list projects
+//	POST   /v1/projects                          add a project
+//	GET    /v1/projects/{name}                   project info
+//	DELETE /v1/projects/{name}                   remove an empty project
+//	PUT    /v1/projects/{name}/quota             set the quotas
+//	GET    /v1/projects/{name}/packs             list the packs of a project
+//	GET    /v1/tokens                            list API tokens
+//	POST   /v1/tokens                            create an API token
+//	DELETE /v1/tokens/{id}                       revoke an API token
+//	GET    /v1/webhooks                          list webhooks
+//	POST   /v1/webhooks                          add a webhook
+//	DELETE /v1/webhooks/{id}                     remove a webhook
+//	GET    /v1/webhooks/{id}/deliveries          recent deliveries, ?limit=
+//	GET    /v1/audit                             audit records, ?pack=&actor=&since=&until=&limit=
+//	GET    /v1/events                            event stream, see handleEvents
+//	GET    /openapi.json                         OpenAPI document of all routes
+//
+// Project-qualified pack names are escaped in paths, e.g.
+// /v1/packs/game1%2Fsummer. Errors are StatusError bodies like those of the
+// dot commands, but with more precise HTTP status codes, see restHTTPCode.
+// Created resources are answered with 201 and a Location header.
+
+// v1Operations returns the /v1 routes.
+func (s *Server) v1Operations() []apiOperation {
+	name := apiParam{"name", "path", "string", "pack or project name, escaped"}
+	id := apiParam{"id", "path", "string", "token or webhook id"}
+	keyParam := apiParam{"key", "path", "string", "the key"}
+	op := func(method, path, id string, perm Permission, summary string, req, rsp interface{}, status int, h http.HandlerFunc, params ...apiParam) apiOperation {
+		return apiOperation{Method: method, Path: path, ID: id, Perm: perm, Summary: summary,
+			Request: req, Response: rsp, Status: status, Params: params, Handler: h}
+	}
+
+	return []apiOperation{
+		op("GET", "/v1/packs", "v1.pack.list", PermPackView, "List the packs visible to the token",
+			nil, PackListResponse{}, http.StatusOK, s.v1ListPacks,
+			apiParam{"project", "query", "string", "filters by project, empty is the default project"}),
+		op("POST", "/v1/packs", "v1.pack.add", PermPackAdmin, "Add a pack",
+			PackAddRequest{}, PackInfo{}, http.StatusCreated, s.v1AddPack),
+		op("GET", "/v1/packs/{name}", "v1.pack.get", PermPackView, "Pack info",
+			nil, PackInfo{}, http.StatusOK, s.v1GetPack, name),
+		op("DELETE", "/v1/packs/{name}", "v1.pack.remove", PermPackAdmin, "Remove a pack",
+			nil, nil, http.StatusNoContent, s.v1RemovePack, name),
+		op("POST", "/v1/packs/{name}/enable", "v1.pack.enable", PermPackManage, "Enable a pack",
+			nil, PackInfo{}, http.StatusOK, s.v1EnablePack, name),
+		op("POST", "/v1/packs/{name}/disable", "v1.pack.disable", PermPackManage, "Disable a pack",
+			DisableBody{}, PackInfo{}, http.StatusOK, s.v1DisablePack, name),
+		op("POST", "/v1/packs/{name}/reload", "v1.pack.reload", PermPackManage, "Reload a broken pack",
+			nil, PackInfo{}, http.StatusOK, s.v1ReloadPack, name),
+		op("POST", "/v1/packs/{name}/verify", "v1.pack.verify", PermPackManage, "Verify a pack",
+			nil, VerifyReport{}, http.StatusOK, s.v1VerifyPack, name,
+			apiParam{"repair", "query", "boolean", "repairs the pack"}),
+		op("POST", "/v1/packs/{name}/extend", "v1.pack.extend", PermPackAdmin, "Generate more keys",
+			ExtendBody{}, PackInfo{}, http.StatusOK, s.v1ExtendPack, name),
+		op("PUT", "/v1/packs/{name}/guard", "v1.pack.guard.set", PermPackManage, "Set the GuardConfig",
+			GuardConfig{}, PackInfo{}, http.StatusOK, s.v1SetPackGuard, name),
+		op("DELETE", "/v1/packs/{name}/guard", "v1.pack.guard.reset", PermPackManage, "Restore the default GuardConfig",
+			nil, PackInfo{}, http.StatusOK, s.v1ResetPackGuard, name),
+		op("GET", "/v1/packs/{name}/keys", "v1.key.list", PermPackView, "List keys",
+			nil, KeyListResponse{}, http.StatusOK, s.v1ListKeys, name,
+			apiParam{"status", "query", "string", "filters by status, e.g. Ready"},
+			apiParam{"match", "query", "string", "filters by key prefix"},
+			apiParam{"offset", "query", "integer", "number of matching keys to skip"},
+			apiParam{"limit", "query", "integer", "maximum number of keys"}),
+		op("GET", "/v1/keys/{key}", "v1.key.find", PermPackView, "Search a key in the packs visible to the token",
+			nil, KeyFindResponse{}, http.StatusOK, s.v1FindKey, keyParam),
+		op("GET", "/v1/packs/{name}/keys/{key}", "v1.key.check", PermKeyUse, "Key status",
+			nil, KeyResponse{}, http.StatusOK, s.v1CheckKey, name, keyParam),
+		op("POST", "/v1/packs/{name}/keys/{key}/redeem", "v1.key.redeem", PermKeyUse, "Use a key",
+			nil, KeyUseResponse{}, http.StatusOK, s.v1RedeemKey, name, keyParam),
+		op("POST", "/v1/packs/{name}/keys/{key}/revoke", "v1.key.revoke", PermPackManage, "Revoke a key",
+			nil, KeyResponse{}, http.StatusOK, s.v1RevokeKey, name, keyParam),
+
+		op("GET", "/v1/projects", "v1.project.list", PermPackView, "List the projects visible to the token",
+			nil, ProjectListResponse{}, http.StatusOK, s.v1ListProjects),
+		op("POST", "/v1/projects", "v1.project.add", PermProjectAdmin, "Add a project",
+			ProjectAddRequest{}, ProjectInfo{}, http.StatusCreated, s.v1AddProject),
+		op("GET", "/v1/projects/{name}", "v1.project.get", PermPackView, "Project info",
+			nil, ProjectInfo{}, http.StatusOK, s.v1GetProject, name),
+		op("DELETE", "/v1/projects/{name}", "v1.project.remove", PermProjectAdmin, "Remove an empty project",
+			nil, nil, http.StatusNoContent, s.v1RemoveProject, name),
+		op("PUT", "/v1/projects/{name}/quota", "v1.project.quota", PermProjectAdmin, "Set the quotas",
+			QuotaBody{}, ProjectInfo{}, http.StatusOK, s.v1SetProjectQuota, name),
+		op("GET", "/v1/projects/{name}/packs", "v1.project.packs", PermPackView, "List the packs of a project",
+			nil, PackListResponse{}, http.StatusOK, s.v1ListProjectPacks, name),
+
+		op("GET", "/v1/tokens", "v1.token.list", PermTokenAdmin, "List API tokens",
+			nil, TokenListResponse{}, http.StatusOK, s.v1ListTokens),
+		op("POST", "/v1/tokens", "v1.token.create", PermTokenAdmin, "Create an API token",
+			TokenCreateRequest{}, TokenCreateResponse{}, http.StatusCreated, s.v1CreateToken),
+		op("DELETE", "/v1/tokens/{id}", "v1.token.revoke", PermTokenAdmin, "Revoke an API token",
+			nil, nil, http.StatusNoContent, s.v1RevokeToken, id),
+
+		op("GET", "/v1/webhooks", "v1.webhook.list", PermWebhookAdmin, "List webhooks",
+			nil, WebhookListResponse{}, http.StatusOK, s.v1ListWebhooks),
+		op("POST", "/v1/webhooks", "v1.webhook.add", PermWebhookAdmin, "Add a webhook",
+			Webhook{}, Webhook{}, http.StatusCreated, s.v1AddWebhook),
+		op("DELETE", "/v1/webhooks/{id}", "v1.webhook.remove", PermWebhookAdmin, "Remove a webhook",
+			nil, nil, http.StatusNoContent, s.v1RemoveWebhook, id),
+		op("GET", "/v1/webhooks/{id}/deliveries", "v1.webhook.deliveries", PermWebhookAdmin, "Recent deliveries",
+			nil, WebhookDeliveriesResponse{}, http.StatusOK, s.v1WebhookDeliveries, id,
+			apiParam{"limit", "query", "integer", "maximum number of deliveries"}),
+
+		op("GET", "/v1/audit", "v1.audit.query", PermAuditView, "Query the audit log",
+			nil, AuditQueryResponse{}, http.StatusOK, s.v1QueryAudit,
+			apiParam{"pack", "query", "string", "filters by pack"},
+			apiParam{"actor", "query", "string", "filters by actor"},
+			apiParam{"since", "query", "string", "RFC 3339 time"},
+			apiParam{"until", "query", "string", "RFC 3339 time"},
+			apiParam{"limit", "query", "integer", "maximum number of records"}),
+	}
+}
+
+// restHTTPCode returns the HTTP status of e in the /v1 API. The dot commands
+// keep the codes of StatusError for compatibility.
+func restHTTPCode(e *StatusError) int {
+	switch {
+	case errors.Is(e, ErrPackAlreadyExists), errors.Is(e, ErrProjectAlreadyExists),
+		errors.Is(e, ErrPackDisabled), errors.Is(e, ErrKeyUsed),
+		errors.Is(e, ErrKeyReserved), errors.Is(e, ErrKeyNotReserved), errors.Is(e, ErrKeyRevoked),
+		errors.Is(e, ErrProjectNotEmpty), errors.Is(e, ErrPrefixConflict),
+		errors.Is(e, ErrQuotaExceeded):
+		return http.StatusConflict
+	case errors.Is(e, ErrInvalidPackName), errors.Is(e, ErrInvalidPrefix),
+		errors.Is(e, ErrKeylenTooShort), errors.Is(e, ErrInvalidProjectName):
+		return http.StatusUnprocessableEntity
+	}
+	return e.HTTPCode()
+}
+
+func putRestError(w http.ResponseWriter, cmd string, err error) {
+	var e *StatusError
+	if !errors.As(err, &e) {
+		e = ErrInternal.affix(err)
+	}
+	e = e.withCmd(cmd)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(restHTTPCode(e))
+	w.Write([]byte(e.Json()))
+}
+
+func putRestJson(w http.ResponseWriter, code int, v interface{}) {
+	rsp, _ := json.Marshal(v)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(rsp)
+}
+
+// readOptionalJsonRequest is like readJsonRequest, but accepts an empty body.
+func readOptionalJsonRequest(r *http.Request, v interface{}) error {
+	if r.ContentLength == 0 {
+		return nil
+	}
+	return readJsonRequest(r, v)
+}
+
+// putPackInfo answers a pack command with the current PackInfo.
+func (s *Server) putPackInfo(w http.ResponseWriter, cmd, name string) {
+	info, err := s.GetPackInfo(name)
+	if err != nil {
+		putRestError(w, cmd, err)
+		return
+	}
+	putRestJson(w, http.StatusOK, info)
+}
+
+func (s *Server) v1ListPacks(w http.ResponseWriter, r *http.Request) {
+	all := s.ListPacks()
+	if r.URL.Query().Has("project") {
+		all = s.ListProjectPacks(r.URL.Query().Get("project"))
+	}
+
+	packs := []PackInfo{}
+	for _, p := range all {
+		if s.Authorize(r.Context(), PermPackView, p.Name) == nil {
+			packs = append(packs, p)
+		}
+	}
+
+	putRestJson(w, http.StatusOK, PackListResponse{
+		Packs: packs,
+	})
+}
+
+func (s *Server) v1AddPack(w http.ResponseWriter, r *http.Request) {
+	req := PackAddRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putRestError(w, "pack.add", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermPackAdmin, req.Name); err != nil {
+		putRestError(w, "pack.add", err)
+		return
+	}
+
+	if err := s.AddPackContext(r.Context(), req.Name, req.Prefix, req.KeyLen, req.PackSize, req.Note); err != nil {
+		putRestError(w, "pack.add", err)
+		return
+	}
+
+	info, err := s.GetPackInfo(req.Name)
+	if err != nil {
+		putRestError(w, "pack.add", err)
+		return
+	}
+
+	w.Header().Set("Location", "/v1/packs/"+url.PathEscape(req.Name))
+	putRestJson(w, http.StatusCreated, info)
+}
+
+func (s *Server) v1GetPack(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if err := s.Authorize(r.Context(), PermPackView, name); err != nil {
+		putRestError(w, "pack.info", err)
+		return
+	}
+
+	s.putPackInfo(w, "pack.info", name)
+}
+
+func (s *Server) v1RemovePack(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if err := s.Authorize(r.Context(), PermPackAdmin, name); err != nil {
+		putRestError(w, "pack.remove", err)
+		return
+	}
+
+	if err := s.RemovePackContext(r.Context(), name); err != nil {
+		putRestError(w, "pack.remove", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) v1EnablePack(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if err := s.Authorize(r.Context(), PermPackManage, name); err != nil {
+		putRestError(w, "pack.enable", err)
+		return
+	}
+
+	if err := s.EnablePackContext(r.Context(), name); err != nil {
+		putRestError(w, "pack.enable", err)
+		return
+	}
+
+	s.putPackInfo(w, "pack.enable", name)
+}
+
+func (s *Server) v1DisablePack(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	req := DisableBody{}
+
+	if err := readOptionalJsonRequest(r, &req); err != nil {
+		putRestError(w, "pack.disable", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermPackManage, name); err != nil {
+		putRestError(w, "pack.disable", err)
+		return
+	}
+
+	if err := s.DisablePackContext(r.Context(), name, req.Msg); err != nil {
+		putRestError(w, "pack.disable", err)
+		return
+	}
+
+	s.putPackInfo(w, "pack.disable", name)
+}
+
+func (s *Server) v1ReloadPack(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if err := s.Authorize(r.Context(), PermPackManage, name); err != nil {
+		putRestError(w, "pack.reload", err)
+		return
+	}
+
+	if err := s.ReloadPackContext(r.Context(), name); err != nil {
+		putRestError(w, "pack.reload", err)
+		return
+	}
+
+	s.putPackInfo(w, "pack.reload", name)
+}
+
+func (s *Server) v1VerifyPack(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	repair := r.URL.Query().Get("repair") == "true"
+
+	if err := s.Authorize(r.Context(), PermPackManage, name); err != nil {
+		putRestError(w, "pack.verify", err)
+		return
+	}
+
+	report, err := s.VerifyPackContext(r.Context(), name, repair)
+	if err != nil {
+		putRestError(w, "pack.verify", err)
+		return
+	}
+
+	putRestJson(w, http.StatusOK, report)
+}
+
+func (s *Server) v1ExtendPack(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	req := ExtendBody{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putRestError(w, "pack.extend", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermPackAdmin, name); err != nil {
+		putRestError(w, "pack.extend", err)
+		return
+	}
+
+	if err := s.ExtendPackContext(r.Context(), name, req.Count); err != nil {
+		putRestError(w, "pack.extend", err)
+		return
+	}
+
+	s.putPackInfo(w, "pack.extend", name)
+}
+
+func (s *Server) v1SetPackGuard(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var cfg GuardConfig
+
+	if err := readJsonRequest(r, &cfg); err != nil {
+		putRestError(w, "pack.guard", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermPackManage, name); err != nil {
+		putRestError(w, "pack.guard", err)
+		return
+	}
+
+	if err := s.SetPackGuardContext(r.Context(), name, &cfg); err != nil {
+		putRestError(w, "pack.guard", err)
+		return
+	}
+
+	s.putPackInfo(w, "pack.guard", name)
+}
+
+func (s *Server) v1ResetPackGuard(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if err := s.Authorize(r.Context(), PermPackManage, name); err != nil {
+		putRestError(w, "pack.guard", err)
+		return
+	}
+
+	if err := s.SetPackGuardContext(r.Context(), name, nil); err != nil {
+		putRestError(w, "pack.guard", err)
+		return
+	}
+
+	s.putPackInfo(w, "pack.guard", name)
+}
+
+func (s *Server) v1ListKeys(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	query := r.URL.Query()
+	q := KeyQuery{
+		Status: query.Get("status"),
+		Match:  query.Get("match"),
+	}
+
+	for param, n := range map[string]*int{"offset": &q.Offset, "limit": &q.Limit} {
+		if v := query.Get(param); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil {
+				putRestError(w, "key.list", ErrBadRequest.affix(fmt.Sprintf("%v:%v", param, v)))
+				return
+			}
+			*n = parsed
+		}
+	}
+
+	if err := s.Authorize(r.Context(), PermPackView, name); err != nil {
+		putRestError(w, "key.list", err)
+		return
+	}
+
+	keys, total, err := s.QueryKeysContext(r.Context(), name, q)
+	if err != nil {
+		putRestError(w, "key.list", err)
+		return
+	}
+
+	putRestJson(w, http.StatusOK, KeyListResponse{
+		Pack:  name,
+		Keys:  keys,
+		Total: total,
+	})
+}
+
+func (s *Server) v1FindKey(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	if err := s.authorizeAnyPack(r.Context(), PermPackView); err != nil {
+		putRestError(w, "key.find", err)
+		return
+	}
+
+	all, err := s.FindKeyContext(r.Context(), key)
+	if err != nil {
+		putRestError(w, "key.find", err)
+		return
+	}
+
+	matches := []KeyMatch{}
+	for _, m := range all {
+		if s.Authorize(r.Context(), PermPackView, m.Pack) == nil {
+			matches = append(matches, m)
+		}
+	}
+
+	putRestJson(w, http.StatusOK, KeyFindResponse{
+		Key:     key,
+		Matches: matches,
+	})
+}
+
+func (s *Server) v1RedeemKey(w http.ResponseWriter, r *http.Request) {
+	name, key := r.PathValue("name"), r.PathValue("key")
+
+	if err := s.Authorize(r.Context(), PermKeyUse, name); err != nil {
+		putRestError(w, "key.use", err)
+		return
+	}
+
+	if err := s.UseKeyContext(r.Context(), name, key); err != nil {
+		putRestError(w, "key.use", err)
+		return
+	}
+
+	normalKey, _ := NormalizeKey(key)
+	putRestJson(w, http.StatusOK, KeyUseResponse{
+		Pack: name,
+		Key:  normalKey,
+	})
+}
+
+func (s *Server) v1CheckKey(w http.ResponseWriter, r *http.Request) {
+	name, key := r.PathValue("name"), r.PathValue("key")
+
+	if err := s.Authorize(r.Context(), PermKeyUse, name); err != nil {
+		putRestError(w, "key.check", err)
+		return
+	}
+
+	info, err := s.CheckKeyContext(r.Context(), name, key)
+	if err != nil {
+		putRestError(w, "key.check", err)
+		return
+	}
+
+	putRestJson(w, http.StatusOK, KeyResponse{
+		Pack:   name,
+		Key:    info.Key,
+		Status: info.Status,
+	})
+}
+
+func (s *Server) v1RevokeKey(w http.ResponseWriter, r *http.Request) {
+	name, key := r.PathValue("name"), r.PathValue("key")
+
+	if err := s.Authorize(r.Context(), PermPackManage, name); err != nil {
+		putRestError(w, "key.revoke", err)
+		return
+	}
+
+	if err := s.RevokeKeyContext(r.Context(), name, key); err != nil {
+		putRestError(w, "key.revoke", err)
+		return
+	}
+
+	normalKey, _ := NormalizeKey(key)
+	putRestJson(w, http.StatusOK, KeyResponse{
+		Pack:   name,
+		Key:    normalKey,
+		Status: keyRevoked.String(),
+	})
+}
+
+func (s *Server) v1ListProjects(w http.ResponseWriter, r *http.Request) {
+	projects := []ProjectInfo{}
+	for _, p := range s.ListProjects() {
+		if s.authorizeProject(r.Context(), p.Name) == nil {
+			projects = append(projects, p)
+		}
+	}
+
+	putRestJson(w, http.StatusOK, ProjectListResponse{
+		Projects: projects,
+	})
+}
+
+func (s *Server) v1AddProject(w http.ResponseWriter, r *http.Request) {
+	req := ProjectAddRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putRestError(w, "project.add", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermProjectAdmin, ""); err != nil {
+		putRestError(w, "project.add", err)
+		return
+	}
+
+	if err := s.AddProjectContext(r.Context(), req.Name, req.MaxPacks, req.MaxKeys, req.Note); err != nil {
+		putRestError(w, "project.add", err)
+		return
+	}
+
+	info, _ := s.GetProject(req.Name)
+	w.Header().Set("Location", "/v1/projects/"+url.PathEscape(req.Name))
+	putRestJson(w, http.StatusCreated, info)
+}
+
+func (s *Server) v1GetProject(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if err := s.authorizeProject(r.Context(), name); err != nil {
+		putRestError(w, "project.info", err)
+		return
+	}
+
+	info, err := s.GetProject(name)
+	if err != nil {
+		putRestError(w, "project.info", err)
+		return
+	}
+
+	putRestJson(w, http.StatusOK, info)
+}
+
+func (s *Server) v1RemoveProject(w http.ResponseWriter, r *http.Request) {
+	if err := s.Authorize(r.Context(), PermProjectAdmin, ""); err != nil {
+		putRestError(w, "project.remove", err)
+		return
+	}
+
+	if err := s.RemoveProjectContext(r.Context(), r.PathValue("name")); err != nil {
+		putRestError(w, "project.remove", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) v1SetProjectQuota(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	req := QuotaBody{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putRestError(w, "project.quota", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermProjectAdmin, ""); err != nil {
+		putRestError(w, "project.quota", err)
+		return
+	}
+
+	if err := s.SetProjectQuotaContext(r.Context(), name, req.MaxPacks, req.MaxKeys); err != nil {
+		putRestError(w, "project.quota", err)
+		return
+	}
+
+	info, _ := s.GetProject(name)
+	putRestJson(w, http.StatusOK, info)
+}
+
+func (s *Server) v1ListProjectPacks(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if _, err := s.GetProject(name); err != nil {
+		putRestError(w, "pack.list", err)
+		return
+	}
+
+	packs := []PackInfo{}
+	for _, p := range s.ListProjectPacks(name) {
+		if s.Authorize(r.Context(), PermPackView, p.Name) == nil {
+			packs = append(packs, p)
+		}
+	}
+
+	putRestJson(w, http.StatusOK, PackListResponse{
+		Packs: packs,
+	})
+}
+
+func (s *Server) v1ListTokens(w http.ResponseWriter, r *http.Request) {
+	if err := s.Authorize(r.Context(), PermTokenAdmin, ""); err != nil {
+		putRestError(w, "token.list", err)
+		return
+	}
+
+	putRestJson(w, http.StatusOK, TokenListResponse{
+		Tokens: s.ListTokens(),
+	})
+}
+
+func (s *Server) v1CreateToken(w http.ResponseWriter, r *http.Request) {
+	req := TokenCreateRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putRestError(w, "token.create", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermTokenAdmin, ""); err != nil {
+		putRestError(w, "token.create", err)
+		return
+	}
+
+	secret, info, err := s.CreateTokenContext(r.Context(), req.Name, req.Bindings)
+	if err != nil {
+		putRestError(w, "token.create", err)
+		return
+	}
+
+	w.Header().Set("Location", "/v1/tokens/"+url.PathEscape(info.ID))
+	putRestJson(w, http.StatusCreated, TokenCreateResponse{
+		Token:     secret,
+		TokenInfo: info,
+	})
+}
+
+func (s *Server) v1RevokeToken(w http.ResponseWriter, r *http.Request) {
+	if err := s.Authorize(r.Context(), PermTokenAdmin, ""); err != nil {
+		putRestError(w, "token.revoke", err)
+		return
+	}
+
+	if err := s.RevokeTokenContext(r.Context(), r.PathValue("id")); err != nil {
+		putRestError(w, "token.revoke", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) v1ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if err := s.Authorize(r.Context(), PermWebhookAdmin, ""); err != nil {
+		putRestError(w, "webhook.list", err)
+		return
+	}
+
+	putRestJson(w, http.StatusOK, WebhookListResponse{
+		Webhooks: s.ListWebhooks(),
+	})
+}
+
+func (s *Server) v1AddWebhook(w http.ResponseWriter, r *http.Request) {
+	var req Webhook
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putRestError(w, "webhook.add", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermWebhookAdmin, ""); err != nil {
+		putRestError(w, "webhook.add", err)
+		return
+	}
+
+	hook, err := s.AddWebhookContext(r.Context(), req)
+	if err != nil {
+		putRestError(w, "webhook.add", err)
+		return
+	}
+
+	w.Header().Set("Location", "/v1/webhooks/"+url.PathEscape(hook.ID))
+	putRestJson(w, http.StatusCreated, hook)
+}
+
+func (s *Server) v1RemoveWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := s.Authorize(r.Context(), PermWebhookAdmin, ""); err != nil {
+		putRestError(w, "webhook.remove", err)
+		return
+	}
+
+	if err := s.RemoveWebhookContext(r.Context(), r.PathValue("id")); err != nil {
+		putRestError(w, "webhook.remove", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) v1WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			putRestError(w, "webhook.deliveries", ErrBadRequest.affix(fmt.Sprintf("limit:%v", v)))
+			return
+		}
+		limit = n
+	}
+
+	if err := s.Authorize(r.Context(), PermWebhookAdmin, ""); err != nil {
+		putRestError(w, "webhook.deliveries", err)
+		return
+	}
+
+	deliveries, err := s.WebhookDeliveries(r.PathValue("id"), limit)
+	if err != nil {
+		putRestError(w, "webhook.deliveries", err)
+		return
+	}
+
+	putRestJson(w, http.StatusOK, WebhookDeliveriesResponse{
+		Deliveries: deliveries,
+	})
+}
+
+func (s *Server) v1QueryAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := AuditQuery{
+		Pack:  query.Get("pack"),
+		Actor: query.Get("actor"),
+	}
+
+	for param, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := query.Get(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				putRestError(w, "audit.query", ErrBadRequest.affix(err))
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			putRestError(w, "audit.query", ErrBadRequest.affix(fmt.Sprintf("limit:%v", v)))
+			return
+		}
+		q.Limit = n
+	}
+
+	if err := s.Authorize(r.Context(), PermAuditView, ""); err != nil {
+		putRestError(w, "audit.query", err)
+		return
+	}
+
+	records, err := s.QueryAudit(q)
+	if err != nil {
+		putRestError(w, "audit.query", err)
+		return
+	}
+
+	putRestJson(w, http.StatusOK, AuditQueryResponse{
+		Records: records,
+	})
+}
@@ -1,394 +1,1670 @@
 package cdkey
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 )
 
 type Server struct {
-	path  string
-	packs map[string]*Pack
+	path     string
+	opts     Options
+	packs    map[string]*Pack
+	broken   map[string]error
+	projects map[string]ProjectInfo
+	pending  map[string]int // keys of running extensions per project
+	tokens   *tokenStore
+	guard    *guard
+	auditLog *auditLog
+	webhooks *webhookManager
+	hub      *hub
+	metrics  *metrics
+	life     lifecycle
+	ready    readyCache
+	log      libLogger
 
 	mtx sync.RWMutex
 }
 
+// NewServer creates a Server with default options. It returns nil on failure,
+// use NewServerWithOptions to get the reason.
 func NewServer(path string) *Server {
-	info_logf("new CDKeyServer (path:%v)", path)
+	s, err := NewServerWithOptions(path, Options{})
+	if err != nil {
+		return nil
+	}
+	return s
+}
+
+// NewServerWithOptions creates a Server storing packs under path, creating the
+// directory if it does not exist, and loads all existing packs.
+func NewServerWithOptions(path string, opts Options) (*Server, error) {
+	opts = opts.withDefaults()
+	log := opts.libLogger()
+
+	log.info_log("new CDKeyServer", "path", path)
+
+	metrics := newMetrics()
+	opts.Storage = timedStorage{opts.Storage, metrics}
+
+	if f, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			log.info_log("path not exists, try to create", "path", path)
+
+			if err := os.MkdirAll(path, opts.DirPerm); err != nil {
+				log.error_log("failed mkdir", "path", path, "err", err)
+				return nil, ErrFailedCreateDataDir.affix(err)
+			}
+
+			log.info_log("created path", "path", path)
+		} else {
+			log.error_log("failed access path", "path", path, "err", err)
+			return nil, ErrFailedAccessDataDir.affix(err)
+		}
+	} else {
+		if !f.IsDir() {
+			log.error_log("path is not a directory", "path", path)
+			return nil, ErrFailedAccessDataDir.affix(fmt.Sprintf("%v is not a directory", path))
+		}
+	}
+
+	s := &Server{
+		path:     path,
+		opts:     opts,
+		packs:    make(map[string]*Pack),
+		broken:   make(map[string]error),
+		projects: make(map[string]ProjectInfo),
+		pending:  make(map[string]int),
+		hub:      newHub(opts.Clock, log),
+		metrics:  metrics,
+		log:      log,
+	}
+
+	tokens, err := loadTokenStore(path, log)
+	if err != nil {
+		return nil, err
+	}
+	if err := tokens.addStatic(opts.Tokens, opts.Clock.Now()); err != nil {
+		return nil, err
+	}
+	if err := tokens.addCerts(opts.ClientCerts, opts.Clock.Now()); err != nil {
+		return nil, err
+	}
+	s.tokens = tokens
+
+	auditLog, err := openAuditLog(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.auditLog = auditLog
+
+	webhooks, err := openWebhookManager(path, opts)
+	if err != nil {
+		auditLog.close()
+		return nil, err
+	}
+	s.webhooks = webhooks
+	s.hub.listen(webhooks.enqueue)
+
+	s.guard = newGuard(opts.Clock, log)
+
+	if err := s.loadPacks(); err != nil {
+		webhooks.close()
+		auditLog.close()
+		s.guard.close()
+		return nil, ErrFailedAccessDataDir.affix(err)
+	}
+
+	return s, nil
+}
+
+func (s *Server) loadPacks() error {
+	if err := s.loadDir(""); err != nil {
+		return err
+	}
+
+	if len(s.broken) > 0 {
+		s.log.error_log("packs failed to load", "count", len(s.broken))
+	}
+
+	return nil
+}
+
+// loadDir loads the packs of a project. For the default project it also loads
+// the project directories.
+func (s *Server) loadDir(project string) error {
+	dir := filepath.Join(s.path, project)
+
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		s.log.error_log("failed read dir", "path", dir, "err", err)
+		return err
+	}
+
+	for _, f := range fs {
+		if !f.IsDir() {
+			continue
+		}
+
+		name := qualifyPackName(project, f.Name())
+		packPath := filepath.Join(dir, f.Name())
+
+		if project == "" && fileExists(filepath.Join(packPath, "project.json")) {
+			if err := s.loadProject(f.Name()); err != nil {
+				s.log.error_log("failed load project", "name", f.Name(), "err", err)
+				s.broken[f.Name()] = err
+			}
+			continue
+		}
+
+		if info, err := os.Stat(filepath.Join(packPath, "pack.json")); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			s.log.error_log("failed access pack.json", "path", packPath, "err", err)
+			s.broken[name] = ErrFailedLoadPackInfo.affix(err)
+			continue
+		} else if info.IsDir() {
+			continue
+		}
+
+		if p, err := loadPack(s.opts, packPath); err != nil {
+			s.broken[name] = err
+		} else {
+			p.Name = qualifyPackName(project, p.Name)
+			p.events = s.hub
+			s.packs[p.Name] = p
+		}
+	}
+
+	return nil
+}
+
+// loadProject loads the info and the packs of a project directory. A project
+// which fails to load is recorded in s.broken under its name, like a pack,
+// so that it is listed and can be reloaded with ReloadPack.
+func (s *Server) loadProject(name string) error {
+	info, err := s.loadProjectInfo(filepath.Join(s.path, name))
+	if err != nil {
+		return err
+	}
+
+	if err := s.loadDir(name); err != nil {
+		return ErrFailedAccessDataDir.affix(err)
+	}
+	s.projects[name] = info
+	return nil
+}
+
+// brokenProject reports whether the broken entry name is a project.
+func (s *Server) brokenProject(name string) bool {
+	return !strings.Contains(name, "/") && fileExists(filepath.Join(s.path, name, "project.json"))
+}
+
+func fileExists(path string) bool {
+	f, err := os.Stat(path)
+	return err == nil && !f.IsDir()
+}
+
+func (s *Server) ListPacks() []PackInfo {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var packs []PackInfo
+	for _, p := range s.packs {
+		packs = append(packs, p.Info())
+	}
+	for name, err := range s.broken {
+		project, _ := splitPackName(name)
+		packs = append(packs, PackInfo{
+			Name:    name,
+			Status:  packStatus("broken"),
+			Project: project,
+			Error:   err.Error(),
+		})
+	}
+	return packs
+}
+
+// GetPackInfo returns the PackInfo of a loaded or broken pack.
+func (s *Server) GetPackInfo(name string) (PackInfo, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if err, ok := s.broken[name]; ok {
+		project, _ := splitPackName(name)
+		return PackInfo{
+			Name:    name,
+			Status:  packStatus("broken"),
+			Project: project,
+			Error:   err.Error(),
+		}, nil
+	}
+
+	p, err := s.lookupPack(name)
+	if err != nil {
+		return PackInfo{}, err
+	}
+	return p.Info(), nil
+}
+
+// lookupPack returns the loaded pack with the given name. The caller must hold
+// s.mtx.
+func (s *Server) lookupPack(name string) (*Pack, error) {
+	if p, ok := s.packs[name]; ok {
+		return p, nil
+	}
+
+	if err, ok := s.broken[name]; ok {
+		s.log.warn_log("pack is broken", "name", name, "err", err)
+		return nil, ErrPackBroken.affix(fmt.Sprintf("name:%v, err:%v", name, err))
+	}
+
+	s.log.warn_log("pack not found", "name", name)
+	return nil, ErrPackNotFound.affix(fmt.Sprintf("name:%v", name))
+}
+
+func (s *Server) AddPack(name string, prefix string, keylen, packsize int, note string) error {
+	return s.AddPackContext(context.Background(), name, prefix, keylen, packsize, note)
+}
+
+// AddPackContext is like AddPack, but stops generating keys and returns
+// ErrCanceled once ctx is done.
+func (s *Server) AddPackContext(ctx context.Context, name string, prefix string, keylen, packsize int, note string) (err error) {
+	defer func() { s.audit(ctx, "pack.add", name, "", err) }()
+
+	if err := s.begin(false); err != nil {
+		return err
+	}
+	defer s.end()
+
+	if !validPackName(name) {
+		s.log.warn_logc(ctx, "invalid pack name", "name", name)
+		return ErrInvalidPackName.affix(fmt.Sprintf("name:%v", name))
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	_, loaded := s.packs[name]
+	_, broken := s.broken[name]
+	if loaded || broken {
+		s.log.warn_logc(ctx, "pack already exists", "name", name)
+		return ErrPackAlreadyExists.affix(fmt.Sprintf("name:%v", name))
+	}
+
+	if _, ok := s.projects[name]; ok {
+		s.log.warn_logc(ctx, "pack name is used by a project", "name", name)
+		return ErrPackAlreadyExists.affix(fmt.Sprintf("name:%v, used by a project", name))
+	}
+
+	if err := s.checkProjectLimits(name, prefix, packsize); err != nil {
+		return err
+	}
+
+	dir, err := s.packDir(name)
+	if err != nil {
+		s.log.warn_logc(ctx, "invalid pack name", "name", name)
+		return err
+	}
+
+	_, packName := splitPackName(name)
+	p, err := createPack(ctx, s.opts, filepath.Dir(dir), packName, prefix, keylen, packsize, note)
+	if err != nil {
+		return err
+	}
+
+	p.Name = name
+	p.events = s.hub
+	s.packs[name] = p
+
+	s.hub.publish(p.event(EventPackAdded, ""))
+	return nil
+}
+
+func (s *Server) RemovePack(name string) error {
+	return s.RemovePackContext(context.Background(), name)
+}
+
+func (s *Server) RemovePackContext(ctx context.Context, name string) (err error) {
+	defer func() { s.audit(ctx, "pack.remove", name, "", err) }()
+
+	if err := s.begin(false); err != nil {
+		return err
+	}
+	defer s.end()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.broken[name]; ok {
+		if s.brokenProject(name) {
+			s.log.warn_logc(ctx, "broken project can not be removed as a pack", "name", name)
+			return ErrBadRequest.affix(fmt.Sprintf("name:%v is a project", name))
+		}
+
+		dir, err := s.packDir(name)
+		if err != nil {
+			s.log.warn_logc(ctx, "invalid pack name", "name", name)
+			return err
+		}
+
+		os.RemoveAll(dir)
+		delete(s.broken, name)
+
+		s.log.info_logc(ctx, "broken pack removed", "name", name)
+		return nil
+	}
+
+	if p, ok := s.packs[name]; !ok {
+		s.log.warn_logc(ctx, "pack not found", "name", name)
+		return ErrPackNotFound.affix(fmt.Sprintf("name:%v", name))
+	} else {
+		dir, err := s.packDir(name)
+		if err != nil {
+			s.log.warn_logc(ctx, "invalid pack name", "name", name)
+			return err
+		}
+
+		e := p.event(EventPackRemoved, "")
+
+		p.Close()
+		os.RemoveAll(dir)
+		delete(s.packs, name)
+
+		s.log.info_logc(ctx, "pack removed", "name", name)
+		s.hub.publish(e)
+		return nil
+	}
+}
+
+// ReloadPack closes and loads a pack from the file system again. It is
+// typically used to retry a broken pack after the underlying problem is fixed.
+func (s *Server) ReloadPack(name string) error {
+	return s.ReloadPackContext(context.Background(), name)
+}
+
+func (s *Server) ReloadPackContext(ctx context.Context, name string) (err error) {
+	defer func() { s.audit(ctx, "pack.reload", name, "", err) }()
+
+	if err := s.begin(false); err != nil {
+		return err
+	}
+	defer s.end()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if p, ok := s.packs[name]; ok {
+		p.Close()
+		delete(s.packs, name)
+	} else if _, ok := s.broken[name]; !ok {
+		s.log.warn_logc(ctx, "pack not found", "name", name)
+		return ErrPackNotFound.affix(fmt.Sprintf("name:%v", name))
+	} else if s.brokenProject(name) {
+		if err := s.loadProject(name); err != nil {
+			s.broken[name] = err
+			return err
+		}
+
+		delete(s.broken, name)
+		s.log.info_logc(ctx, "project reloaded", "name", name)
+		return nil
+	}
+
+	dir, err := s.packDir(name)
+	if err != nil {
+		return err
+	}
+
+	p, err := loadPack(s.opts, dir)
+	if err != nil {
+		s.broken[name] = err
+		return err
+	}
+
+	delete(s.broken, name)
+	p.Name = name
+	p.events = s.hub
+	s.packs[name] = p
+
+	s.log.info_logc(ctx, "pack reloaded", "name", name)
+	return nil
+}
+
+func (s *Server) EnablePack(name string) error {
+	return s.EnablePackContext(context.Background(), name)
+}
+
+func (s *Server) EnablePackContext(ctx context.Context, name string) (err error) {
+	defer func() { s.audit(ctx, "pack.enable", name, "", err) }()
+
+	if err := s.begin(false); err != nil {
+		return err
+	}
+	defer s.end()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	p, err := s.lookupPack(name)
+	if err != nil {
+		return err
+	}
+
+	return p.Enable()
+}
+
+func (s *Server) DisablePack(name string, msg string) error {
+	return s.DisablePackContext(context.Background(), name, msg)
+}
+
+func (s *Server) DisablePackContext(ctx context.Context, name string, msg string) (err error) {
+	defer func() { s.audit(ctx, "pack.disable", name, "", err) }()
+
+	if err := s.begin(false); err != nil {
+		return err
+	}
+	defer s.end()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	p, err := s.lookupPack(name)
+	if err != nil {
+		return err
+	}
+
+	return p.Disable(msg)
+}
+
+func (s *Server) VerifyPack(name string, repair bool) (VerifyReport, error) {
+	return s.VerifyPackContext(context.Background(), name, repair)
+}
+
+func (s *Server) VerifyPackContext(ctx context.Context, name string, repair bool) (report VerifyReport, err error) {
+	if repair {
+		defer func() { s.audit(ctx, "pack.repair", name, "", err) }()
+	}
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	p, err := s.lookupPack(name)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	return p.VerifyContext(ctx, repair)
+}
+
+// ExtendPack generates count more keys for the named pack, see Pack.Extend.
+func (s *Server) ExtendPack(name string, count int) error {
+	return s.ExtendPackContext(context.Background(), name, count)
+}
+
+// ExtendPackContext is like ExtendPack, but stops generating keys and returns
+// ErrCanceled once ctx is done.
+func (s *Server) ExtendPackContext(ctx context.Context, name string, count int) (err error) {
+	defer func() { s.audit(ctx, "pack.extend", name, "", err) }()
+
+	if err := s.begin(false); err != nil {
+		return err
+	}
+	defer s.end()
+
+	// The generation runs without s.mtx, so that it does not block the other
+	// packs. The keys are reserved in the project quota meanwhile, so that
+	// concurrent extensions and new packs can not exceed it together. A pack
+	// removed or reloaded meanwhile fails the extension with ErrPackClosing.
+	project, _ := splitPackName(name)
+
+	s.mtx.Lock()
+	p, err := s.lookupPack(name)
+	if err == nil {
+		err = s.checkProjectKeys(name, count)
+	}
+	if err == nil {
+		s.pending[project] += count
+	}
+	s.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		s.mtx.Lock()
+		if s.pending[project] -= count; s.pending[project] == 0 {
+			delete(s.pending, project)
+		}
+		s.mtx.Unlock()
+	}()
+
+	return p.ExtendContext(ctx, count)
+}
+
+func (s *Server) ListKeys(packName string) ([]KeyInfo, error) {
+	return s.ListKeysContext(context.Background(), packName)
+}
+
+func (s *Server) ListKeysContext(ctx context.Context, packName string) ([]KeyInfo, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	p, err := s.lookupPack(packName)
+	if err != nil {
+		return nil, err
+	}
+	return p.ListKeysContext(ctx)
+}
+
+// QueryKeys returns a page of the keys of the named pack and the number of
+// all keys matching q, see Pack.QueryKeys.
+func (s *Server) QueryKeys(packName string, q KeyQuery) ([]KeyInfo, int, error) {
+	return s.QueryKeysContext(context.Background(), packName, q)
+}
+
+func (s *Server) QueryKeysContext(ctx context.Context, packName string, q KeyQuery) ([]KeyInfo, int, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	p, err := s.lookupPack(packName)
+	if err != nil {
+		return nil, 0, err
+	}
+	return p.QueryKeysContext(ctx, q)
+}
+
+// KeyMatch is a pack holding a key searched by FindKey.
+type KeyMatch struct {
+	Pack   string `json:"pack"`
+	Status string `json:"status"`
+}
+
+// findGuardPack is the guard entry of FindKey, which is no valid pack name.
+const findGuardPack = "*find*"
+
+// FindKey searches a key in all packs whose prefix and key length fit the
+// key. Like CheckKey it is guarded against brute-force, with the default
+// GuardConfig; a search without matches counts as a failure.
+func (s *Server) FindKey(key string) ([]KeyMatch, error) {
+	return s.FindKeyContext(context.Background(), key)
+}
+
+func (s *Server) FindKeyContext(ctx context.Context, key string) (matches []KeyMatch, err error) {
+	normalized, ok := NormalizeKey(key)
+	if !ok {
+		return nil, ErrBadRequest.affix(fmt.Sprintf("key:%v", key))
+	}
+	key = normalized
+
+	cfg := DefaultGuardConfig
+	if s.opts.Guard != nil {
+		cfg = *s.opts.Guard
+	}
+	sources := guardSources(ctx)
+	if !cfg.Disabled {
+		if err := s.guard.allow(findGuardPack, cfg, sources, s.opts.Clock.Now()); err != nil {
+			return nil, err
+		}
+		defer func() {
+			res := err
+			if res == nil && len(matches) == 0 {
+				res = ErrKeyNotFound
+			}
+			s.guard.record(findGuardPack, cfg, sources, res, s.opts.Clock.Now())
+		}()
+	}
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	for _, p := range s.packs {
+		info := p.Info()
+		if info.KeyLen != len(key) || !strings.HasPrefix(key, info.Prefix) {
+			continue
+		}
+
+		k, err := p.CheckKeyContext(ctx, key)
+		if err != nil {
+			if errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		matches = append(matches, KeyMatch{Pack: p.Name, Status: k.Status})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Pack < matches[j].Pack
+	})
+	return matches, nil
+}
+
+func (s *Server) UseKey(packName, key string) error {
+	return s.UseKeyContext(context.Background(), packName, key)
+}
+
+// UseKeyContext is like UseKey. The redemption is guarded against brute-force
+// per client address and API token, as set by WithClientAddr and AuthHandler,
+// see GuardConfig.
+func (s *Server) UseKeyContext(ctx context.Context, packName, key string) (err error) {
+	ctx = WithLogFields(ctx, "pack", packName, "key", key)
+	defer func() { s.audit(ctx, "key.use", packName, key, err) }()
+
+	metricPack := ""
+	defer func() { s.metrics.observeKeyUse(metricPack, err) }()
+
+	return s.guardKey(ctx, packName, func(p *Pack) error {
+		metricPack = p.Name
+		return p.UseKeyContext(ctx, key)
+	})
+}
+
+// ReserveKey reserves a key of the named pack, see Pack.ReserveKey.
+func (s *Server) ReserveKey(packName, key string) error {
+	return s.ReserveKeyContext(context.Background(), packName, key)
+}
+
+// ReserveKeyContext is like ReserveKey. It is guarded like UseKeyContext.
+func (s *Server) ReserveKeyContext(ctx context.Context, packName, key string) (err error) {
+	ctx = WithLogFields(ctx, "pack", packName, "key", key)
+	defer func() { s.audit(ctx, "key.reserve", packName, key, err) }()
+
+	return s.guardKey(ctx, packName, func(p *Pack) error {
+		return p.ReserveKeyContext(ctx, key)
+	})
+}
+
+// ReleaseKey releases a reserved key of the named pack, see Pack.ReleaseKey.
+func (s *Server) ReleaseKey(packName, key string) error {
+	return s.ReleaseKeyContext(context.Background(), packName, key)
+}
+
+// ReleaseKeyContext is like ReleaseKey.
+func (s *Server) ReleaseKeyContext(ctx context.Context, packName, key string) (err error) {
+	ctx = WithLogFields(ctx, "pack", packName, "key", key)
+	defer func() { s.audit(ctx, "key.release", packName, key, err) }()
+
+	if err := s.begin(false); err != nil {
+		return err
+	}
+	defer s.end()
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	p, err := s.lookupPack(packName)
+	if err != nil {
+		return err
+	}
+	return p.ReleaseKeyContext(ctx, key)
+}
+
+// RevokeKey revokes a key of the named pack, see Pack.RevokeKey.
+func (s *Server) RevokeKey(packName, key string) error {
+	return s.RevokeKeyContext(context.Background(), packName, key)
+}
+
+// RevokeKeyContext is like RevokeKey.
+func (s *Server) RevokeKeyContext(ctx context.Context, packName, key string) (err error) {
+	ctx = WithLogFields(ctx, "pack", packName, "key", key)
+	defer func() { s.audit(ctx, "key.revoke", packName, key, err) }()
+
+	if err := s.begin(false); err != nil {
+		return err
+	}
+	defer s.end()
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	p, err := s.lookupPack(packName)
+	if err != nil {
+		return err
+	}
+	return p.RevokeKeyContext(ctx, key)
+}
+
+// CheckKey returns the status of a key of the named pack, see Pack.CheckKey.
+func (s *Server) CheckKey(packName, key string) (KeyInfo, error) {
+	return s.CheckKeyContext(context.Background(), packName, key)
+}
+
+// CheckKeyContext is like CheckKey. It is guarded like UseKeyContext, since
+// it reveals whether a key exists.
+func (s *Server) CheckKeyContext(ctx context.Context, packName, key string) (info KeyInfo, err error) {
+	ctx = WithLogFields(ctx, "pack", packName, "key", key)
+
+	err = s.guardKey(ctx, packName, func(p *Pack) error {
+		info, err = p.CheckKeyContext(ctx, key)
+		return err
+	})
+	return info, err
+}
+
+// authorizeAnyPack returns nil if the principal of ctx has perm on all packs
+// or on one of them.
+func (s *Server) authorizeAnyPack(ctx context.Context, perm Permission) error {
+	if t, ok := PrincipalFromContext(ctx); ok {
+		for _, p := range s.ListPacks() {
+			if t.Allows(perm, p.Name) {
+				return nil
+			}
+		}
+	}
+	return s.Authorize(ctx, perm, "*")
+}
+
+// guardKey runs fn on the named pack, guarded against brute-force per client
+// address and API token, see GuardConfig.
+func (s *Server) guardKey(ctx context.Context, packName string, fn func(p *Pack) error) error {
+	if err := s.begin(true); err != nil {
+		return err
+	}
+	defer s.end()
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	p, err := s.lookupPack(packName)
+	if err != nil {
+		return err
+	}
+
+	cfg := s.guardConfig(p)
+	sources := guardSources(ctx)
+	if !cfg.Disabled {
+		if err := s.guard.allow(p.Name, cfg, sources, s.opts.Clock.Now()); err != nil {
+			return err
+		}
+	}
+
+	err = fn(p)
+	if !cfg.Disabled {
+		s.guard.record(p.Name, cfg, sources, err, s.opts.Clock.Now())
+	}
+	return err
+}
+
+// Stop closes all packs at once, new key operations fail with
+// ErrServerStopping. Use Shutdown to let running operations finish first.
+func (s *Server) Stop() {
+	s.life.mtx.Lock()
+	stopped := s.life.stopped
+	s.life.stopped = true
+	s.life.mtx.Unlock()
+	if stopped {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.hub.close()
+	s.webhooks.close()
+	s.guard.close()
+
+	for _, p := range s.packs {
+		p.Close()
+	}
+
+	s.auditLog.close()
+}
+
+func (s *Server) HTTPServeMux() *http.ServeMux {
+	m := http.NewServeMux()
+	for _, op := range s.apiOperations() {
+		m.Handle(op.pattern(), s.handler(op))
+	}
+	return m
+}
+
+// apiOperations returns all operations served by HTTPServeMux.
+func (s *Server) apiOperations() []apiOperation {
+	ops := s.cmdOperations()
+	ops = append(ops, s.v1Operations()...)
+	return append(ops, []apiOperation{
+		// The event stream is long-lived, its latency is not observed.
+		{Method: "GET", Path: "/events", AnyMethod: true, ID: "events", Perm: PermPackView,
+			Summary: "Event stream, see handleEvents", Params: eventParams,
+			ContentType: "text/event-stream", Status: http.StatusOK,
+			Handler: s.handleEvents, Unobserved: true},
+		{Method: "GET", Path: "/v1/events", ID: "v1.events", Perm: PermPackView,
+			Summary: "Event stream, see handleEvents", Params: eventParams,
+			ContentType: "text/event-stream", Status: http.StatusOK,
+			Handler: s.handleEvents, Unobserved: true},
+		{Method: "GET", Path: "/metrics", AnyMethod: true, ID: "metrics", Perm: PermMetricsView,
+			Summary: "Prometheus metrics", ContentType: "text/plain", Status: http.StatusOK,
+			Handler: s.handleMetrics, Unobserved: true},
+
+		// Health checks are for orchestrators and never require a token.
+		{Method: "GET", Path: "/healthz", AnyMethod: true, ID: "healthz",
+			Summary: "Liveness check", Response: LivenessReport{}, Status: http.StatusOK,
+			Handler: s.handleHealthz, Public: true, Unobserved: true},
+		{Method: "GET", Path: "/readyz", AnyMethod: true, ID: "readyz",
+			Summary: "Readiness check", Response: HealthReport{}, Status: http.StatusOK,
+			Handler: s.handleReadyz, Public: true, Unobserved: true},
+
+		{Method: "GET", Path: "/openapi.json", ID: "openapi",
+			Summary: "This document", ContentType: "application/json", Status: http.StatusOK,
+			Handler: s.handleOpenAPI, Public: true, Unobserved: true},
+	}...)
+}
+
+var eventParams = []apiParam{
+	{"pack", "query", "string", "comma separated packs, all packs if empty"},
+	{"type", "query", "string", "comma separated event types, all types if empty"},
+}
+
+// cmdOperations returns the dot commands. They take a JSON body and accept
+// any method.
+func (s *Server) cmdOperations() []apiOperation {
+	cmd := func(id string, perm Permission, summary string, req, rsp interface{}, h http.HandlerFunc) apiOperation {
+		return apiOperation{Method: "POST", Path: "/" + id, AnyMethod: true, ID: id, Perm: perm,
+			Summary: summary, Request: req, Response: rsp, Status: http.StatusOK, Handler: h}
+	}
+
+	return []apiOperation{
+		cmd("pack.list", PermPackView, "List the packs visible to the token",
+			PackListRequest{}, PackListResponse{}, s.handlePackList),
+		cmd("pack.add", PermPackAdmin, "Add a pack",
+			PackAddRequest{}, PackResponse{}, s.handlePackAdd),
+		cmd("pack.remove", PermPackAdmin, "Remove a pack",
+			PackRequest{}, PackResponse{}, s.handlePackRemove),
+		cmd("pack.enable", PermPackManage, "Enable a pack",
+			PackRequest{}, PackResponse{}, s.handlePackEnable),
+		cmd("pack.disable", PermPackManage, "Disable a pack",
+			PackDisableRequest{}, PackResponse{}, s.handlePackDisable),
+		cmd("pack.verify", PermPackManage, "Verify and optionally repair a pack",
+			PackVerifyRequest{}, PackVerifyResponse{}, s.handlePackVerify),
+		cmd("pack.reload", PermPackManage, "Reload a broken pack",
+			PackRequest{}, PackResponse{}, s.handlePackReload),
+		cmd("pack.guard", PermPackManage, "Set the GuardConfig of a pack",
+			PackGuardRequest{}, PackResponse{}, s.handlePackGuard),
+		cmd("pack.extend", PermPackAdmin, "Generate more keys for a pack",
+			PackExtendRequest{}, PackResponse{}, s.handlePackExtend),
+
+		cmd("key.list", PermPackView, "List the keys of a pack, or a page of them",
+			KeyListRequest{}, KeyListResponse{}, s.handleKeyList),
+		cmd("key.find", PermPackView, "Search a key in the packs visible to the token",
+			KeyFindRequest{}, KeyFindResponse{}, s.handleKeyFind),
+		cmd("key.use", PermKeyUse, "Use a key",
+			KeyUseRequest{}, KeyUseResponse{}, s.handleKeyUse),
+		cmd("key.check", PermKeyUse, "Status of a key",
+			KeyRequest{}, KeyResponse{}, s.handleKeyCheck),
+		cmd("key.revoke", PermPackManage, "Revoke a ready or reserved key",
+			KeyRequest{}, KeyResponse{}, s.handleKeyRevoke),
+
+		cmd("project.add", PermProjectAdmin, "Add a project",
+			ProjectAddRequest{}, ProjectResponse{}, s.handleProjectAdd),
+		cmd("project.list", PermPackView, "List the projects visible to the token",
+			nil, ProjectListResponse{}, s.handleProjectList),
+		cmd("project.quota", PermProjectAdmin, "Set the quotas of a project",
+			ProjectQuotaRequest{}, ProjectResponse{}, s.handleProjectQuota),
+		cmd("project.remove", PermProjectAdmin, "Remove an empty project",
+			ProjectRequest{}, ProjectResponse{}, s.handleProjectRemove),
+
+		cmd("audit.query", PermAuditView, "Query the audit log",
+			AuditQuery{}, AuditQueryResponse{}, s.handleAuditQuery),
+
+		cmd("webhook.add", PermWebhookAdmin, "Add a webhook",
+			Webhook{}, WebhookResponse{}, s.handleWebhookAdd),
+		cmd("webhook.list", PermWebhookAdmin, "List webhooks",
+			nil, WebhookListResponse{}, s.handleWebhookList),
+		cmd("webhook.remove", PermWebhookAdmin, "Remove a webhook",
+			IDRequest{}, IDResponse{}, s.handleWebhookRemove),
+		cmd("webhook.deliveries", PermWebhookAdmin, "Recent deliveries of a webhook",
+			WebhookDeliveriesRequest{}, WebhookDeliveriesResponse{}, s.handleWebhookDeliveries),
+
+		cmd("token.create", PermTokenAdmin, "Create an API token",
+			TokenCreateRequest{}, TokenCreateResponse{}, s.handleTokenCreate),
+		cmd("token.list", PermTokenAdmin, "List API tokens",
+			nil, TokenListResponse{}, s.handleTokenList),
+		cmd("token.revoke", PermTokenAdmin, "Revoke an API token",
+			IDRequest{}, IDResponse{}, s.handleTokenRevoke),
+	}
+}
+
+func putStatusError(w http.ResponseWriter, cmd string, err error) {
+	var e *StatusError
+	if errors.As(err, &e) {
+		e = e.withCmd(cmd)
+		http.Error(w, e.Json(), e.HTTPCode())
+	} else {
+		putStatusError(w, cmd, ErrInternal.affix(err))
+	}
+}
+
+func readJsonRequest(r *http.Request, v interface{}) error {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return ErrBadRequest.affix(err)
+	}
+
+	return nil
+}
+
+func (s *Server) handlePackList(w http.ResponseWriter, r *http.Request) {
+	req := PackListRequest{}
+
+	if r.ContentLength != 0 {
+		if err := readJsonRequest(r, &req); err != nil {
+			putStatusError(w, "pack.list", err)
+			return
+		}
+	}
+
+	all := s.ListPacks()
+	if req.Project != nil {
+		all = s.ListProjectPacks(*req.Project)
+	}
+
+	var packs []PackInfo
+	for _, p := range all {
+		if s.Authorize(r.Context(), PermPackView, p.Name) == nil {
+			packs = append(packs, p)
+		}
+	}
+
+	rsp, _ := json.Marshal(PackListResponse{
+		Cmd:   "pack.list",
+		Packs: packs,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
+
+func (s *Server) handlePackAdd(w http.ResponseWriter, r *http.Request) {
+	req := PackAddRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "pack.add", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermPackAdmin, req.Name); err != nil {
+		putStatusError(w, "pack.add", err)
+		return
+	}
+
+	if err := s.AddPackContext(r.Context(), req.Name, req.Prefix, req.KeyLen, req.PackSize, req.Note); err != nil {
+		putStatusError(w, "pack.add", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(PackResponse{
+		Cmd:  "pack.add",
+		Pack: req.Name,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
+
+func (s *Server) handlePackRemove(w http.ResponseWriter, r *http.Request) {
+	req := PackRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "pack.remove", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermPackAdmin, req.Pack); err != nil {
+		putStatusError(w, "pack.remove", err)
+		return
+	}
+
+	if err := s.RemovePackContext(r.Context(), req.Pack); err != nil {
+		putStatusError(w, "pack.remove", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(PackResponse{
+		Cmd:  "pack.remove",
+		Pack: req.Pack,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
+
+func (s *Server) handlePackEnable(w http.ResponseWriter, r *http.Request) {
+	req := PackRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "pack.enable", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermPackManage, req.Pack); err != nil {
+		putStatusError(w, "pack.enable", err)
+		return
+	}
+
+	if err := s.EnablePackContext(r.Context(), req.Pack); err != nil {
+		putStatusError(w, "pack.enable", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(PackResponse{
+		Cmd:  "pack.enable",
+		Pack: req.Pack,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
+
+func (s *Server) handlePackDisable(w http.ResponseWriter, r *http.Request) {
+	req := PackDisableRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "pack.disable", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermPackManage, req.Pack); err != nil {
+		putStatusError(w, "pack.disable", err)
+		return
+	}
+
+	if err := s.DisablePackContext(r.Context(), req.Pack, req.Msg); err != nil {
+		putStatusError(w, "pack.disable", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(PackResponse{
+		Cmd:  "pack.disable",
+		Pack: req.Pack,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
+
+func (s *Server) handlePackReload(w http.ResponseWriter, r *http.Request) {
+	req := PackRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "pack.reload", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermPackManage, req.Pack); err != nil {
+		putStatusError(w, "pack.reload", err)
+		return
+	}
+
+	if err := s.ReloadPackContext(r.Context(), req.Pack); err != nil {
+		putStatusError(w, "pack.reload", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(PackResponse{
+		Cmd:  "pack.reload",
+		Pack: req.Pack,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
+
+func (s *Server) handlePackGuard(w http.ResponseWriter, r *http.Request) {
+	req := PackGuardRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "pack.guard", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermPackManage, req.Pack); err != nil {
+		putStatusError(w, "pack.guard", err)
+		return
+	}
+
+	if err := s.SetPackGuardContext(r.Context(), req.Pack, req.Guard); err != nil {
+		putStatusError(w, "pack.guard", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(PackResponse{
+		Cmd:  "pack.guard",
+		Pack: req.Pack,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
+
+func (s *Server) handlePackExtend(w http.ResponseWriter, r *http.Request) {
+	req := PackExtendRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "pack.extend", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermPackAdmin, req.Pack); err != nil {
+		putStatusError(w, "pack.extend", err)
+		return
+	}
+
+	if err := s.ExtendPackContext(r.Context(), req.Pack, req.Count); err != nil {
+		putStatusError(w, "pack.extend", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(PackResponse{
+		Cmd:  "pack.extend",
+		Pack: req.Pack,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
+
+func (s *Server) handlePackVerify(w http.ResponseWriter, r *http.Request) {
+	req := PackVerifyRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "pack.verify", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermPackManage, req.Pack); err != nil {
+		putStatusError(w, "pack.verify", err)
+		return
+	}
+
+	report, err := s.VerifyPackContext(r.Context(), req.Pack, req.Repair)
+	if err != nil {
+		putStatusError(w, "pack.verify", err)
+		return
+	}
 
-	if f, err := os.Stat(path); err != nil {
-		if os.IsNotExist(err) {
-			info_logf("path %v not exists, try to create", path)
+	rsp, _ := json.Marshal(PackVerifyResponse{
+		Cmd:    "pack.verify",
+		Pack:   req.Pack,
+		OK:     report.OK(),
+		Report: report,
+	})
 
-			if err := os.MkdirAll(path, 0644); err != nil {
-				error_logf("failed mkdir (path:%v)", path)
-				return nil
-			}
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
 
-			info_logf("created path %v", path)
-		} else {
-			error_logf("failed access path %v", path)
-			return nil
-		}
-	} else {
-		if !f.IsDir() {
-			error_logf("%v is not a directory", path)
-			return nil
-		}
+func (s *Server) handleKeyList(w http.ResponseWriter, r *http.Request) {
+	req := KeyListRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "key.list", err)
+		return
 	}
 
-	packs := make(map[string]*Pack)
-	filepath.Walk(path, func(packPath string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
-			return nil
-		}
+	if err := s.Authorize(r.Context(), PermPackView, req.Pack); err != nil {
+		putStatusError(w, "key.list", err)
+		return
+	}
 
-		if filepath.Clean(filepath.Join(packPath, "..")) != filepath.Clean(path) {
-			return nil
-		}
+	keys, total, err := s.QueryKeysContext(r.Context(), req.Pack, req.KeyQuery)
+	if err != nil {
+		putStatusError(w, "key.list", err)
+		return
+	}
 
-		if f, err := os.Stat(filepath.Join(packPath, "pack.json")); err != nil || f.IsDir() {
-			return nil
-		}
+	rsp, _ := json.Marshal(KeyListResponse{
+		Cmd:   "key.list",
+		Pack:  req.Pack,
+		Keys:  keys,
+		Total: total,
+	})
 
-		if p, err := LoadPack(packPath); err == nil {
-			packs[p.Name] = p
-		}
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
 
-		return nil
-	})
+func (s *Server) handleKeyUse(w http.ResponseWriter, r *http.Request) {
+	req := KeyUseRequest{}
 
-	return &Server{
-		path:  path,
-		packs: packs,
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "key.use", err)
+		return
 	}
-}
 
-func (s *Server) ListPacks() []PackInfo {
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
+	if err := s.Authorize(r.Context(), PermKeyUse, req.Pack); err != nil {
+		putStatusError(w, "key.use", err)
+		return
+	}
 
-	var packs []PackInfo
-	for _, p := range s.packs {
-		packs = append(packs, p.Info())
+	if err := s.UseKeyContext(r.Context(), req.Pack, req.Key); err != nil {
+		putStatusError(w, "key.use", err)
+		return
 	}
-	return packs
+
+	rsp, _ := json.Marshal(KeyUseResponse{
+		Cmd:  "key.use",
+		Pack: req.Pack,
+		Key:  req.Key,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
 }
 
-func (s *Server) AddPack(name string, prefix string, keylen, packsize int, note string) error {
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
+func (s *Server) handleKeyCheck(w http.ResponseWriter, r *http.Request) {
+	req := KeyRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "key.check", err)
+		return
+	}
 
-	if _, ok := s.packs[name]; ok {
-		error_logf("pack already exists (name:%v)", name)
-		return errPackAlreadyExists.affix(fmt.Sprintf("name:%v", name))
+	if err := s.Authorize(r.Context(), PermKeyUse, req.Pack); err != nil {
+		putStatusError(w, "key.check", err)
+		return
 	}
 
-	p, err := CreatePack(s.path, name, prefix, keylen, packsize, note)
+	info, err := s.CheckKeyContext(r.Context(), req.Pack, req.Key)
 	if err != nil {
-		return err
+		putStatusError(w, "key.check", err)
+		return
 	}
 
-	s.packs[name] = p
-	return nil
+	rsp, _ := json.Marshal(KeyResponse{
+		Cmd:    "key.check",
+		Pack:   req.Pack,
+		Key:    info.Key,
+		Status: info.Status,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
 }
 
-func (s *Server) RemovePack(name string) error {
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
+func (s *Server) handleKeyFind(w http.ResponseWriter, r *http.Request) {
+	req := KeyFindRequest{}
 
-	if p, ok := s.packs[name]; !ok {
-		error_logf("pack not found (name:%v)", name)
-		return errPackNotFound.affix(fmt.Sprintf("name:%v", name))
-	} else {
-		p.Close()
-		os.RemoveAll(filepath.Join(s.path, name))
-		delete(s.packs, name)
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "key.find", err)
+		return
+	}
 
-		info_logf("pack removed (name:%v)", name)
-		return nil
+	if err := s.authorizeAnyPack(r.Context(), PermPackView); err != nil {
+		putStatusError(w, "key.find", err)
+		return
 	}
-}
 
-func (s *Server) EnablePack(name string) error {
-	s.mtx.RLock()
-	defer s.mtx.RUnlock()
+	all, err := s.FindKeyContext(r.Context(), req.Key)
+	if err != nil {
+		putStatusError(w, "key.find", err)
+		return
+	}
 
-	if p, ok := s.packs[name]; ok {
-		return p.Enable()
-	} else {
-		error_logf("pack not found (name:%v)", name)
-		return errPackNotFound.affix(fmt.Sprintf("name:%v", name))
+	matches := []KeyMatch{}
+	for _, m := range all {
+		if s.Authorize(r.Context(), PermPackView, m.Pack) == nil {
+			matches = append(matches, m)
+		}
 	}
-}
 
-func (s *Server) DisablePack(name string, msg string) error {
-	s.mtx.RLock()
-	defer s.mtx.RUnlock()
+	rsp, _ := json.Marshal(KeyFindResponse{
+		Cmd:     "key.find",
+		Key:     req.Key,
+		Matches: matches,
+	})
 
-	if p, ok := s.packs[name]; ok {
-		return p.Disable(msg)
-	} else {
-		error_logf("pack not found (name:%v)", name)
-		return errPackNotFound.affix(fmt.Sprintf("name:%v", name))
-	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
 }
 
-func (s *Server) ListKeys(packName string) ([]KeyInfo, error) {
-	s.mtx.RLock()
-	defer s.mtx.RUnlock()
+func (s *Server) handleKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	req := KeyRequest{}
 
-	if p, ok := s.packs[packName]; ok {
-		return p.ListKeys()
-	} else {
-		error_logf("pack not found (name:%v)", packName)
-		return nil, errPackNotFound.affix(fmt.Sprintf("name:%v", packName))
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "key.revoke", err)
+		return
 	}
-}
 
-func (s *Server) UseKey(packName, key string) error {
-	s.mtx.RLock()
-	defer s.mtx.RUnlock()
+	if err := s.Authorize(r.Context(), PermPackManage, req.Pack); err != nil {
+		putStatusError(w, "key.revoke", err)
+		return
+	}
 
-	if p, ok := s.packs[packName]; ok {
-		return p.UseKey(key)
-	} else {
-		error_logf("pack not found (name:%v)", packName)
-		return errPackNotFound.affix(fmt.Sprintf("name:%v", packName))
+	if err := s.RevokeKeyContext(r.Context(), req.Pack, req.Key); err != nil {
+		putStatusError(w, "key.revoke", err)
+		return
 	}
+
+	key, _ := NormalizeKey(req.Key)
+	rsp, _ := json.Marshal(KeyResponse{
+		Cmd:    "key.revoke",
+		Pack:   req.Pack,
+		Key:    key,
+		Status: keyRevoked.String(),
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
 }
 
-func (s *Server) Stop() {
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
+func (s *Server) handleTokenCreate(w http.ResponseWriter, r *http.Request) {
+	req := TokenCreateRequest{}
 
-	for _, p := range s.packs {
-		p.Close()
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "token.create", err)
+		return
 	}
-}
 
-func (s *Server) HTTPServeMux() *http.ServeMux {
-	m := http.NewServeMux()
+	if err := s.Authorize(r.Context(), PermTokenAdmin, ""); err != nil {
+		putStatusError(w, "token.create", err)
+		return
+	}
 
-	m.HandleFunc("/pack.list", s.handlePackList)
-	m.HandleFunc("/pack.add", s.handlePackAdd)
-	m.HandleFunc("/pack.remove", s.handlePackRemove)
-	m.HandleFunc("/pack.enable", s.handlePackEnable)
-	m.HandleFunc("/pack.disable", s.handlePackDisable)
+	secret, info, err := s.CreateTokenContext(r.Context(), req.Name, req.Bindings)
+	if err != nil {
+		putStatusError(w, "token.create", err)
+		return
+	}
 
-	m.HandleFunc("/key.list", s.handleKeyList)
-	m.HandleFunc("/key.use", s.handleKeyUse)
+	rsp, _ := json.Marshal(TokenCreateResponse{
+		Cmd:       "token.create",
+		Token:     secret,
+		TokenInfo: info,
+	})
 
-	return m
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
 }
 
-func putStatusError(w http.ResponseWriter, cmd string, err error) {
-	if e, ok := err.(statusError); ok {
-		e.Cmd = cmd
-		http.Error(w, e.Json(), e.HTTPCode)
-	} else {
-		putStatusError(w, cmd, errInternal.affix(err))
+func (s *Server) handleTokenList(w http.ResponseWriter, r *http.Request) {
+	if err := s.Authorize(r.Context(), PermTokenAdmin, ""); err != nil {
+		putStatusError(w, "token.list", err)
+		return
 	}
+
+	rsp, _ := json.Marshal(TokenListResponse{
+		Cmd:    "token.list",
+		Tokens: s.ListTokens(),
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
 }
 
-func readJsonRequest(r *http.Request, v interface{}) error {
-	b, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return err
+func (s *Server) handleTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	req := IDRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "token.revoke", err)
+		return
 	}
 
-	if err := json.Unmarshal(b, v); err != nil {
-		return errBadRequest.affix(err)
+	if err := s.Authorize(r.Context(), PermTokenAdmin, ""); err != nil {
+		putStatusError(w, "token.revoke", err)
+		return
 	}
 
-	return nil
-}
+	if err := s.RevokeTokenContext(r.Context(), req.ID); err != nil {
+		putStatusError(w, "token.revoke", err)
+		return
+	}
 
-func (s *Server) handlePackList(w http.ResponseWriter, r *http.Request) {
-	rsp, _ := json.Marshal(struct {
-		Cmd   string     `json:"cmd"`
-		Packs []PackInfo `json:"packs"`
-	}{
-		Cmd:   "pack.list",
-		Packs: s.ListPacks(),
+	rsp, _ := json.Marshal(IDResponse{
+		Cmd: "token.revoke",
+		ID:  req.ID,
 	})
 
 	w.WriteHeader(http.StatusOK)
 	w.Write(rsp)
 }
 
-func (s *Server) handlePackAdd(w http.ResponseWriter, r *http.Request) {
-	req := struct {
-		Name     string `json:"name"`
-		Prefix   string `json:"prefix"`
-		KeyLen   int    `json:"keylen"`
-		PackSize int    `json:"packsize"`
-		Note     string `json:"note"`
-	}{}
+func (s *Server) handleProjectAdd(w http.ResponseWriter, r *http.Request) {
+	req := ProjectAddRequest{}
 
 	if err := readJsonRequest(r, &req); err != nil {
-		putStatusError(w, "pack.add", err)
+		putStatusError(w, "project.add", err)
 		return
 	}
 
-	if err := s.AddPack(req.Name, req.Prefix, req.KeyLen, req.PackSize, req.Note); err != nil {
-		putStatusError(w, "pack.add", err)
+	if err := s.Authorize(r.Context(), PermProjectAdmin, ""); err != nil {
+		putStatusError(w, "project.add", err)
 		return
 	}
 
-	rsp, _ := json.Marshal(struct {
-		Cmd  string `json:"cmd"`
-		Pack string `json:"pack"`
-	}{
-		Cmd:  "pack.add",
-		Pack: req.Name,
+	if err := s.AddProjectContext(r.Context(), req.Name, req.MaxPacks, req.MaxKeys, req.Note); err != nil {
+		putStatusError(w, "project.add", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(ProjectResponse{
+		Cmd:     "project.add",
+		Project: req.Name,
 	})
 
 	w.WriteHeader(http.StatusOK)
 	w.Write(rsp)
 }
 
-func (s *Server) handlePackRemove(w http.ResponseWriter, r *http.Request) {
-	req := struct {
-		Pack string `json:"pack"`
-	}{}
+func (s *Server) handleProjectList(w http.ResponseWriter, r *http.Request) {
+	projects := []ProjectInfo{}
+	for _, p := range s.ListProjects() {
+		if s.authorizeProject(r.Context(), p.Name) == nil {
+			projects = append(projects, p)
+		}
+	}
+
+	rsp, _ := json.Marshal(ProjectListResponse{
+		Cmd:      "project.list",
+		Projects: projects,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
+
+func (s *Server) handleProjectQuota(w http.ResponseWriter, r *http.Request) {
+	req := ProjectQuotaRequest{}
 
 	if err := readJsonRequest(r, &req); err != nil {
-		putStatusError(w, "pack.remove", err)
+		putStatusError(w, "project.quota", err)
 		return
 	}
 
-	if err := s.RemovePack(req.Pack); err != nil {
-		putStatusError(w, "pack.remove", err)
+	if err := s.Authorize(r.Context(), PermProjectAdmin, ""); err != nil {
+		putStatusError(w, "project.quota", err)
 		return
 	}
 
-	rsp, _ := json.Marshal(struct {
-		Cmd  string `json:"cmd"`
-		Pack string `json:"pack"`
-	}{
-		Cmd:  "pack.add",
-		Pack: req.Pack,
+	if err := s.SetProjectQuotaContext(r.Context(), req.Project, req.MaxPacks, req.MaxKeys); err != nil {
+		putStatusError(w, "project.quota", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(ProjectResponse{
+		Cmd:     "project.quota",
+		Project: req.Project,
 	})
 
 	w.WriteHeader(http.StatusOK)
 	w.Write(rsp)
 }
 
-func (s *Server) handlePackEnable(w http.ResponseWriter, r *http.Request) {
-	req := struct {
-		Pack string `json:"pack"`
-	}{}
+func (s *Server) handleProjectRemove(w http.ResponseWriter, r *http.Request) {
+	req := ProjectRequest{}
 
 	if err := readJsonRequest(r, &req); err != nil {
-		putStatusError(w, "pack.enable", err)
+		putStatusError(w, "project.remove", err)
 		return
 	}
 
-	if err := s.EnablePack(req.Pack); err != nil {
-		putStatusError(w, "pack.enable", err)
+	if err := s.Authorize(r.Context(), PermProjectAdmin, ""); err != nil {
+		putStatusError(w, "project.remove", err)
 		return
 	}
 
-	rsp, _ := json.Marshal(struct {
-		Cmd  string `json:"cmd"`
-		Pack string `json:"pack"`
-	}{
-		Cmd:  "pack.enable",
-		Pack: req.Pack,
+	if err := s.RemoveProjectContext(r.Context(), req.Project); err != nil {
+		putStatusError(w, "project.remove", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(ProjectResponse{
+		Cmd:     "project.remove",
+		Project: req.Project,
 	})
 
 	w.WriteHeader(http.StatusOK)
 	w.Write(rsp)
 }
 
-func (s *Server) handlePackDisable(w http.ResponseWriter, r *http.Request) {
-	req := struct {
-		Pack string `json:"pack"`
-		Msg  string `json:"msg"`
-	}{}
+func (s *Server) handleAuditQuery(w http.ResponseWriter, r *http.Request) {
+	var req AuditQuery
 
 	if err := readJsonRequest(r, &req); err != nil {
-		putStatusError(w, "pack.disable", err)
+		putStatusError(w, "audit.query", err)
 		return
 	}
 
-	if err := s.DisablePack(req.Pack, req.Msg); err != nil {
-		putStatusError(w, "pack.disable", err)
+	if err := s.Authorize(r.Context(), PermAuditView, ""); err != nil {
+		putStatusError(w, "audit.query", err)
 		return
 	}
 
-	rsp, _ := json.Marshal(struct {
-		Cmd  string `json:"cmd"`
-		Pack string `json:"pack"`
-	}{
-		Cmd:  "pack.disable",
-		Pack: req.Pack,
+	records, err := s.QueryAudit(req)
+	if err != nil {
+		putStatusError(w, "audit.query", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(AuditQueryResponse{
+		Cmd:     "audit.query",
+		Records: records,
 	})
 
 	w.WriteHeader(http.StatusOK)
 	w.Write(rsp)
 }
 
-func (s *Server) handleKeyList(w http.ResponseWriter, r *http.Request) {
-	req := struct {
-		Pack string `json:"pack"`
-	}{}
+func (s *Server) handleWebhookAdd(w http.ResponseWriter, r *http.Request) {
+	var req Webhook
 
 	if err := readJsonRequest(r, &req); err != nil {
-		putStatusError(w, "key.list", err)
+		putStatusError(w, "webhook.add", err)
 		return
 	}
 
-	keys, err := s.ListKeys(req.Pack)
+	if err := s.Authorize(r.Context(), PermWebhookAdmin, ""); err != nil {
+		putStatusError(w, "webhook.add", err)
+		return
+	}
+
+	hook, err := s.AddWebhookContext(r.Context(), req)
 	if err != nil {
-		putStatusError(w, "key.list", err)
+		putStatusError(w, "webhook.add", err)
 		return
 	}
 
-	rsp, _ := json.Marshal(struct {
-		Cmd  string    `json:"cmd"`
-		Pack string    `json:"pack"`
-		Keys []KeyInfo `json:"keys"`
-	}{
-		Cmd:  "key.list",
-		Pack: req.Pack,
-		Keys: keys,
+	rsp, _ := json.Marshal(WebhookResponse{
+		Cmd:     "webhook.add",
+		Webhook: hook,
 	})
 
 	w.WriteHeader(http.StatusOK)
 	w.Write(rsp)
 }
 
-func (s *Server) handleKeyUse(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleWebhookList(w http.ResponseWriter, r *http.Request) {
+	if err := s.Authorize(r.Context(), PermWebhookAdmin, ""); err != nil {
+		putStatusError(w, "webhook.list", err)
+		return
+	}
 
-	req := struct {
-		Pack string `json:"pack`
-		Key  string `json:"key`
-	}{}
+	rsp, _ := json.Marshal(WebhookListResponse{
+		Cmd:      "webhook.list",
+		Webhooks: s.ListWebhooks(),
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
+
+func (s *Server) handleWebhookRemove(w http.ResponseWriter, r *http.Request) {
+	req := IDRequest{}
 
 	if err := readJsonRequest(r, &req); err != nil {
-		putStatusError(w, "key.use", err)
+		putStatusError(w, "webhook.remove", err)
 		return
 	}
 
-	if err := s.UseKey(req.Pack, req.Key); err != nil {
-		putStatusError(w, "key.use", err)
+	if err := s.Authorize(r.Context(), PermWebhookAdmin, ""); err != nil {
+		putStatusError(w, "webhook.remove", err)
 		return
 	}
 
-	rsp, _ := json.Marshal(struct {
-		Cmd  string `json:"cmd"`
-		Pack string `json:"pack"`
-		Key  string `json:"key"`
-	}{
-		Cmd:  "key.use",
-		Pack: req.Pack,
-		Key:  req.Key,
+	if err := s.RemoveWebhookContext(r.Context(), req.ID); err != nil {
+		putStatusError(w, "webhook.remove", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(IDResponse{
+		Cmd: "webhook.remove",
+		ID:  req.ID,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rsp)
+}
+
+func (s *Server) handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	req := WebhookDeliveriesRequest{}
+
+	if err := readJsonRequest(r, &req); err != nil {
+		putStatusError(w, "webhook.deliveries", err)
+		return
+	}
+
+	if err := s.Authorize(r.Context(), PermWebhookAdmin, ""); err != nil {
+		putStatusError(w, "webhook.deliveries", err)
+		return
+	}
+
+	ds, err := s.WebhookDeliveries(req.ID, req.Limit)
+	if err != nil {
+		putStatusError(w, "webhook.deliveries", err)
+		return
+	}
+
+	rsp, _ := json.Marshal(WebhookDeliveriesResponse{
+		Cmd:        "webhook.deliveries",
+		Deliveries: ds,
 	})
 
 	w.WriteHeader(http.StatusOK)
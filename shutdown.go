@@ -0,0 +1,105 @@
+package cdkey
+
+import (
+	"context"
+	"sync"
+)
+
+// lifecycle tracks the key and pack operations in flight, so that a shutdown
+// can wait for them before closing the packs. The operations are counted under mtx
+// rather than with a sync.WaitGroup, whose Add must not race with Wait while
+// other key operations still begin during a drain.
+type lifecycle struct {
+	draining bool
+	stopped  bool
+	inflight int
+	idle     chan struct{} // closed when inflight drops to 0, see Server.idle
+	mtx      sync.RWMutex
+}
+
+// begin registers a key or pack operation, which must call s.end when
+// finished. Redemptions, i.e. using, reserving and checking keys, are refused
+// once the server drains, other operations, e.g. adding, extending or
+// reloading packs, only once it is stopped, so that no pack is opened after
+// Stop closed them.
+func (s *Server) begin(redeem bool) error {
+	s.life.mtx.Lock()
+	defer s.life.mtx.Unlock()
+
+	if s.life.stopped || (redeem && s.life.draining) {
+		return ErrServerStopping
+	}
+	s.life.inflight++
+	return nil
+}
+
+// end unregisters an operation registered by begin.
+func (s *Server) end() {
+	s.life.mtx.Lock()
+	defer s.life.mtx.Unlock()
+
+	s.life.inflight--
+	if s.life.inflight == 0 && s.life.idle != nil {
+		close(s.life.idle)
+		s.life.idle = nil
+	}
+}
+
+// idle returns a channel which is closed once no operation is in flight.
+func (s *Server) idle() <-chan struct{} {
+	s.life.mtx.Lock()
+	defer s.life.mtx.Unlock()
+
+	if s.life.inflight == 0 {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	if s.life.idle == nil {
+		s.life.idle = make(chan struct{})
+	}
+	return s.life.idle
+}
+
+// Drain makes the server refuse new redemptions with ErrServerStopping and
+// report not ready on /readyz, and closes the /events streams. Key operations
+// already running are not affected. Drain is the first step of Shutdown, it
+// can be called earlier, e.g. to take the server out of a load balancer.
+func (s *Server) Drain() {
+	s.life.mtx.Lock()
+	defer s.life.mtx.Unlock()
+
+	if s.life.draining {
+		return
+	}
+	s.life.draining = true
+	s.hub.close()
+
+	s.log.info_log("server draining")
+}
+
+// Draining reports whether Drain or Shutdown was called.
+func (s *Server) Draining() bool {
+	s.life.mtx.RLock()
+	defer s.life.mtx.RUnlock()
+
+	return s.life.draining
+}
+
+// Shutdown drains the server, waits for the key and pack operations in flight
+// and then stops it. If ctx is done before the operations finish, the server
+// is stopped anyway and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Drain()
+
+	var err error
+	select {
+	case <-s.idle():
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.log.warn_log("shutdown deadline exceeded, stop with key operations in flight", "err", err)
+	}
+
+	s.Stop()
+	return err
+}
@@ -0,0 +1,99 @@
+package cdkey
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShutdownWaitsInflight(t *testing.T) {
+	s, err := NewServerWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.begin(true); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	for !s.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Other key operations still begin while draining, redemptions do not.
+	if err := s.begin(true); !errors.Is(err, ErrServerStopping) {
+		t.Errorf("redemption while draining: %v, want ErrServerStopping", err)
+	}
+	if err := s.begin(false); err != nil {
+		t.Fatalf("key operation while draining: %v", err)
+	}
+
+	s.end()
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown returned %v with a key operation in flight", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	s.end()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return after the key operations finished")
+	}
+
+	if err := s.begin(false); !errors.Is(err, ErrServerStopping) {
+		t.Errorf("key operation after shutdown: %v, want ErrServerStopping", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	s, err := NewServerWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.begin(false); err != nil {
+		t.Fatal(err)
+	}
+	defer s.end()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("shutdown: %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestPackOperationsAfterStop(t *testing.T) {
+	s, err := NewServerWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddPack("summer", "S", 12, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	p := s.packs["summer"]
+	s.Stop()
+
+	for op, err := range map[string]error{
+		"add":    s.AddPack("winter", "W", 12, 10, ""),
+		"extend": s.ExtendPack("summer", 10),
+		"reload": s.ReloadPack("summer"),
+		"remove": s.RemovePack("summer"),
+		"enable": s.EnablePack("summer"),
+	} {
+		if !errors.Is(err, ErrServerStopping) {
+			t.Errorf("%v after stop: %v, want ErrServerStopping", op, err)
+		}
+	}
+
+	// A closed pack may be closed again.
+	p.Close()
+}
@@ -0,0 +1,134 @@
+package cdkey
+
+import (
+	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ErrStoreNotFound must be returned by Store.Get if the key does not exist.
+var ErrStoreNotFound = errors.New("cdkey: key not found in store")
+
+// Storage is a storage backend, which creates and opens the key-value stores
+// holding the keys of packs. Each pack is stored in its own directory.
+type Storage interface {
+	// Create creates a new store at path. It fails if a store already exists.
+	Create(path string) (Store, error)
+
+	// Open opens an existing store at path.
+	Open(path string) (Store, error)
+}
+
+// Store is a key-value store holding the keys of a single pack.
+type Store interface {
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Write(b *Batch) error
+
+	// NewIterator returns an iterator over all entries, in key order.
+	NewIterator() Iterator
+
+	// NewPrefixIterator returns an iterator over the entries whose key
+	// starts with prefix, in key order.
+	NewPrefixIterator(prefix []byte) Iterator
+
+	Close() error
+}
+
+// Iterator iterates over the entries of a Store. It must be released after
+// use.
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Release()
+	Error() error
+}
+
+type batchOp struct {
+	key, value []byte
+	delete     bool
+}
+
+// Batch is a set of writes applied atomically by Store.Write.
+type Batch struct {
+	ops []batchOp
+}
+
+func (b *Batch) Put(key, value []byte) {
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+}
+
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+}
+
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Replay calls put or del for every write in the batch, in order.
+func (b *Batch) Replay(put func(key, value []byte), del func(key []byte)) {
+	for _, op := range b.ops {
+		if op.delete {
+			del(op.key)
+		} else {
+			put(op.key, op.value)
+		}
+	}
+}
+
+// LevelDBStorage is the default Storage, backed by goleveldb.
+type LevelDBStorage struct{}
+
+func (LevelDBStorage) Create(path string) (Store, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ErrorIfExist: true})
+	if err != nil {
+		return nil, err
+	}
+	return levelDBStore{db}, nil
+}
+
+func (LevelDBStorage) Open(path string) (Store, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ErrorIfMissing: true})
+	if err != nil {
+		return nil, err
+	}
+	return levelDBStore{db}, nil
+}
+
+type levelDBStore struct {
+	db *leveldb.DB
+}
+
+func (s levelDBStore) Get(key []byte) ([]byte, error) {
+	b, err := s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrStoreNotFound
+	}
+	return b, err
+}
+
+func (s levelDBStore) Put(key, value []byte) error {
+	return s.db.Put(key, value, nil)
+}
+
+func (s levelDBStore) Write(b *Batch) error {
+	batch := &leveldb.Batch{}
+	b.Replay(batch.Put, batch.Delete)
+	return s.db.Write(batch, nil)
+}
+
+func (s levelDBStore) NewIterator() Iterator {
+	return s.db.NewIterator(nil, nil)
+}
+
+func (s levelDBStore) NewPrefixIterator(prefix []byte) Iterator {
+	return s.db.NewIterator(util.BytesPrefix(prefix), nil)
+}
+
+func (s levelDBStore) Close() error {
+	return s.db.Close()
+}
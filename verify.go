@@ -0,0 +1,202 @@
+package cdkey
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// KeyIssue describes a single bad entry found by Pack.Verify.
+type KeyIssue struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Problem string `json:"problem"`
+}
+
+// VerifyReport is the result of a pack integrity check.
+type VerifyReport struct {
+	Pack     string     `json:"pack"`
+	PackSize int        `json:"packsize"`
+	Keys     int        `json:"keys"`
+	Ready    int        `json:"ready"`
+	Used     int        `json:"used"`
+	Reserved int        `json:"reserved"`
+	Revoked  int        `json:"revoked"`
+	Issues   []KeyIssue `json:"issues"`
+	Repaired int        `json:"repaired"`
+}
+
+// OK reports whether the pack passed the check, i.e. no bad entries were
+// found and the number of keys matches PackSize.
+func (r VerifyReport) OK() bool {
+	return len(r.Issues) == 0 && r.Keys == r.PackSize
+}
+
+func checkKey(key, prefix string, keyLen int) string {
+	switch {
+	case len(key) != keyLen:
+		return fmt.Sprintf("keylen %v, expect %v", len(key), keyLen)
+	case !strings.HasPrefix(key, prefix):
+		return fmt.Sprintf("prefix mismatch, expect %v", prefix)
+	}
+
+	// The prefix may contain 'U', which NormalizeKey accepts but the generator
+	// never produces, so only the generated part is checked against charSet.
+	for _, r := range key[len(prefix):] {
+		if !strings.ContainsRune(charSet, r) {
+			return fmt.Sprintf("invalid char %q", r)
+		}
+	}
+
+	return ""
+}
+
+func checkKeyStatus(b []byte) string {
+	switch string(b) {
+	case string(keyReady.dbVal()), string(keyUsed.dbVal()), string(keyReserved.dbVal()), string(keyRevoked.dbVal()):
+		return ""
+	default:
+		return "unknown status"
+	}
+}
+
+// Verify walks the whole pack database and checks every entry: the key must
+// match the pack's Prefix and KeyLen and contain charSet characters only
+// after the prefix, and its value must be a known key status. The number of
+// keys is compared against PackSize.
+//
+// If repair is true, bad entries are removed from the database and appended
+// to quarantine.json in the pack directory, so they can be inspected later.
+// Keys with a valid format but an unknown status are marked as used, which is
+// how they would have been treated by UseKey anyway.
+func (p *Pack) Verify(repair bool) (VerifyReport, error) {
+	return p.VerifyContext(context.Background(), repair)
+}
+
+// VerifyContext is like Verify, but stops and returns ErrCanceled once ctx is
+// done. Nothing is repaired in that case.
+func (p *Pack) VerifyContext(ctx context.Context, repair bool) (VerifyReport, error) {
+	p.closeMtx.RLock()
+	defer p.closeMtx.RUnlock()
+
+	if p.db == nil {
+		return VerifyReport{}, ErrPackClosing
+	}
+
+	p.log.debug_logc(ctx, "start verify pack", "name", p.Name, "repair", repair)
+
+	info := p.Info()
+	report := VerifyReport{
+		Pack:     p.Name,
+		PackSize: info.PackSize,
+	}
+
+	var stats PackStats
+	var quarantine []KeyIssue
+	batch := &Batch{}
+
+	iter := p.db.NewIterator()
+	defer iter.Release()
+
+	for i := 0; iter.Next(); i++ {
+		if i%checkInterval == 0 {
+			if err := checkContext(ctx); err != nil {
+				return VerifyReport{}, err
+			}
+		}
+
+		key, val := string(iter.Key()), string(iter.Value())
+
+		if problem := checkKey(key, info.Prefix, info.KeyLen); problem != "" {
+			issue := KeyIssue{Key: key, Value: val, Problem: problem}
+			report.Issues = append(report.Issues, issue)
+			quarantine = append(quarantine, issue)
+			batch.Delete([]byte(key))
+			continue
+		}
+
+		report.Keys++
+
+		if problem := checkKeyStatus(iter.Value()); problem != "" {
+			report.Issues = append(report.Issues, KeyIssue{Key: key, Value: val, Problem: problem})
+			batch.Put([]byte(key), keyUsed.dbVal())
+		}
+
+		stats.count(loadKeyStatus(iter.Value()))
+	}
+
+	report.Ready, report.Used = stats.Ready, stats.Used
+	report.Reserved, report.Revoked = stats.Reserved, stats.Revoked
+
+	if iter.Error() != nil {
+		p.log.error_logc(ctx, "failed verify keys", "pack", p.Name, "err", iter.Error())
+		return VerifyReport{}, ErrFailedLoadKeys.affix(iter.Error())
+	}
+
+	if repair && len(report.Issues) > 0 {
+		if err := p.quarantine(quarantine); err != nil {
+			return report, err
+		}
+
+		if err := p.repair(ctx, batch); err != nil {
+			return report, err
+		}
+		report.Repaired = len(report.Issues)
+	}
+
+	p.log.info_logc(ctx, "pack verified", "name", p.Name, "keys", report.Keys, "packsize", report.PackSize,
+		"issues", len(report.Issues), "repaired", report.Repaired)
+
+	return report, nil
+}
+
+// repair writes batch and counts the keys again. Keys may change status
+// since the scan, so the count is done while status changes, which hold
+// useMtx, and extensions, which hold infoMtx, are blocked.
+func (p *Pack) repair(ctx context.Context, batch *Batch) error {
+	p.infoMtx.RLock()
+	defer p.infoMtx.RUnlock()
+
+	p.useMtx.Lock()
+	defer p.useMtx.Unlock()
+
+	if err := p.db.Write(batch); err != nil {
+		p.log.error_logc(ctx, "failed repair keys", "pack", p.Name, "err", err)
+		return ErrFailedSaveKeys.affix(err)
+	}
+	return p.countKeys()
+}
+
+func (p *Pack) quarantine(issues []KeyIssue) error {
+	if len(issues) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(filepath.Join(p.path, "quarantine.json"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		p.log.error_log("failed open quarantine.json", "err", err)
+		return ErrFailedSaveKeys.affix(err)
+	}
+	defer f.Close()
+
+	now := time.Now()
+	enc := json.NewEncoder(f)
+	for _, issue := range issues {
+		entry := struct {
+			KeyIssue
+			Time time.Time `json:"time"`
+		}{issue, now}
+
+		if err := enc.Encode(entry); err != nil {
+			p.log.error_log("failed write quarantine.json", "err", err)
+			return ErrFailedSaveKeys.affix(err)
+		}
+	}
+
+	p.log.info_log("keys quarantined", "pack", p.Name, "count", len(issues))
+	return nil
+}
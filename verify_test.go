@@ -0,0 +1,50 @@
+package cdkey
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyPrefixWithU(t *testing.T) {
+	// 'U' is accepted in prefixes by NormalizeKey, but is not in charSet.
+	p, err := CreatePack(t.TempDir(), "summer", "SUMMER", 12, 100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	report, err := p.Verify(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Keys != 100 || report.Repaired != 0 {
+		t.Fatalf("verify of a sound pack: %+v", report)
+	}
+
+	// A 'U' after the prefix is still an issue.
+	if err := p.db.Put([]byte("SUMMERUUUUUU"), keyReady.dbVal()); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = p.Verify(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Key != "SUMMERUUUUUU" || report.Repaired != 1 {
+		t.Fatalf("verify of a bad key: %+v", report)
+	}
+	if !strings.Contains(report.Issues[0].Problem, "invalid char") {
+		t.Errorf("problem %q, want invalid char", report.Issues[0].Problem)
+	}
+	if st := p.Stats(); st.Ready != 100 {
+		t.Errorf("stats after repair: %+v, want 100 ready", st)
+	}
+
+	report, err = p.Verify(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Ready != 100 {
+		t.Fatalf("verify after repair: %+v", report)
+	}
+}
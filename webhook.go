@@ -0,0 +1,623 @@
+package cdkey
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook is a HTTP endpoint notified of events, by a POST of the Event as
+// JSON. The body is signed with HMAC-SHA256 using Secret, the signature is
+// sent hex encoded in the X-CDKey-Signature header as "sha256=<hex>".
+//
+// A webhook receives the events of the packs matching Packs, in the syntax of
+// RoleBinding; "*" makes a global webhook. If Events is empty all events are
+// sent. A pack.exhausted event is sent once the unused keys of a pack, ready or
+// reserved, drop to Threshold (a fraction of PackSize, default 0.1) or below
+// by a key being used or revoked.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Packs     string    `json:"packs"`
+	Events    []string  `json:"events"`
+	Threshold float64   `json:"threshold"`
+	Note      string    `json:"note"`
+	Time      time.Time `json:"createTime"`
+}
+
+func (h Webhook) wants(e Event) bool {
+	if !(RoleBinding{Packs: h.Packs}).matches(e.Pack) {
+		return false
+	}
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, typ := range h.Events {
+		if typ == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// exhausted reports whether the key.used or key.revoked event e made the
+// unused keys of its pack drop to the threshold of h. Reserved keys count as
+// unused, they may still be released, so reserving a key never exhausts a pack.
+func (h Webhook) exhausted(e Event) bool {
+	if (e.Type != EventKeyUsed && e.Type != EventKeyRevoked) || e.PackSize == 0 {
+		return false
+	}
+	limit := h.Threshold * float64(e.PackSize)
+	unused := float64(e.Ready + e.Reserved)
+	return unused <= limit && unused+1 > limit
+}
+
+// Delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is an attempt to deliver an event to a webhook. Pending deliveries
+// are kept in a persistent queue and retried with exponential backoff, up to
+// maxDeliveryAttempts times.
+type Delivery struct {
+	ID          string    `json:"id"`
+	Webhook     string    `json:"webhook"`
+	Event       Event     `json:"event"`
+	State       string    `json:"state"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+	UpdateTime  time.Time `json:"updateTime"`
+}
+
+const (
+	maxDeliveryAttempts = 10
+	minDeliveryBackoff  = 5 * time.Second
+	maxDeliveryBackoff  = time.Hour
+
+	// maxFinishedDeliveries is the number of delivered and failed deliveries
+	// kept for inspection, older ones are removed.
+	maxFinishedDeliveries = 1000
+)
+
+func deliveryBackoff(attempts int) time.Duration {
+	d := minDeliveryBackoff << uint(attempts-1)
+	if d <= 0 || d > maxDeliveryBackoff {
+		d = maxDeliveryBackoff
+	}
+	return d
+}
+
+// webhookManager keeps the webhooks in webhooks.json and the delivery queue and
+// log in the store _webhooks, both in the server directory. Queued deliveries
+// are stored as "q/<id>", finished ones as "d/<id>". Ids sort by creation time.
+//
+// New deliveries are handed to the delivery loop, which stores them, so that
+// publishing an event never waits for the store. The deliveries of each
+// webhook are attempted in order by a worker of their own, so a slow endpoint
+// does not hold up the others.
+type webhookManager struct {
+	path  string
+	clock Clock
+	log   libLogger
+	db    Store
+
+	hooks map[string]Webhook
+	busy  map[string]bool // webhooks with a running worker
+	mtx   sync.RWMutex
+
+	queue    []Delivery // new deliveries not yet stored by the delivery loop
+	queueMtx sync.Mutex
+
+	client  *http.Client
+	workers sync.WaitGroup
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func openWebhookManager(dir string, opts Options) (*webhookManager, error) {
+	m := &webhookManager{
+		path:   filepath.Join(dir, "webhooks.json"),
+		clock:  opts.Clock,
+		log:    opts.libLogger(),
+		hooks:  make(map[string]Webhook),
+		busy:   make(map[string]bool),
+		client: &http.Client{Timeout: 10 * time.Second},
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	b, err := ioutil.ReadFile(m.path)
+	if err != nil && !os.IsNotExist(err) {
+		m.log.error_log("failed read webhooks.json", "err", err)
+		return nil, ErrFailedLoadWebhooks.affix(err)
+	}
+	if err == nil {
+		var hooks []Webhook
+		if err := json.Unmarshal(b, &hooks); err != nil {
+			m.log.error_log("failed parse webhooks.json", "err", err)
+			return nil, ErrFailedLoadWebhooks.affix(err)
+		}
+		for _, h := range hooks {
+			m.hooks[h.ID] = h
+		}
+	}
+
+	dbPath := filepath.Join(dir, "_webhooks")
+	if _, err = os.Stat(dbPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(dbPath, opts.DirPerm); err != nil {
+			return nil, ErrFailedLoadWebhooks.affix(err)
+		}
+		m.db, err = opts.Storage.Create(dbPath)
+	} else {
+		m.db, err = opts.Storage.Open(dbPath)
+	}
+	if err != nil {
+		m.log.error_log("failed open webhook queue", "err", err)
+		return nil, ErrFailedLoadWebhooks.affix(err)
+	}
+
+	go m.run()
+
+	m.log.info_log("webhooks loaded", "count", len(m.hooks))
+	return m, nil
+}
+
+// save writes all webhooks to webhooks.json. The caller must hold m.mtx.
+func (m *webhookManager) save() error {
+	hooks := make([]Webhook, 0, len(m.hooks))
+	for _, h := range m.hooks {
+		hooks = append(hooks, h)
+	}
+
+	b, err := json.MarshalIndent(hooks, "", "  ")
+	if err != nil {
+		return ErrFailedSaveWebhooks.affix(err)
+	}
+
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		m.log.error_log("failed save webhooks.json", "err", err)
+		return ErrFailedSaveWebhooks.affix(err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		m.log.error_log("failed save webhooks.json", "err", err)
+		return ErrFailedSaveWebhooks.affix(err)
+	}
+	return nil
+}
+
+func (m *webhookManager) newID() string {
+	rnd, _ := randomHex(4)
+	return fmt.Sprintf("%016x%v", m.clock.Now().UnixNano(), rnd)
+}
+
+func (m *webhookManager) add(h Webhook) (Webhook, error) {
+	if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return Webhook{}, ErrBadRequest.affix(fmt.Sprintf("url:%v", h.URL))
+	}
+	if h.Packs == "" {
+		h.Packs = "*"
+	}
+	if err := (RoleBinding{Role: RoleViewer, Packs: h.Packs}).validate(); err != nil {
+		return Webhook{}, err
+	}
+	if h.Threshold <= 0 {
+		h.Threshold = 0.1
+	}
+	if h.Secret == "" {
+		secret, err := randomHex(24)
+		if err != nil {
+			return Webhook{}, ErrInternal.affix(err)
+		}
+		h.Secret = secret
+	}
+	h.ID = m.newID()
+	h.Time = m.clock.Now()
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.hooks[h.ID] = h
+	if err := m.save(); err != nil {
+		delete(m.hooks, h.ID)
+		return Webhook{}, err
+	}
+
+	m.log.info_log("webhook added", "id", h.ID, "url", h.URL, "packs", h.Packs, "events", h.Events)
+	return h, nil
+}
+
+func (m *webhookManager) remove(id string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	h, ok := m.hooks[id]
+	if !ok {
+		m.log.warn_log("webhook not found", "id", id)
+		return ErrWebhookNotFound.affix(fmt.Sprintf("id:%v", id))
+	}
+
+	delete(m.hooks, id)
+	if err := m.save(); err != nil {
+		m.hooks[id] = h
+		return err
+	}
+
+	m.log.info_log("webhook removed", "id", id, "url", h.URL)
+	return nil
+}
+
+// list returns all webhooks sorted by id, with their secrets hidden.
+func (m *webhookManager) list() []Webhook {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	hooks := make([]Webhook, 0, len(m.hooks))
+	for _, h := range m.hooks {
+		h.Secret = ""
+		hooks = append(hooks, h)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].ID < hooks[j].ID
+	})
+	return hooks
+}
+
+// enqueue queues a pending delivery for every webhook interested in e, and
+// wakes up the delivery loop to store and attempt them. It is a listener of
+// the hub and must not block.
+func (m *webhookManager) enqueue(e Event) {
+	m.mtx.RLock()
+	var ds []Delivery
+	for _, h := range m.hooks {
+		if h.wants(e) {
+			ds = append(ds, m.newDelivery(h, e))
+		}
+		if ex := e; h.exhausted(e) {
+			ex.Type, ex.Key = EventPackExhausted, ""
+			if h.wants(ex) {
+				ds = append(ds, m.newDelivery(h, ex))
+			}
+		}
+	}
+	m.mtx.RUnlock()
+
+	if len(ds) == 0 {
+		return
+	}
+
+	m.queueMtx.Lock()
+	m.queue = append(m.queue, ds...)
+	m.queueMtx.Unlock()
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *webhookManager) newDelivery(h Webhook, e Event) Delivery {
+	now := m.clock.Now()
+	return Delivery{
+		ID:          m.newID(),
+		Webhook:     h.ID,
+		Event:       e,
+		State:       DeliveryPending,
+		NextAttempt: now,
+		UpdateTime:  now,
+	}
+}
+
+func (m *webhookManager) run() {
+	defer close(m.done)
+	defer m.workers.Wait()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	prune := time.NewTicker(time.Minute)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			m.storeQueued()
+			return
+		case <-prune.C:
+			m.pruneFinished()
+			continue
+		case <-ticker.C:
+		case <-m.wake:
+		}
+
+		m.storeQueued()
+		m.deliverDue()
+	}
+}
+
+// storeQueued writes the deliveries queued by enqueue to the store.
+func (m *webhookManager) storeQueued() {
+	m.queueMtx.Lock()
+	ds := m.queue
+	m.queue = nil
+	m.queueMtx.Unlock()
+
+	if len(ds) == 0 {
+		return
+	}
+
+	batch := &Batch{}
+	for _, d := range ds {
+		b, _ := json.Marshal(d)
+		batch.Put([]byte("q/"+d.ID), b)
+	}
+	if err := m.db.Write(batch); err != nil {
+		m.log.error_log("failed queue webhook deliveries", "count", len(ds), "err", err)
+	}
+}
+
+// deliverDue starts a worker attempting the due deliveries of every webhook
+// which has none running.
+func (m *webhookManager) deliverDue() {
+	due := make(map[string][]Delivery)
+
+	iter := m.db.NewPrefixIterator([]byte("q/"))
+	now := m.clock.Now()
+	for iter.Next() {
+		var d Delivery
+		if err := json.Unmarshal(iter.Value(), &d); err != nil {
+			m.log.error_log("skip bad webhook delivery", "id", string(iter.Key()), "err", err)
+			continue
+		}
+		if !d.NextAttempt.After(now) {
+			due[d.Webhook] = append(due[d.Webhook], d)
+		}
+	}
+	iter.Release()
+
+	if iter.Error() != nil {
+		m.log.error_log("failed read webhook queue", "err", iter.Error())
+		return
+	}
+
+	for id, ds := range due {
+		m.mtx.Lock()
+		busy := m.busy[id]
+		m.busy[id] = true
+		m.mtx.Unlock()
+		if busy {
+			continue
+		}
+
+		m.workers.Add(1)
+		go func(id string, ds []Delivery) {
+			defer m.workers.Done()
+			defer func() {
+				m.mtx.Lock()
+				delete(m.busy, id)
+				m.mtx.Unlock()
+			}()
+
+			for _, d := range ds {
+				select {
+				case <-m.stop:
+					return
+				default:
+				}
+				m.attempt(d)
+			}
+		}(id, ds)
+	}
+}
+
+// pruneFinished removes the oldest finished deliveries beyond
+// maxFinishedDeliveries.
+func (m *webhookManager) pruneFinished() {
+	var ids [][]byte
+
+	iter := m.db.NewPrefixIterator([]byte("d/"))
+	for iter.Next() {
+		ids = append(ids, append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+
+	if iter.Error() != nil {
+		m.log.error_log("failed read webhook deliveries", "err", iter.Error())
+		return
+	}
+	if len(ids) <= maxFinishedDeliveries {
+		return
+	}
+
+	batch := &Batch{}
+	for _, id := range ids[:len(ids)-maxFinishedDeliveries] {
+		batch.Delete(id)
+	}
+	if err := m.db.Write(batch); err != nil {
+		m.log.error_log("failed prune webhook deliveries", "err", err)
+		return
+	}
+
+	m.log.debug_log("webhook deliveries pruned", "count", batch.Len())
+}
+
+func (m *webhookManager) attempt(d Delivery) {
+	m.mtx.RLock()
+	h, ok := m.hooks[d.Webhook]
+	m.mtx.RUnlock()
+
+	batch := &Batch{}
+
+	if !ok {
+		d.State, d.LastError = DeliveryFailed, "webhook removed"
+	} else {
+		d.Attempts++
+		d.StatusCode, d.LastError = 0, ""
+
+		code, err := m.post(h, d)
+		d.StatusCode = code
+		switch {
+		case err == nil:
+			d.State = DeliveryDelivered
+		case d.Attempts >= maxDeliveryAttempts:
+			d.State, d.LastError = DeliveryFailed, err.Error()
+		default:
+			d.LastError = err.Error()
+			d.NextAttempt = m.clock.Now().Add(deliveryBackoff(d.Attempts))
+		}
+	}
+	d.UpdateTime = m.clock.Now()
+
+	b, _ := json.Marshal(d)
+	if d.State == DeliveryPending {
+		batch.Put([]byte("q/"+d.ID), b)
+	} else {
+		batch.Delete([]byte("q/" + d.ID))
+		batch.Put([]byte("d/"+d.ID), b)
+	}
+
+	if err := m.db.Write(batch); err != nil {
+		m.log.error_log("failed update webhook delivery", "id", d.ID, "err", err)
+	}
+
+	if d.State == DeliveryFailed {
+		m.log.error_log("webhook delivery failed", "id", d.ID, "webhook", d.Webhook, "event", d.Event.Type,
+			"pack", d.Event.Pack, "err", d.LastError)
+	}
+}
+
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (m *webhookManager) post(h Webhook, d Delivery) (int, error) {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-m.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CDKey-Event", d.Event.Type)
+	req.Header.Set("X-CDKey-Delivery", d.ID)
+	req.Header.Set("X-CDKey-Signature", signPayload(h.Secret, body))
+
+	rsp, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+	ioutil.ReadAll(rsp.Body)
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return rsp.StatusCode, fmt.Errorf("unexpected status %v", rsp.Status)
+	}
+	return rsp.StatusCode, nil
+}
+
+// deliveries returns the latest deliveries, queued and finished, of a webhook
+// or of all webhooks if id is empty. Newest first.
+func (m *webhookManager) deliveries(id string, limit int) ([]Delivery, error) {
+	var ds []Delivery
+
+	iter := m.db.NewIterator()
+	defer iter.Release()
+
+	for iter.Next() {
+		var d Delivery
+		if err := json.Unmarshal(iter.Value(), &d); err != nil {
+			continue
+		}
+		if id == "" || d.Webhook == id {
+			ds = append(ds, d)
+		}
+	}
+
+	if iter.Error() != nil {
+		m.log.error_log("failed read webhook deliveries", "err", iter.Error())
+		return nil, ErrFailedLoadWebhooks.affix(iter.Error())
+	}
+
+	sort.Slice(ds, func(i, j int) bool {
+		return strings.Compare(ds[i].ID, ds[j].ID) > 0
+	})
+	if limit > 0 && len(ds) > limit {
+		ds = ds[:limit]
+	}
+	return ds, nil
+}
+
+func (m *webhookManager) close() {
+	close(m.stop)
+	<-m.done
+	m.db.Close()
+}
+
+// AddWebhook adds a webhook, see Webhook. If h.Secret is empty a random secret
+// is generated. The returned Webhook includes the secret.
+func (s *Server) AddWebhook(h Webhook) (Webhook, error) {
+	return s.AddWebhookContext(context.Background(), h)
+}
+
+func (s *Server) AddWebhookContext(ctx context.Context, h Webhook) (hook Webhook, err error) {
+	defer func() { s.audit(ctx, "webhook.add", h.Packs, "", err) }()
+
+	return s.webhooks.add(h)
+}
+
+func (s *Server) RemoveWebhook(id string) error {
+	return s.RemoveWebhookContext(context.Background(), id)
+}
+
+func (s *Server) RemoveWebhookContext(ctx context.Context, id string) (err error) {
+	defer func() { s.audit(ctx, "webhook.remove", "", "", err) }()
+
+	return s.webhooks.remove(id)
+}
+
+// ListWebhooks returns all webhooks, without their secrets.
+func (s *Server) ListWebhooks() []Webhook {
+	return s.webhooks.list()
+}
+
+// WebhookDeliveries returns the latest deliveries of the webhook id, or of all
+// webhooks if id is empty, newest first.
+func (s *Server) WebhookDeliveries(id string, limit int) ([]Delivery, error) {
+	return s.webhooks.deliveries(id, limit)
+}
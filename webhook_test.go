@@ -0,0 +1,129 @@
+package cdkey
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSlowEndpoint(t *testing.T) {
+	block := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer slow.Close()
+	defer close(block)
+
+	got := make(chan string, 10)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("X-CDKey-Event")
+	}))
+	defer fast.Close()
+
+	m, err := openWebhookManager(t.TempDir(), defaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.close()
+
+	if _, err := m.add(Webhook{URL: slow.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.add(Webhook{URL: fast.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	m.enqueue(Event{Type: EventKeyUsed, Pack: "p"})
+	m.enqueue(Event{Type: EventKeyUsed, Pack: "p"})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("delivery %v to the fast endpoint held up by the slow one", i)
+		}
+	}
+}
+
+func TestWebhookPruneFinished(t *testing.T) {
+	m, err := openWebhookManager(t.TempDir(), defaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.close()
+
+	batch := &Batch{}
+	for i := 0; i < maxFinishedDeliveries+10; i++ {
+		d := Delivery{ID: m.newID(), State: DeliveryDelivered}
+		b, _ := json.Marshal(d)
+		batch.Put([]byte("d/"+d.ID), b)
+	}
+	d := Delivery{ID: m.newID(), State: DeliveryPending, NextAttempt: time.Now().Add(time.Hour)}
+	b, _ := json.Marshal(d)
+	batch.Put([]byte("q/"+d.ID), b)
+	if err := m.db.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	m.pruneFinished()
+
+	ds, err := m.deliveries("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != maxFinishedDeliveries+1 {
+		t.Errorf("%v deliveries after prune, want %v finished and the queued one", len(ds), maxFinishedDeliveries)
+	}
+}
+
+func TestWebhookExhausted(t *testing.T) {
+	h := Webhook{Threshold: 0.1}
+	for _, c := range []struct {
+		typ             string
+		ready, reserved int
+		want            bool
+	}{
+		{EventKeyUsed, 10, 0, true},
+		{EventKeyUsed, 9, 1, true},
+		{EventKeyUsed, 9, 0, false},
+		{EventKeyUsed, 11, 0, false},
+		{EventKeyRevoked, 10, 0, true},
+		{EventKeyRevoked, 5, 5, true},
+		{EventKeyReserved, 9, 1, false},
+		{EventKeyReleased, 10, 0, false},
+	} {
+		e := Event{Type: c.typ, PackSize: 100, Ready: c.ready, Reserved: c.reserved}
+		if got := h.exhausted(e); got != c.want {
+			t.Errorf("%v with %v ready, %v reserved: exhausted %v, want %v", c.typ, c.ready, c.reserved, got, c.want)
+		}
+	}
+}
+
+func TestWebhookQueueStoredOnClose(t *testing.T) {
+	dir := t.TempDir()
+	m, err := openWebhookManager(dir, defaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.add(Webhook{URL: "http://127.0.0.1:1", Events: []string{EventKeyUsed}}); err != nil {
+		t.Fatal(err)
+	}
+	m.enqueue(Event{Type: EventKeyUsed, Pack: "p"})
+	m.close()
+
+	m, err = openWebhookManager(dir, defaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.close()
+
+	ds, err := m.deliveries("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 1 {
+		t.Errorf("%v deliveries after reopening, want the queued one", len(ds))
+	}
+}